@@ -0,0 +1,185 @@
+package acmerenew
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"golang.org/x/crypto/acme"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"strings"
+)
+
+const (
+	LetsEncryptURL = acme.LetsEncryptURL
+	// AccountKeyKey is the key in the account secret that holds the ACME account's private key
+	AccountKeyKey = "account.key"
+)
+
+/**
+loads the ACME account key from the secret given as "namespace/name", generating a new one and saving it there if the
+secret doesn't exist yet.  Keeping the key means that we re-use the same account on every run
+*/
+func LoadAccountKey(ctx context.Context, clientset kubernetes.Interface, secretRef string) (crypto.Signer, error) {
+	parts := strings.SplitN(secretRef, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("ACME account secret '%s' should be given as namespace/name", secretRef)
+	}
+	client := clientset.CoreV1().Secrets(parts[0])
+
+	secret, getErr := client.Get(ctx, parts[1], metav1.GetOptions{})
+	if getErr == nil {
+		return certs.LoadPrivateKey(secret.Data[AccountKeyKey])
+	}
+	if !apierrors.IsNotFound(getErr) {
+		return nil, getErr
+	}
+
+	log.Printf("INFO Creating a new ACME account key in %s", secretRef)
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	keyDER, marshalErr := x509.MarshalPKCS8PrivateKey(key)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	_, createErr := client.Create(ctx, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: parts[0], Name: parts[1]},
+		Type:       v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			AccountKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		},
+	}, metav1.CreateOptions{})
+	if createErr != nil {
+		return nil, fmt.Errorf("could not save the new ACME account key: %s", createErr)
+	}
+	return key, nil
+}
+
+/**
+returns a client for the ACME directory at `directoryURL`, registering the account for the key (and agreeing to the
+CA's terms of service) if it isn't registered already
+*/
+func NewClient(ctx context.Context, directoryURL string, accountKey crypto.Signer, email string) (*acme.Client, error) {
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: directoryURL,
+		UserAgent:    "k8s-certchecker",
+	}
+	account := &acme.Account{}
+	if email != "" {
+		account.Contact = []string{"mailto:" + email}
+	}
+	_, regErr := client.Register(ctx, account, acme.AcceptTOS)
+	if regErr != nil && !errors.Is(regErr, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("could not register with %s: %s", directoryURL, regErr)
+	}
+	return client, nil
+}
+
+/**
+solves one pending authorization with the solver, cleaning up after itself whether or not it succeeded
+*/
+func authorize(ctx context.Context, client *acme.Client, solver Solver, authz *acme.Authorization) error {
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == solver.ChallengeType() {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("the CA did not offer a %s challenge for %s", solver.ChallengeType(), authz.Identifier.Value)
+	}
+
+	var response string
+	var responseErr error
+	if challenge.Type == DNS01 {
+		response, responseErr = client.DNS01ChallengeRecord(challenge.Token)
+	} else {
+		response, responseErr = client.HTTP01ChallengeResponse(challenge.Token)
+	}
+	if responseErr != nil {
+		return responseErr
+	}
+
+	req := &ChallengeRequest{
+		Domain:   authz.Identifier.Value,
+		Token:    challenge.Token,
+		Response: response,
+	}
+	if presentErr := solver.Present(ctx, req); presentErr != nil {
+		return presentErr
+	}
+	defer func() {
+		if cleanupErr := solver.CleanUp(ctx, req); cleanupErr != nil {
+			log.Printf("WARNING Could not clean up the %s challenge for %s: %s", challenge.Type, req.Domain, cleanupErr)
+		}
+	}()
+
+	if _, acceptErr := client.Accept(ctx, challenge); acceptErr != nil {
+		return fmt.Errorf("could not accept the challenge for %s: %s", req.Domain, acceptErr)
+	}
+	if _, waitErr := client.WaitAuthorization(ctx, authz.URI); waitErr != nil {
+		return fmt.Errorf("could not validate %s: %s", req.Domain, waitErr)
+	}
+	return nil
+}
+
+/**
+orders a new certificate for the domains from the CA, proving control of each of them with the solver, and returns
+the PEM-encoded chain
+*/
+func Obtain(ctx context.Context, client *acme.Client, solver Solver, domains []string, key crypto.Signer) ([]byte, error) {
+	order, orderErr := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if orderErr != nil {
+		return nil, fmt.Errorf("could not create an order: %s", orderErr)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, authzErr := client.GetAuthorization(ctx, authzURL)
+		if authzErr != nil {
+			return nil, authzErr
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		if err := authorize(ctx, client, solver, authz); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, waitErr := client.WaitOrder(ctx, order.URI); waitErr != nil {
+		return nil, fmt.Errorf("the order did not become ready: %s", waitErr)
+	}
+
+	csr, csrErr := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if csrErr != nil {
+		return nil, csrErr
+	}
+	chain, _, certErr := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if certErr != nil {
+		return nil, fmt.Errorf("could not finalize the order: %s", certErr)
+	}
+
+	var chainPEM []byte
+	for _, der := range chain {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return chainPEM, nil
+}
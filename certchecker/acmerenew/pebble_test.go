@@ -0,0 +1,419 @@
+package acmerenew
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+testCA is a minimal ACME server in the style of Pebble, for testing the renewal flow without a network.  It checks
+the signature on every request, validates the challenges with the functions it is given and signs the certs it
+issues with its own CA
+*/
+type testCA struct {
+	t      *testing.T
+	server *httptest.Server
+	mutex  sync.Mutex
+
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+	caPEM  []byte
+
+	accounts map[string]*ecdsa.PublicKey
+	orders   map[string]*testOrder
+	authzs   map[string]*testAuthz
+	certs    map[string][]byte
+	nextID   int
+
+	//validateHTTP01 returns what is served at the challenge path for the domain
+	validateHTTP01 func(domain string, token string) string
+	//validateDNS01 returns the TXT record value at the fqdn
+	validateDNS01 func(fqdn string) string
+}
+
+type testAuthz struct {
+	Domain     string
+	Status     string
+	Thumbprint string
+	Challenges []*testChallenge
+}
+
+type testChallenge struct {
+	ID     string
+	Type   string
+	Token  string
+	Status string
+}
+
+type testOrder struct {
+	Domains  []string
+	AuthzIDs []string
+	Status   string
+	CertID   string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Pebble Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	caCert, _ := x509.ParseCertificate(der)
+
+	ca := &testCA{
+		t:        t,
+		caCert:   caCert,
+		caKey:    key,
+		caPEM:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		accounts: make(map[string]*ecdsa.PublicKey),
+		orders:   make(map[string]*testOrder),
+		authzs:   make(map[string]*testAuthz),
+		certs:    make(map[string][]byte),
+	}
+	ca.server = httptest.NewServer(http.HandlerFunc(ca.serve))
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *testCA) DirectoryURL() string {
+	return ca.server.URL + "/dir"
+}
+
+func (ca *testCA) newID() string {
+	ca.nextID++
+	return fmt.Sprintf("%d", ca.nextID)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+type testJWK struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *testJWK) publicKey() (*ecdsa.PublicKey, error) {
+	x, xErr := base64.RawURLEncoding.DecodeString(k.X)
+	y, yErr := base64.RawURLEncoding.DecodeString(k.Y)
+	if k.Kty != "EC" || k.Crv != "P-256" || xErr != nil || yErr != nil {
+		return nil, fmt.Errorf("unsupported account key %+v", k)
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+/**
+RFC 7638 thumbprint of an account key, which is part of every key authorization
+*/
+func thumbprint(key *ecdsa.PublicKey) string {
+	jwk := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, b64(key.X.FillBytes(make([]byte, 32))), b64(key.Y.FillBytes(make([]byte, 32))))
+	sum := sha256.Sum256([]byte(jwk))
+	return b64(sum[:])
+}
+
+/**
+checks the JWS signature on a request and returns its payload and the account key that signed it
+*/
+func (ca *testCA) verify(r *http.Request) ([]byte, *ecdsa.PublicKey, error) {
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, nil, err
+	}
+	protected, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var header struct {
+		Alg string   `json:"alg"`
+		JWK *testJWK `json:"jwk"`
+		Kid string   `json:"kid"`
+		URL string   `json:"url"`
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, nil, err
+	}
+	if header.URL != ca.server.URL+r.URL.Path {
+		return nil, nil, fmt.Errorf("signed for %s but sent to %s", header.URL, r.URL.Path)
+	}
+
+	var key *ecdsa.PublicKey
+	if header.JWK != nil {
+		var keyErr error
+		if key, keyErr = header.JWK.publicKey(); keyErr != nil {
+			return nil, nil, keyErr
+		}
+	} else if key = ca.accounts[header.Kid]; key == nil {
+		return nil, nil, fmt.Errorf("unknown account %s", header.Kid)
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if header.Alg != "ES256" || len(signature) != 64 ||
+		!ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return nil, nil, fmt.Errorf("bad signature")
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, key, nil
+}
+
+func (ca *testCA) writeJSON(w http.ResponseWriter, status int, location string, value interface{}) {
+	if location != "" {
+		w.Header().Set("Location", ca.server.URL+location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func (ca *testCA) problem(w http.ResponseWriter, status int, detail string) {
+	ca.t.Logf("test CA: %s", detail)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": "urn:ietf:params:acme:error:malformed", "detail": detail})
+}
+
+func (ca *testCA) serve(w http.ResponseWriter, r *http.Request) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	w.Header().Set("Replay-Nonce", b64([]byte(ca.newID())))
+
+	if r.URL.Path == "/dir" {
+		ca.writeJSON(w, http.StatusOK, "", map[string]string{
+			"newNonce":   ca.server.URL + "/nonce",
+			"newAccount": ca.server.URL + "/new-account",
+			"newOrder":   ca.server.URL + "/new-order",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payload, key, verifyErr := ca.verify(r)
+	if verifyErr != nil {
+		ca.problem(w, http.StatusBadRequest, verifyErr.Error())
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	id := ""
+	if len(parts) > 1 {
+		id = parts[1]
+	}
+
+	switch parts[0] {
+	case "new-account":
+		kid := "/acct/" + thumbprint(key)
+		status := http.StatusOK
+		if _, exists := ca.accounts[ca.server.URL+kid]; !exists {
+			ca.accounts[ca.server.URL+kid] = key
+			status = http.StatusCreated
+		}
+		ca.writeJSON(w, status, kid, map[string]string{"status": "valid"})
+	case "new-order":
+		ca.newOrder(w, payload, key)
+	case "order":
+		ca.writeJSON(w, http.StatusOK, "/order/"+id, ca.orderJSON(id))
+	case "authz":
+		ca.writeJSON(w, http.StatusOK, "", ca.authzJSON(id))
+	case "chal":
+		ca.accept(w, id)
+	case "finalize":
+		ca.finalize(w, id, payload)
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.certs[id])
+	default:
+		ca.problem(w, http.StatusNotFound, "no such resource "+r.URL.Path)
+	}
+}
+
+func (ca *testCA) newOrder(w http.ResponseWriter, payload []byte, key *ecdsa.PublicKey) {
+	var req struct {
+		Identifiers []struct{ Type, Value string }
+	}
+	json.Unmarshal(payload, &req)
+
+	order := &testOrder{Status: "pending"}
+	for _, identifier := range req.Identifiers {
+		authzID := ca.newID()
+		authz := &testAuthz{Domain: identifier.Value, Status: "pending", Thumbprint: thumbprint(key)}
+		challengeTypes := []string{DNS01}
+		if !strings.HasPrefix(identifier.Value, "*.") {
+			challengeTypes = append(challengeTypes, HTTP01)
+		}
+		for _, challengeType := range challengeTypes {
+			token := make([]byte, 16)
+			rand.Read(token)
+			authz.Challenges = append(authz.Challenges, &testChallenge{ID: authzID + "-" + challengeType, Type: challengeType, Token: b64(token), Status: "pending"})
+		}
+		ca.authzs[authzID] = authz
+		order.Domains = append(order.Domains, identifier.Value)
+		order.AuthzIDs = append(order.AuthzIDs, authzID)
+	}
+	orderID := ca.newID()
+	ca.orders[orderID] = order
+	ca.writeJSON(w, http.StatusCreated, "/order/"+orderID, ca.orderJSON(orderID))
+}
+
+func (ca *testCA) orderJSON(id string) map[string]interface{} {
+	order := ca.orders[id]
+	if order.Status == "pending" {
+		order.Status = "ready"
+		for _, authzID := range order.AuthzIDs {
+			if ca.authzs[authzID].Status != "valid" {
+				order.Status = "pending"
+			}
+		}
+	}
+	value := map[string]interface{}{
+		"status":   order.Status,
+		"finalize": ca.server.URL + "/finalize/" + id,
+	}
+	var authzURLs []string
+	var identifiers []map[string]string
+	for i, authzID := range order.AuthzIDs {
+		authzURLs = append(authzURLs, ca.server.URL+"/authz/"+authzID)
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": order.Domains[i]})
+	}
+	value["authorizations"] = authzURLs
+	value["identifiers"] = identifiers
+	if order.CertID != "" {
+		value["certificate"] = ca.server.URL + "/cert/" + order.CertID
+	}
+	return value
+}
+
+func (ca *testCA) challengeJSON(challenge *testChallenge) map[string]string {
+	return map[string]string{
+		"type":   challenge.Type,
+		"url":    ca.server.URL + "/chal/" + challenge.ID,
+		"token":  challenge.Token,
+		"status": challenge.Status,
+	}
+}
+
+func (ca *testCA) authzJSON(id string) map[string]interface{} {
+	authz := ca.authzs[id]
+	var challenges []map[string]string
+	for _, challenge := range authz.Challenges {
+		challenges = append(challenges, ca.challengeJSON(challenge))
+	}
+	return map[string]interface{}{
+		"identifier": map[string]string{"type": "dns", "value": strings.TrimPrefix(authz.Domain, "*.")},
+		"status":     authz.Status,
+		"wildcard":   strings.HasPrefix(authz.Domain, "*."),
+		"challenges": challenges,
+	}
+}
+
+/**
+validates a challenge straight away, rather than asynchronously as a real CA would
+*/
+func (ca *testCA) accept(w http.ResponseWriter, id string) {
+	authz := ca.authzs[strings.SplitN(id, "-", 2)[0]]
+	for _, challenge := range authz.Challenges {
+		if challenge.ID != id {
+			continue
+		}
+		keyAuth := challenge.Token + "." + authz.Thumbprint
+		valid := false
+		switch challenge.Type {
+		case HTTP01:
+			valid = ca.validateHTTP01 != nil && ca.validateHTTP01(authz.Domain, challenge.Token) == keyAuth
+		case DNS01:
+			sum := sha256.Sum256([]byte(keyAuth))
+			valid = ca.validateDNS01 != nil && ca.validateDNS01("_acme-challenge."+strings.TrimPrefix(authz.Domain, "*.")+".") == b64(sum[:])
+		}
+		if valid {
+			challenge.Status, authz.Status = "valid", "valid"
+		} else {
+			challenge.Status, authz.Status = "invalid", "invalid"
+		}
+		ca.writeJSON(w, http.StatusOK, "", ca.challengeJSON(challenge))
+		return
+	}
+	ca.problem(w, http.StatusNotFound, "no such challenge "+id)
+}
+
+func (ca *testCA) finalize(w http.ResponseWriter, id string, payload []byte) {
+	order := ca.orders[id]
+	if ca.orderJSON(id)["status"] != "ready" {
+		ca.problem(w, http.StatusForbidden, "order is not ready")
+		return
+	}
+	var req struct{ CSR string }
+	json.Unmarshal(payload, &req)
+	der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+	csr, csrErr := x509.ParseCertificateRequest(der)
+	if csrErr != nil || csr.CheckSignature() != nil {
+		ca.problem(w, http.StatusBadRequest, "bad CSR")
+		return
+	}
+	if strings.Join(csr.DNSNames, ",") != strings.Join(order.Domains, ",") {
+		ca.problem(w, http.StatusBadRequest, fmt.Sprintf("CSR is for %v but the order is for %v", csr.DNSNames, order.Domains))
+		return
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(ca.nextID + 100)),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().AddDate(0, 0, 90),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, signErr := x509.CreateCertificate(rand.Reader, template, ca.caCert, csr.PublicKey, ca.caKey)
+	if signErr != nil {
+		ca.problem(w, http.StatusInternalServerError, signErr.Error())
+		return
+	}
+	order.CertID = ca.newID()
+	order.Status = "valid"
+	ca.certs[order.CertID] = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), ca.caPEM...)
+	ca.writeJSON(w, http.StatusOK, "/order/"+id, ca.orderJSON(id))
+}
+
+/**
+an in-memory DNSProvider
+*/
+type memoryDNS struct {
+	records map[string]string
+}
+
+func (m *memoryDNS) Present(ctx context.Context, fqdn string, value string) error {
+	m.records[fqdn] = value
+	return nil
+}
+
+func (m *memoryDNS) CleanUp(ctx context.Context, fqdn string, value string) error {
+	delete(m.records, fqdn)
+	return nil
+}
@@ -0,0 +1,157 @@
+package acmerenew
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/certchecker/reissue"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"golang.org/x/crypto/acme"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"time"
+)
+
+const (
+	// RenewAnnotation must be set to "true" on a secret before certchecker will renew its certificate with ACME
+	RenewAnnotation = "certchecker.guardian.co.uk/acme-renew"
+
+	RemediationAction = "acme-renew"
+)
+
+/**
+Renewer renews the near-expiry certificates in opted-in secrets from an ACME CA such as Let's Encrypt.  As with
+re-issuing, the old data is backed up to a secret alongside before it is replaced
+*/
+type Renewer struct {
+	Clientset kubernetes.Interface
+	Client    *acme.Client
+	Solver    Solver
+	DryRun    bool
+	Now       func() time.Time
+}
+
+func NewRenewer(clientset kubernetes.Interface, client *acme.Client, solver Solver, dryRun bool) *Renewer {
+	return &Renewer{
+		Clientset: clientset,
+		Client:    client,
+		Solver:    solver,
+		DryRun:    dryRun,
+		Now:       time.Now,
+	}
+}
+
+/**
+the names to put on the renewed cert.  ACME CAs only validate DNS names, so IP addresses can't be carried over
+*/
+func domainsFor(original *x509.Certificate) ([]string, error) {
+	if len(original.IPAddresses) > 0 || len(original.URIs) > 0 || len(original.EmailAddresses) > 0 {
+		return nil, errors.New("the certificate has IP, URI or email SANs, which can't be validated with ACME")
+	}
+	domains := original.DNSNames
+	if len(domains) == 0 && original.Subject.CommonName != "" {
+		domains = []string{original.Subject.CommonName}
+	}
+	if len(domains) == 0 {
+		return nil, errors.New("the certificate has no DNS names to renew")
+	}
+	return domains, nil
+}
+
+/**
+renews every opted-in secret in the results that needs it, returning a record of what was done for each one
+*/
+func (r *Renewer) RenewAll(ctx context.Context, results []datapersistence.CheckRecord) []datapersistence.RemediationRecord {
+	remediations := make([]datapersistence.RemediationRecord, 0)
+	for i := range results {
+		rec := &results[i]
+		if !reissue.NeedsReplacing(rec) {
+			continue
+		}
+		remediation, attempted := r.renewSecret(ctx, rec)
+		if !attempted {
+			continue
+		}
+		if remediation.Error != "" {
+			log.Printf("ERROR Could not renew %s with ACME: %s", rec.Source, remediation.Error)
+		} else {
+			log.Printf("INFO %s: %s", rec.Source, remediation.Details)
+		}
+		remediations = append(remediations, *remediation)
+	}
+	return remediations
+}
+
+/**
+renews a single secret.  The boolean is false if the secret hasn't opted in, in which case nothing is recorded
+*/
+func (r *Renewer) renewSecret(ctx context.Context, rec *datapersistence.CheckRecord) (*datapersistence.RemediationRecord, bool) {
+	now := r.Now()
+	remediation := &datapersistence.RemediationRecord{
+		Cluster: rec.Cluster,
+		Source:  rec.Source,
+		Action:  RemediationAction,
+		At:      now,
+		DryRun:  r.DryRun,
+	}
+	fail := func(err error) (*datapersistence.RemediationRecord, bool) {
+		remediation.Error = err.Error()
+		return remediation, true
+	}
+
+	secret, getErr := r.Clientset.CoreV1().Secrets(rec.Source.Namespace).Get(ctx, rec.Source.Name, metav1.GetOptions{})
+	if getErr != nil {
+		return fail(getErr)
+	}
+	if secret.Annotations[RenewAnnotation] != "true" {
+		return nil, false
+	}
+
+	original, _, loadErr := certs.LoadCert(secret.Data[v1.TLSCertKey], rec.Source.String())
+	if loadErr != nil {
+		return fail(loadErr)
+	}
+	domains, domainsErr := domainsFor(original)
+	if domainsErr != nil {
+		return fail(domainsErr)
+	}
+
+	if r.DryRun {
+		remediation.Details = fmt.Sprintf("would renew %v with a %s challenge, backing up the old data (dry run)", domains, r.Solver.ChallengeType())
+		return remediation, true
+	}
+
+	key, keyErr := reissue.NewKeyLike(original.PublicKey)
+	if keyErr != nil {
+		return fail(keyErr)
+	}
+	chainPEM, obtainErr := Obtain(ctx, r.Client, r.Solver, domains, key)
+	if obtainErr != nil {
+		return fail(obtainErr)
+	}
+	newCert, _, parseErr := certs.LoadCert(chainPEM, rec.Source.String())
+	if parseErr != nil {
+		return fail(fmt.Errorf("the CA returned an unreadable certificate: %s", parseErr))
+	}
+	keyDER, marshalErr := x509.MarshalPKCS8PrivateKey(key)
+	if marshalErr != nil {
+		return fail(marshalErr)
+	}
+	remediation.NewValidUntil = &newCert.NotAfter
+
+	backup, replaceErr := reissue.ReplaceData(ctx, r.Clientset, secret, map[string][]byte{
+		v1.TLSCertKey:       chainPEM,
+		v1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, now)
+	if replaceErr != nil {
+		return fail(replaceErr)
+	}
+
+	remediation.Details = fmt.Sprintf("renewed with ACME until %s, old data backed up to %s", newCert.NotAfter.Format(time.RFC3339), backup)
+	return remediation, true
+}
@@ -0,0 +1,208 @@
+package acmerenew
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/certchecker/reissue"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+a secret holding a self-signed cert for the domains that expires in a few days
+*/
+func makeLegacySecret(t *testing.T, name string, domains []string, optIn bool) *v1.Secret {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		NotBefore:    time.Now().AddDate(0, 0, -85),
+		NotAfter:     time.Now().AddDate(0, 0, 5),
+		DNSNames:     domains,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "legacy", Name: name, Annotations: map[string]string{}},
+		Type:       v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			v1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+	if optIn {
+		secret.Annotations[RenewAnnotation] = "true"
+	}
+	return secret
+}
+
+func nearExpiryRecord(name string) datapersistence.CheckRecord {
+	return datapersistence.CheckRecord{
+		Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "legacy", Name: name, DataKey: v1.TLSCertKey},
+		CheckResult: datapersistence.NearExpiry,
+	}
+}
+
+func newTestRenewer(t *testing.T, ca *testCA, clientset *fake.Clientset, solver Solver) *Renewer {
+	ctx := context.Background()
+	accountKey, keyErr := LoadAccountKey(ctx, clientset, "certchecker/acme-account")
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+	client, clientErr := NewClient(ctx, ca.DirectoryURL(), accountKey, "ops@example.com")
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	return NewRenewer(clientset, client, solver, false)
+}
+
+func checkRenewed(t *testing.T, clientset *fake.Clientset, ca *testCA, name string, domains []string) {
+	updated, _ := clientset.CoreV1().Secrets("legacy").Get(context.Background(), name, metav1.GetOptions{})
+	renewed, _, loadErr := certs.LoadCert(updated.Data[v1.TLSCertKey], name)
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	if renewed.CheckSignatureFrom(ca.caCert) != nil {
+		t.Errorf("expected the new cert to be issued by the test CA, got %s", renewed.Issuer)
+	}
+	if strings.Join(renewed.DNSNames, ",") != strings.Join(domains, ",") {
+		t.Errorf("expected the new cert to be for %v, got %v", domains, renewed.DNSNames)
+	}
+	if !strings.Contains(string(updated.Data[v1.TLSCertKey]), strings.TrimSpace(string(ca.caPEM))) {
+		t.Error("expected the CA's chain to be included in tls.crt")
+	}
+	key, keyErr := certs.LoadPrivateKey(updated.Data[v1.TLSPrivateKeyKey])
+	if keyErr != nil || !certs.KeyMatchesCert(renewed, key) {
+		t.Errorf("expected tls.key to match the new cert (%v)", keyErr)
+	}
+
+	backupName := updated.Annotations[reissue.BackupAnnotation]
+	backup, backupErr := clientset.CoreV1().Secrets("legacy").Get(context.Background(), backupName, metav1.GetOptions{})
+	if backupErr != nil {
+		t.Fatalf("expected a backup called %s: %s", backupName, backupErr)
+	}
+	if backup.Type != reissue.BackupSecretType {
+		t.Errorf("expected the backup to have type %s, got %s", reissue.BackupSecretType, backup.Type)
+	}
+}
+
+func TestRenewHTTP01(t *testing.T) {
+	ca := newTestCA(t)
+	domains := []string{"shop.example.com", "www.shop.example.com"}
+	clientset := fake.NewSimpleClientset(
+		makeLegacySecret(t, "shop-tls", domains, true),
+		makeLegacySecret(t, "other-tls", []string{"other.example.com"}, false),
+	)
+
+	server := NewChallengeServer()
+	//stands in for the ingress controller: the challenge is only reachable if an ingress routes it to our server
+	ca.validateHTTP01 = func(domain string, token string) string {
+		ingresses, _ := clientset.NetworkingV1().Ingresses("certchecker").List(context.Background(), metav1.ListOptions{})
+		for _, ingress := range ingresses.Items {
+			rule := ingress.Spec.Rules[0]
+			path := rule.HTTP.Paths[0]
+			if rule.Host == domain && path.Path == ChallengePathPrefix+token && path.Backend.Service.Name == "certchecker-acme" {
+				response := httptest.NewRecorder()
+				server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path.Path, nil))
+				return response.Body.String()
+			}
+		}
+		return ""
+	}
+	solver := &HTTP01Solver{
+		Clientset:   clientset,
+		Server:      server,
+		Namespace:   "certchecker",
+		ServiceName: "certchecker-acme",
+		ServicePort: 8089,
+	}
+
+	renewer := newTestRenewer(t, ca, clientset, solver)
+	remediations := renewer.RenewAll(context.Background(), []datapersistence.CheckRecord{nearExpiryRecord("shop-tls"), nearExpiryRecord("other-tls")})
+	if len(remediations) != 1 {
+		t.Fatalf("expected only the opted-in secret to be renewed, got %d remediations: %v", len(remediations), remediations)
+	}
+	if remediations[0].Error != "" || remediations[0].Action != RemediationAction || remediations[0].NewValidUntil == nil {
+		t.Errorf("unexpected remediation %+v", remediations[0])
+	}
+	checkRenewed(t, clientset, ca, "shop-tls", domains)
+
+	ingresses, _ := clientset.NetworkingV1().Ingresses("certchecker").List(context.Background(), metav1.ListOptions{})
+	if len(ingresses.Items) != 0 {
+		t.Errorf("expected the challenge ingresses to be removed, got %d", len(ingresses.Items))
+	}
+	if len(server.responses) != 0 {
+		t.Errorf("expected no challenges to be left on the server, got %v", server.responses)
+	}
+
+	//a second run re-uses the saved account
+	if _, err := LoadAccountKey(context.Background(), clientset, "certchecker/acme-account"); err != nil {
+		t.Error(err)
+	}
+	if len(ca.accounts) != 1 {
+		t.Errorf("expected one ACME account, got %d", len(ca.accounts))
+	}
+}
+
+func TestRenewDNS01Wildcard(t *testing.T) {
+	ca := newTestCA(t)
+	domains := []string{"*.apps.example.com"}
+	clientset := fake.NewSimpleClientset(makeLegacySecret(t, "wildcard-tls", domains, true))
+
+	dns := &memoryDNS{records: make(map[string]string)}
+	ca.validateDNS01 = func(fqdn string) string {
+		return dns.records[fqdn]
+	}
+
+	renewer := newTestRenewer(t, ca, clientset, &DNS01Solver{Provider: dns})
+	remediations := renewer.RenewAll(context.Background(), []datapersistence.CheckRecord{nearExpiryRecord("wildcard-tls")})
+	if len(remediations) != 1 || remediations[0].Error != "" {
+		t.Fatalf("expected the wildcard to be renewed, got %+v", remediations)
+	}
+	checkRenewed(t, clientset, ca, "wildcard-tls", domains)
+	if len(dns.records) != 0 {
+		t.Errorf("expected the TXT records to be cleaned up, got %v", dns.records)
+	}
+}
+
+func TestRenewFailedChallengeLeavesSecret(t *testing.T) {
+	ca := newTestCA(t)
+	clientset := fake.NewSimpleClientset(makeLegacySecret(t, "shop-tls", []string{"shop.example.com"}, true))
+	original, _ := clientset.CoreV1().Secrets("legacy").Get(context.Background(), "shop-tls", metav1.GetOptions{})
+
+	//the CA can't see the TXT records, e.g. they were created in the wrong zone
+	ca.validateDNS01 = func(fqdn string) string {
+		return ""
+	}
+	renewer := newTestRenewer(t, ca, clientset, &DNS01Solver{Provider: &memoryDNS{records: make(map[string]string)}})
+	remediations := renewer.RenewAll(context.Background(), []datapersistence.CheckRecord{nearExpiryRecord("shop-tls")})
+	if len(remediations) != 1 || remediations[0].Error == "" {
+		t.Fatalf("expected a failed remediation, got %+v", remediations)
+	}
+
+	after, _ := clientset.CoreV1().Secrets("legacy").Get(context.Background(), "shop-tls", metav1.GetOptions{})
+	if string(after.Data[v1.TLSCertKey]) != string(original.Data[v1.TLSCertKey]) {
+		t.Error("expected the secret to be left alone when renewal fails")
+	}
+	secrets, _ := clientset.CoreV1().Secrets("legacy").List(context.Background(), metav1.ListOptions{})
+	if len(secrets.Items) != 1 {
+		t.Errorf("expected no backup when nothing was replaced, got %d secrets", len(secrets.Items))
+	}
+}
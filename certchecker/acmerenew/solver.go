@@ -0,0 +1,218 @@
+package acmerenew
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	HTTP01 = "http-01"
+	DNS01  = "dns-01"
+
+	// ChallengePathPrefix is where the CA looks for HTTP-01 responses
+	ChallengePathPrefix = "/.well-known/acme-challenge/"
+)
+
+/**
+ChallengeRequest is everything a Solver needs to satisfy one challenge for one domain
+*/
+type ChallengeRequest struct {
+	Domain string
+	Token  string
+	// Response is the body to serve for an HTTP-01 challenge or the TXT record value for a DNS-01 challenge
+	Response string
+}
+
+/**
+Solver proves to the CA that we control a domain, by setting up the response to a challenge before it is accepted
+and removing it again afterwards
+*/
+type Solver interface {
+	// ChallengeType is the type of challenge that this solver can satisfy, HTTP01 or DNS01
+	ChallengeType() string
+	Present(ctx context.Context, req *ChallengeRequest) error
+	CleanUp(ctx context.Context, req *ChallengeRequest) error
+}
+
+/**
+ChallengeServer serves the responses to HTTP-01 challenges that are currently being solved.  It must be reachable
+through the Service that HTTP01Solver routes challenges to
+*/
+type ChallengeServer struct {
+	mutex     sync.RWMutex
+	responses map[string]string
+}
+
+func NewChallengeServer() *ChallengeServer {
+	return &ChallengeServer{responses: make(map[string]string)}
+}
+
+func (s *ChallengeServer) add(token string, response string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.responses[token] = response
+}
+
+func (s *ChallengeServer) remove(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.responses, token)
+}
+
+func (s *ChallengeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, ChallengePathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	s.mutex.RLock()
+	response, ok := s.responses[strings.TrimPrefix(r.URL.Path, ChallengePathPrefix)]
+	s.mutex.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(response))
+}
+
+/**
+HTTP01Solver answers HTTP-01 challenges from a ChallengeServer, by creating a temporary Ingress that routes the
+challenge path for the domain to the Service in front of it
+*/
+type HTTP01Solver struct {
+	Clientset kubernetes.Interface
+	Server    *ChallengeServer
+	// Namespace, ServiceName and ServicePort identify the Service in front of the ChallengeServer
+	Namespace    string
+	ServiceName  string
+	ServicePort  int32
+	IngressClass string
+}
+
+func (s *HTTP01Solver) ChallengeType() string {
+	return HTTP01
+}
+
+func challengeIngressName(req *ChallengeRequest) string {
+	hash := sha1.Sum([]byte(req.Domain + "/" + req.Token))
+	return "certchecker-acme-" + hex.EncodeToString(hash[:])[:10]
+}
+
+func (s *HTTP01Solver) ingressFor(req *ChallengeRequest) *networkingv1.Ingress {
+	pathType := networkingv1.PathTypeExact
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      challengeIngressName(req),
+			Namespace: s.Namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "certchecker"},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: req.Domain,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{
+							Path:     ChallengePathPrefix + req.Token,
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: s.ServiceName,
+									Port: networkingv1.ServiceBackendPort{Number: s.ServicePort},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+	}
+	if s.IngressClass != "" {
+		ingress.Spec.IngressClassName = &s.IngressClass
+	}
+	return ingress
+}
+
+func (s *HTTP01Solver) Present(ctx context.Context, req *ChallengeRequest) error {
+	s.Server.add(req.Token, req.Response)
+	_, err := s.Clientset.NetworkingV1().Ingresses(s.Namespace).Create(ctx, s.ingressFor(req), metav1.CreateOptions{})
+	if err != nil {
+		s.Server.remove(req.Token)
+		return fmt.Errorf("could not create the challenge ingress for %s: %s", req.Domain, err)
+	}
+	return nil
+}
+
+func (s *HTTP01Solver) CleanUp(ctx context.Context, req *ChallengeRequest) error {
+	s.Server.remove(req.Token)
+	return s.Clientset.NetworkingV1().Ingresses(s.Namespace).Delete(ctx, challengeIngressName(req), metav1.DeleteOptions{})
+}
+
+/**
+DNSProvider creates and removes the TXT records for DNS-01 challenges in a particular DNS service
+*/
+type DNSProvider interface {
+	Present(ctx context.Context, fqdn string, value string) error
+	CleanUp(ctx context.Context, fqdn string, value string) error
+}
+
+/**
+DNS01Solver answers DNS-01 challenges by creating a TXT record at `_acme-challenge.<domain>` with a DNSProvider
+*/
+type DNS01Solver struct {
+	Provider DNSProvider
+}
+
+func (s *DNS01Solver) ChallengeType() string {
+	return DNS01
+}
+
+/**
+the name of the TXT record for a domain.  Wildcards are validated against the domain itself
+*/
+func challengeRecordName(domain string) string {
+	return "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+}
+
+func (s *DNS01Solver) Present(ctx context.Context, req *ChallengeRequest) error {
+	return s.Provider.Present(ctx, challengeRecordName(req.Domain), req.Response)
+}
+
+func (s *DNS01Solver) CleanUp(ctx context.Context, req *ChallengeRequest) error {
+	return s.Provider.CleanUp(ctx, challengeRecordName(req.Domain), req.Response)
+}
+
+/**
+ExecDNSProvider runs an external command to manage TXT records, so that any DNS service can be used without building
+support for it in.  The command is run as `<command> present|cleanup <fqdn> <value>` and must not return until the
+record has been created or removed
+*/
+type ExecDNSProvider struct {
+	Command string
+}
+
+func (p *ExecDNSProvider) run(ctx context.Context, action string, fqdn string, value string) error {
+	output, err := exec.CommandContext(ctx, p.Command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		log.Printf("ERROR %s %s %s failed: %s", p.Command, action, fqdn, string(output))
+		return fmt.Errorf("could not %s the TXT record for %s: %s", action, fqdn, err)
+	}
+	return nil
+}
+
+func (p *ExecDNSProvider) Present(ctx context.Context, fqdn string, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *ExecDNSProvider) CleanUp(ctx context.Context, fqdn string, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
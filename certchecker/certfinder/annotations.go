@@ -0,0 +1,63 @@
+package certfinder
+
+import (
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"strconv"
+)
+
+const (
+	WarningPeriodAnnotation  = "certchecker.guardian.co.uk/warning-period"
+	CriticalPeriodAnnotation = "certchecker.guardian.co.uk/critical-period"
+	IgnoreAnnotation         = "certchecker.guardian.co.uk/ignore"
+	OwnerAnnotation          = "certchecker.guardian.co.uk/owner"
+)
+
+func invalidAnnotation(key string, value string, err error) datapersistence.Finding {
+	return datapersistence.Finding{
+		Code:     "InvalidAnnotation",
+		Severity: datapersistence.SeverityWarning,
+		Message:  fmt.Sprintf("could not understand %s value '%s': %s", key, value, err),
+	}
+}
+
+/**
+reads the per-certificate overrides from the given sets of annotations, normally those of a namespace and then those
+of the secret in it.  Later sets take precedence over earlier ones. Values that can't be parsed are ignored and
+reported as findings instead.
+*/
+func ParseOverrides(annotationSets ...map[string]string) *certs.Overrides {
+	overrides := &certs.Overrides{}
+
+	for _, annotations := range annotationSets {
+		if value, haveValue := annotations[WarningPeriodAnnotation]; haveValue {
+			threshold, err := certs.ParseThreshold(value)
+			if err != nil {
+				overrides.Problems = append(overrides.Problems, invalidAnnotation(WarningPeriodAnnotation, value, err))
+			} else {
+				overrides.Warning = &threshold
+			}
+		}
+		if value, haveValue := annotations[CriticalPeriodAnnotation]; haveValue {
+			threshold, err := certs.ParseThreshold(value)
+			if err != nil {
+				overrides.Problems = append(overrides.Problems, invalidAnnotation(CriticalPeriodAnnotation, value, err))
+			} else {
+				overrides.Critical = &threshold
+			}
+		}
+		if value, haveValue := annotations[IgnoreAnnotation]; haveValue {
+			ignore, err := strconv.ParseBool(value)
+			if err != nil {
+				overrides.Problems = append(overrides.Problems, invalidAnnotation(IgnoreAnnotation, value, err))
+			} else {
+				overrides.Ignore = ignore
+			}
+		}
+		if value, haveValue := annotations[OwnerAnnotation]; haveValue && value != "" {
+			overrides.Owner = value
+		}
+	}
+	return overrides
+}
@@ -0,0 +1,44 @@
+package certfinder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseOverridesPrecedence(t *testing.T) {
+	namespaceAnnotations := map[string]string{
+		WarningPeriodAnnotation:  "60d",
+		CriticalPeriodAnnotation: "7d",
+		OwnerAnnotation:          "team-a",
+	}
+	secretAnnotations := map[string]string{
+		WarningPeriodAnnotation: "14d",
+	}
+
+	overrides := ParseOverrides(namespaceAnnotations, secretAnnotations)
+	if overrides.Warning == nil || overrides.Warning.Period != 14*24*time.Hour {
+		t.Errorf("secret warning period should take precedence, got %v", overrides.Warning)
+	}
+	if overrides.Critical == nil || overrides.Critical.Period != 7*24*time.Hour {
+		t.Errorf("namespace critical period should apply, got %v", overrides.Critical)
+	}
+	if overrides.Owner != "team-a" {
+		t.Errorf("expected owner team-a, got '%s'", overrides.Owner)
+	}
+	if len(overrides.Problems) != 0 {
+		t.Errorf("expected no problems, got %v", overrides.Problems)
+	}
+}
+
+func TestParseOverridesInvalid(t *testing.T) {
+	overrides := ParseOverrides(map[string]string{
+		WarningPeriodAnnotation: "a month",
+		IgnoreAnnotation:        "perhaps",
+	})
+	if overrides.Warning != nil || overrides.Ignore {
+		t.Error("invalid values should not be applied")
+	}
+	if len(overrides.Problems) != 2 {
+		t.Errorf("expected 2 problems, got %d", len(overrides.Problems))
+	}
+}
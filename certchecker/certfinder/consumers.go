@@ -0,0 +1,271 @@
+package certfinder
+
+import (
+	"context"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"sort"
+)
+
+// IssuerVersions are the versions of cert-manager's API to look for Issuers and ClusterIssuers in
+var IssuerVersions = []string{"v1"}
+
+func IssuerResource(version string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "cert-manager.io", Version: version, Resource: "issuers"}
+}
+
+func ClusterIssuerResource(version string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "cert-manager.io", Version: version, Resource: "clusterissuers"}
+}
+
+/**
+ConsumerIndex lists the consumers of each secret, by "namespace/name".  Complete is false if some kinds of consumer
+could not be listed, in which case a secret with no consumers may just have ones we couldn't see
+*/
+type ConsumerIndex struct {
+	Consumers map[string][]datapersistence.Consumer
+	Complete  bool
+}
+
+/**
+records that `consumer` uses the secret
+*/
+func (idx *ConsumerIndex) Add(secretNamespace string, secretName string, consumer datapersistence.Consumer) {
+	key := secretNamespace + "/" + secretName
+	for _, existing := range idx.Consumers[key] {
+		if existing == consumer {
+			return
+		}
+	}
+	idx.Consumers[key] = append(idx.Consumers[key], consumer)
+}
+
+func (idx *ConsumerIndex) addPodSpec(kind string, meta *metav1.ObjectMeta, spec *v1.PodSpec) {
+	for _, secretName := range PodSpecSecrets(spec) {
+		idx.Add(meta.Namespace, secretName, datapersistence.Consumer{Kind: kind, Namespace: meta.Namespace, Name: meta.Name})
+	}
+}
+
+/**
+returns the consumers of the secret, sorted by kind, namespace and name
+*/
+func (idx *ConsumerIndex) For(namespace string, name string) []datapersistence.Consumer {
+	consumers := idx.Consumers[namespace+"/"+name]
+	sort.Slice(consumers, func(i, j int) bool {
+		if consumers[i].Kind != consumers[j].Kind {
+			return consumers[i].Kind < consumers[j].Kind
+		}
+		if consumers[i].Namespace != consumers[j].Namespace {
+			return consumers[i].Namespace < consumers[j].Namespace
+		}
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+/**
+true if the object is run by a controller of one of the given kinds
+*/
+func controlledBy(meta *metav1.ObjectMeta, kinds ...string) bool {
+	controller := metav1.GetControllerOf(meta)
+	if controller == nil {
+		return false
+	}
+	for _, kind := range kinds {
+		if controller.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+true if the pod is run by a controller whose own pod template is indexed, so listing the pod as well would only
+repeat it once per replica.  ReplicaSets and Jobs are indexed themselves unless they belong to a Deployment or
+CronJob, so every ReplicaSet or Job pod is covered by one or the other
+*/
+func managedPod(pod *v1.Pod) bool {
+	return controlledBy(&pod.ObjectMeta, "ReplicaSet", "StatefulSet", "DaemonSet", "Job")
+}
+
+/**
+finds everything in the cluster that uses a secret: Deployments, StatefulSets, DaemonSets, CronJobs, ReplicaSets and
+Jobs not run by a Deployment or CronJob, and any Pods not managed by one of them that mount it or read it into their
+environment, Ingresses that serve it, Gateway API or Istio Gateways whose listeners refer to it and cert-manager CA
+Issuers and ClusterIssuers that sign with it.  ClusterIssuers read their secrets from `clusterResourceNamespace`
+(cert-manager's --cluster-resource-namespace).  Gateways and issuers are skipped if their APIs aren't installed.  A
+kind that can't be listed is logged and the index is marked incomplete, rather than failing the scan
+*/
+func IndexConsumers(ctx context.Context, clientset kubernetes.Interface, dynamicClient dynamic.Interface, clusterResourceNamespace string) *ConsumerIndex {
+	idx := &ConsumerIndex{Consumers: make(map[string][]datapersistence.Consumer), Complete: true}
+	failed := func(kind string, err error) {
+		log.Printf("WARNING Could not list %s to find which secrets they use: %s", kind, err)
+		idx.Complete = false
+	}
+
+	if pods, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{}); err != nil {
+		failed("pods", err)
+	} else {
+		for i := range pods.Items {
+			if !managedPod(&pods.Items[i]) {
+				idx.addPodSpec("Pod", &pods.Items[i].ObjectMeta, &pods.Items[i].Spec)
+			}
+		}
+	}
+
+	if deployments, err := clientset.AppsV1().Deployments("").List(ctx, metav1.ListOptions{}); err != nil {
+		failed("deployments", err)
+	} else {
+		for i := range deployments.Items {
+			idx.addPodSpec("Deployment", &deployments.Items[i].ObjectMeta, &deployments.Items[i].Spec.Template.Spec)
+		}
+	}
+
+	if statefulSets, err := clientset.AppsV1().StatefulSets("").List(ctx, metav1.ListOptions{}); err != nil {
+		failed("statefulsets", err)
+	} else {
+		for i := range statefulSets.Items {
+			idx.addPodSpec("StatefulSet", &statefulSets.Items[i].ObjectMeta, &statefulSets.Items[i].Spec.Template.Spec)
+		}
+	}
+
+	if daemonSets, err := clientset.AppsV1().DaemonSets("").List(ctx, metav1.ListOptions{}); err != nil {
+		failed("daemonsets", err)
+	} else {
+		for i := range daemonSets.Items {
+			idx.addPodSpec("DaemonSet", &daemonSets.Items[i].ObjectMeta, &daemonSets.Items[i].Spec.Template.Spec)
+		}
+	}
+
+	if replicaSets, err := clientset.AppsV1().ReplicaSets("").List(ctx, metav1.ListOptions{}); err != nil {
+		failed("replicasets", err)
+	} else {
+		for i := range replicaSets.Items {
+			if !controlledBy(&replicaSets.Items[i].ObjectMeta, "Deployment") {
+				idx.addPodSpec("ReplicaSet", &replicaSets.Items[i].ObjectMeta, &replicaSets.Items[i].Spec.Template.Spec)
+			}
+		}
+	}
+
+	if jobs, err := clientset.BatchV1().Jobs("").List(ctx, metav1.ListOptions{}); err != nil {
+		failed("jobs", err)
+	} else {
+		for i := range jobs.Items {
+			if !controlledBy(&jobs.Items[i].ObjectMeta, "CronJob") {
+				idx.addPodSpec("Job", &jobs.Items[i].ObjectMeta, &jobs.Items[i].Spec.Template.Spec)
+			}
+		}
+	}
+
+	if cronJobs, err := clientset.BatchV1().CronJobs("").List(ctx, metav1.ListOptions{}); err != nil {
+		failed("cronjobs", err)
+	} else {
+		for i := range cronJobs.Items {
+			idx.addPodSpec("CronJob", &cronJobs.Items[i].ObjectMeta, &cronJobs.Items[i].Spec.JobTemplate.Spec.Template.Spec)
+		}
+	}
+
+	if ingresses, err := clientset.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{}); err != nil {
+		failed("ingresses", err)
+	} else {
+		for _, ingress := range ingresses.Items {
+			for _, tls := range ingress.Spec.TLS {
+				if tls.SecretName != "" {
+					idx.Add(ingress.Namespace, tls.SecretName, datapersistence.Consumer{Kind: "Ingress", Namespace: ingress.Namespace, Name: ingress.Name})
+				}
+			}
+		}
+	}
+
+	if dynamicClient != nil {
+		indexGateways(ctx, clientset, dynamicClient, idx, failed)
+		indexIssuers(ctx, dynamicClient, clusterResourceNamespace, idx, failed)
+	}
+	return idx
+}
+
+func indexGateways(ctx context.Context, clientset kubernetes.Interface, dynamicClient dynamic.Interface, idx *ConsumerIndex, failed func(string, error)) {
+	gateways, err := ListGateways(ctx, dynamicClient)
+	if err != nil {
+		failed("gateways", err)
+	}
+	for i := range gateways {
+		consumer := datapersistence.Consumer{Kind: "Gateway", Namespace: gateways[i].GetNamespace(), Name: gateways[i].GetName()}
+		for _, ref := range GatewaySecretRefs(&gateways[i]) {
+			idx.Add(ref.Namespace, ref.Name, consumer)
+		}
+	}
+
+	istioGateways, istioErr := ListIstioGateways(ctx, dynamicClient)
+	if istioErr != nil {
+		failed("Istio gateways", istioErr)
+	}
+	for i := range istioGateways {
+		consumer := datapersistence.Consumer{Kind: "IstioGateway", Namespace: istioGateways[i].GetNamespace(), Name: istioGateways[i].GetName()}
+		namespaces, namespacesErr := IstioCredentialNamespaces(ctx, clientset, &istioGateways[i])
+		if namespacesErr != nil {
+			failed("the workloads of Istio gateways", namespacesErr)
+		}
+		for _, listener := range IstioTLSListeners(&istioGateways[i], namespaces) {
+			for _, ref := range listener.SecretRefs {
+				idx.Add(ref.Namespace, ref.Name, consumer)
+			}
+		}
+	}
+}
+
+/**
+indexes the CA secrets of cert-manager's CA Issuers and ClusterIssuers.  Nothing mounts these, but they are far from
+unused
+*/
+func indexIssuers(ctx context.Context, dynamicClient dynamic.Interface, clusterResourceNamespace string, idx *ConsumerIndex, failed func(string, error)) {
+	addCASecret := func(kind string, issuer *unstructured.Unstructured, secretNamespace string) {
+		if secretName, _, _ := unstructured.NestedString(issuer.Object, "spec", "ca", "secretName"); secretName != "" {
+			idx.Add(secretNamespace, secretName, datapersistence.Consumer{Kind: kind, Namespace: issuer.GetNamespace(), Name: issuer.GetName()})
+		}
+	}
+
+	issuers, err := listFirstServed(ctx, dynamicClient, IssuerResource, IssuerVersions)
+	if err != nil {
+		failed("issuers", err)
+	}
+	for i := range issuers {
+		addCASecret("Issuer", &issuers[i], issuers[i].GetNamespace())
+	}
+
+	clusterIssuers, clusterErr := listFirstServed(ctx, dynamicClient, ClusterIssuerResource, IssuerVersions)
+	if clusterErr != nil {
+		failed("clusterissuers", clusterErr)
+	}
+	for i := range clusterIssuers {
+		addCASecret("ClusterIssuer", &clusterIssuers[i], clusterResourceNamespace)
+	}
+}
+
+/**
+sets the consumers of each secret-based record in the results.  If the index is complete, a secret that nothing uses
+is given an informational Unused finding, as it may be a candidate for cleaning up
+*/
+func (idx *ConsumerIndex) Attach(results []datapersistence.CheckRecord) {
+	for i := range results {
+		rec := &results[i]
+		if rec.Source.Kind != datapersistence.SourceSecret {
+			continue
+		}
+		rec.Consumers = idx.For(rec.Source.Namespace, rec.Source.Name)
+		if len(rec.Consumers) == 0 && idx.Complete {
+			certs.AddFindings(rec, datapersistence.Finding{
+				Code:     datapersistence.UnusedFinding,
+				Severity: datapersistence.SeverityInfo,
+				Message:  "nothing in the cluster uses this secret, it may be a candidate for cleaning up",
+			})
+		}
+	}
+}
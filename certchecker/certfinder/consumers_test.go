@@ -0,0 +1,246 @@
+package certfinder
+
+import (
+	"context"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+)
+
+func volumeSpec(secret string) v1.PodSpec {
+	return v1.PodSpec{Volumes: []v1.Volume{{
+		Name:         "tls",
+		VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: secret}},
+	}}}
+}
+
+/**
+the fake guesses the wrong resource name ("gatewaies") for objects passed to its constructor, so gateways (and
+issuers) are created through the client instead, in the resource for their apiVersion
+*/
+func newGatewayClient(t *testing.T, gateways ...*unstructured.Unstructured) *dynamicfake.FakeDynamicClient {
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for _, version := range GatewayVersions {
+		listKinds[GatewayResource(version)] = "GatewayList"
+	}
+	for _, version := range ReferenceGrantVersions {
+		listKinds[ReferenceGrantResource(version)] = "ReferenceGrantList"
+	}
+	for _, version := range IstioGatewayVersions {
+		listKinds[IstioGatewayResource(version)] = "GatewayList"
+	}
+	for _, version := range IssuerVersions {
+		listKinds[IssuerResource(version)] = "IssuerList"
+		listKinds[ClusterIssuerResource(version)] = "ClusterIssuerList"
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	for _, gateway := range gateways {
+		gv, _ := schema.ParseGroupVersion(gateway.GetAPIVersion())
+		resource := gv.WithResource(strings.ToLower(gateway.GetKind()) + "s")
+		if _, err := client.Resource(resource).Namespace(gateway.GetNamespace()).Create(context.Background(), gateway, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client
+}
+
+func istioGateway(namespace string, name string, servers ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "networking.istio.io/v1",
+		"kind":       "Gateway",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"spec":       map[string]interface{}{"servers": servers},
+	}}
+}
+
+func consumerTestClientset() *fake.Clientset {
+	isController := true
+	replicaSetPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "web",
+			Name:            "frontend-abc12",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "frontend-abc", Controller: &isController}},
+		},
+		Spec: volumeSpec("web-tls"),
+	}
+	return fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend"},
+			Spec:       appsv1.DeploymentSpec{Template: v1.PodTemplateSpec{Spec: volumeSpec("web-tls")}},
+		},
+		replicaSetPod,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "debug"}, Spec: volumeSpec("web-tls")},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "cert-export"},
+			Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{
+				Template: v1.PodTemplateSpec{Spec: volumeSpec("export-tls")},
+			}}},
+		},
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend"},
+			Spec:       networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{{SecretName: "web-tls"}}},
+		},
+	)
+}
+
+func gateway(namespace string, name string, certificateRefs ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"spec": map[string]interface{}{
+			"listeners": []interface{}{map[string]interface{}{
+				"name":     "https",
+				"protocol": "HTTPS",
+				"tls":      map[string]interface{}{"certificateRefs": certificateRefs},
+			}},
+		},
+	}}
+}
+
+func secretRecords(names ...string) []datapersistence.CheckRecord {
+	records := make([]datapersistence.CheckRecord, 0)
+	for _, name := range names {
+		records = append(records, datapersistence.CheckRecord{
+			Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: name, DataKey: v1.TLSCertKey},
+			CheckResult: datapersistence.WithinRange,
+		})
+	}
+	return records
+}
+
+func TestIndexConsumers(t *testing.T) {
+	dynamicClient := newGatewayClient(t, gateway("infra", "public",
+		map[string]interface{}{"name": "web-tls", "namespace": "web"},
+		map[string]interface{}{"name": "not-a-secret", "group": "example.com", "kind": "Certificate"},
+	), istioGateway("web", "mesh-ingress", map[string]interface{}{
+		"hosts": []interface{}{"web/www.example.com"},
+		"tls":   map[string]interface{}{"mode": "SIMPLE", "credentialName": "web-tls"},
+	}))
+
+	idx := IndexConsumers(context.Background(), consumerTestClientset(), dynamicClient, "cert-manager")
+	if !idx.Complete {
+		t.Fatal("expected every kind of consumer to be listed")
+	}
+	expected := []string{"Deployment web/frontend", "Gateway infra/public", "Ingress web/frontend", "IstioGateway web/mesh-ingress", "Pod web/debug"}
+	consumers := idx.For("web", "web-tls")
+	if fmt.Sprint(consumers) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, consumers)
+	}
+	if exported := idx.For("web", "export-tls"); len(exported) != 1 || exported[0].Kind != "CronJob" {
+		t.Errorf("expected the cronjob to be found, got %v", exported)
+	}
+	if len(idx.For("infra", "not-a-secret")) != 0 {
+		t.Error("a certificateRef to something other than a Secret should be ignored")
+	}
+
+	results := secretRecords("web-tls", "old-tls", "unreadable-tls")
+	results[2].CheckResult = datapersistence.Errored
+	results[2].Findings = []datapersistence.Finding{{Code: "Unreadable", Severity: datapersistence.SeverityCritical, Result: datapersistence.ResultOf(datapersistence.Errored)}}
+	idx.Attach(results)
+	if len(results[0].Consumers) != 5 || len(results[0].Findings) != 0 {
+		t.Errorf("expected web-tls to have its consumers and no findings, got %+v", results[0])
+	}
+	if len(results[1].Findings) != 1 || results[1].Findings[0].Code != datapersistence.UnusedFinding {
+		t.Errorf("expected old-tls to be flagged as unused, got %+v", results[1].Findings)
+	}
+	if results[1].CheckResult != datapersistence.WithinRange {
+		t.Errorf("being unused should not change the result, got %s", results[1].CheckResult)
+	}
+	if results[2].CheckResult != datapersistence.Errored {
+		t.Errorf("an unreadable cert should stay errored, got %+v", results[2])
+	}
+}
+
+func TestIndexConsumersIncomplete(t *testing.T) {
+	dynamicClient := newGatewayClient(t)
+	dynamicClient.PrependReactor("list", "gateways", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("forbidden")
+	})
+
+	idx := IndexConsumers(context.Background(), consumerTestClientset(), dynamicClient, "cert-manager")
+	if idx.Complete {
+		t.Fatal("expected the index to be incomplete when gateways can't be listed")
+	}
+	results := secretRecords("old-tls")
+	idx.Attach(results)
+	if len(results[0].Findings) != 0 {
+		t.Errorf("a secret should not be flagged as unused when some consumers couldn't be seen, got %+v", results[0].Findings)
+	}
+}
+
+func caIssuer(kind string, namespace string, name string, secretName string) *unstructured.Unstructured {
+	issuer := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"ca": map[string]interface{}{"secretName": secretName}},
+	}}
+	issuer.SetNamespace(namespace)
+	return issuer
+}
+
+func TestIndexConsumersControllersAndIssuers(t *testing.T) {
+	isController := true
+	owned := func(kind string, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &isController}}
+	}
+	clientset := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "legacy"},
+			Spec:       appsv1.ReplicaSetSpec{Template: v1.PodTemplateSpec{Spec: volumeSpec("legacy-tls")}},
+		},
+		&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend-abc", OwnerReferences: owned("Deployment", "frontend")},
+			Spec:       appsv1.ReplicaSetSpec{Template: v1.PodTemplateSpec{Spec: volumeSpec("web-tls")}},
+		},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "legacy-x1", OwnerReferences: owned("ReplicaSet", "legacy")}, Spec: volumeSpec("legacy-tls")},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "migrate"},
+			Spec:       batchv1.JobSpec{Template: v1.PodTemplateSpec{Spec: volumeSpec("migrate-tls")}},
+		},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "export-123", OwnerReferences: owned("CronJob", "export")},
+			Spec:       batchv1.JobSpec{Template: v1.PodTemplateSpec{Spec: volumeSpec("export-tls")}},
+		},
+	)
+	dynamicClient := newGatewayClient(t,
+		caIssuer("Issuer", "web", "internal", "internal-ca"),
+		caIssuer("ClusterIssuer", "", "root", "root-ca"),
+	)
+
+	idx := IndexConsumers(context.Background(), clientset, dynamicClient, "cert-manager")
+	if !idx.Complete {
+		t.Fatal("expected every kind of consumer to be listed")
+	}
+	if consumers := idx.For("web", "legacy-tls"); fmt.Sprint(consumers) != "[ReplicaSet web/legacy]" {
+		t.Errorf("expected a bare ReplicaSet to be indexed instead of its pods, got %v", consumers)
+	}
+	if consumers := idx.For("web", "web-tls"); len(consumers) != 0 {
+		t.Errorf("a ReplicaSet run by a Deployment should be left to the Deployment, got %v", consumers)
+	}
+	if consumers := idx.For("web", "migrate-tls"); fmt.Sprint(consumers) != "[Job web/migrate]" {
+		t.Errorf("expected a standalone Job to be indexed, got %v", consumers)
+	}
+	if consumers := idx.For("web", "export-tls"); len(consumers) != 0 {
+		t.Errorf("a Job run by a CronJob should be left to the CronJob, got %v", consumers)
+	}
+	if consumers := idx.For("web", "internal-ca"); fmt.Sprint(consumers) != "[Issuer web/internal]" {
+		t.Errorf("expected the Issuer's CA secret to be used, got %v", consumers)
+	}
+	if consumers := idx.For("cert-manager", "root-ca"); fmt.Sprint(consumers) != "[ClusterIssuer /root]" {
+		t.Errorf("expected the ClusterIssuer's CA secret in the cluster resource namespace to be used, got %v", consumers)
+	}
+}
@@ -0,0 +1,132 @@
+package certfinder
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var CertFileExtensions = []string{".pem", ".crt", ".cer"}
+
+/**
+FileSource finds certificates in files on disk.  Each path can be a file or a directory; directories are walked
+recursively and any file with one of the CertFileExtensions is read.  Files may contain PEM bundles with several
+certificates in them, or a single DER-encoded certificate.
+*/
+type FileSource struct {
+	Paths []string
+}
+
+func NewFileSource(paths []string) *FileSource {
+	return &FileSource{Paths: paths}
+}
+
+func (s *FileSource) Describe() string {
+	return "files"
+}
+
+func hasCertExtension(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, wanted := range CertFileExtensions {
+		if ext == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+splits the content of a certificate file into individual PEM-encoded certificates.  Non-certificate PEM blocks
+(e.g. private keys) are skipped.  If the content is not PEM at all it is assumed to be a single DER certificate.
+*/
+func SplitCertificateFile(content []byte) [][]byte {
+	results := make([][]byte, 0)
+	if !bytes.Contains(content, []byte("-----BEGIN")) {
+		if len(content) > 0 {
+			results = append(results, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: content}))
+		}
+		return results
+	}
+
+	rest := content
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			results = append(results, pem.EncodeToMemory(block))
+		}
+	}
+	return results
+}
+
+func fileCertData(path string) ([]CertData, error) {
+	content, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	certBlocks := SplitCertificateFile(content)
+	results := make([]CertData, len(certBlocks))
+	for i, certBlock := range certBlocks {
+		results[i] = CertData{
+			Source: datapersistence.SourceDescriptor{
+				Kind:    datapersistence.SourceFile,
+				Name:    path,
+				DataKey: strconv.Itoa(i),
+			},
+			RawCertificateData: certBlock,
+		}
+	}
+	return results, nil
+}
+
+/**
+walks each of the paths in turn.  A path that was given explicitly but is missing or unreadable is an error, since it
+is most likely a typo and would otherwise go unnoticed; unreadable files found inside a directory are logged and skipped
+*/
+func (s *FileSource) FindCertificates(ctx context.Context) (*[]CertData, error) {
+	results := make([]CertData, 0)
+
+	for _, root := range s.Paths {
+		walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if d.IsDir() {
+				return nil
+			}
+			//files named explicitly on the commandline are always read, files found in a directory need an extension
+			if path != root && !hasCertExtension(path) {
+				return nil
+			}
+
+			found, readErr := fileCertData(path)
+			if readErr != nil && path == root {
+				return readErr
+			} else if readErr != nil {
+				log.Printf("ERROR FileSource could not read %s: %s", path, readErr)
+				return nil
+			}
+			log.Printf("INFO FileSource %s: found %d certs", path, len(found))
+			results = append(results, found...)
+			return nil
+		})
+		if walkErr != nil {
+			return nil, walkErr
+		}
+	}
+	return &results, nil
+}
@@ -0,0 +1,77 @@
+package certfinder
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitCertificateFileBundle(t *testing.T) {
+	content := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1, 2, 3}}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{4, 5, 6}})...)
+	content = append(content, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{7, 8, 9}})...)
+
+	result := SplitCertificateFile(content)
+	if len(result) != 2 {
+		t.Fatalf("expected 2 certificates from bundle, got %d", len(result))
+	}
+	block, _ := pem.Decode(result[1])
+	if block == nil || block.Bytes[0] != 7 {
+		t.Errorf("second certificate was not decoded correctly")
+	}
+}
+
+func TestSplitCertificateFileDER(t *testing.T) {
+	result := SplitCertificateFile([]byte{0x30, 0x82, 0x01, 0x0a})
+	if len(result) != 1 {
+		t.Fatalf("expected DER content to be treated as a single certificate, got %d", len(result))
+	}
+	block, _ := pem.Decode(result[0])
+	if block == nil || block.Type != "CERTIFICATE" || len(block.Bytes) != 4 {
+		t.Errorf("DER content was not wrapped into a CERTIFICATE PEM block")
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSourceFindCertificates(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1, 2, 3}})
+	writeTestFile(t, filepath.Join(dir, "tree", "server.pem"), certPEM)
+	writeTestFile(t, filepath.Join(dir, "tree", "nested", "chain.CRT"), append(certPEM, certPEM...))
+	writeTestFile(t, filepath.Join(dir, "tree", "notes.txt"), certPEM)
+	writeTestFile(t, filepath.Join(dir, "explicit.key"), certPEM)
+
+	source := NewFileSource([]string{filepath.Join(dir, "tree"), filepath.Join(dir, "explicit.key")})
+	found, err := source.FindCertificates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, cert := range *found {
+		counts[filepath.Base(cert.Source.Name)]++
+	}
+	if counts["server.pem"] != 1 || counts["chain.CRT"] != 2 || counts["explicit.key"] != 1 {
+		t.Errorf("expected certs from the nested files and the explicitly named file, got %v", counts)
+	}
+	if counts["notes.txt"] != 0 {
+		t.Errorf("expected a file without a cert extension inside a directory to be skipped")
+	}
+
+	missing := NewFileSource([]string{filepath.Join(dir, "tree"), filepath.Join(dir, "typo")})
+	if _, err := missing.FindCertificates(context.Background()); !os.IsNotExist(err) {
+		t.Errorf("expected a missing path to be a not-exist error, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package certfinder
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const gatewayAPIGroup = "gateway.networking.k8s.io"
+
+// GatewayVersions are the versions of the Gateway API to look for Gateways in, most preferred first
+var GatewayVersions = []string{"v1", "v1beta1"}
+
+// ReferenceGrantVersions are the versions of the Gateway API to look for ReferenceGrants in, most preferred first
+var ReferenceGrantVersions = []string{"v1beta1", "v1alpha2"}
+
+// IstioGatewayVersions are the versions of Istio's networking API to look for Gateways in, most preferred first
+var IstioGatewayVersions = []string{"v1", "v1beta1", "v1alpha3"}
+
+func GatewayResource(version string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: gatewayAPIGroup, Version: version, Resource: "gateways"}
+}
+
+func ReferenceGrantResource(version string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: gatewayAPIGroup, Version: version, Resource: "referencegrants"}
+}
+
+func IstioGatewayResource(version string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "networking.istio.io", Version: version, Resource: "gateways"}
+}
+
+/**
+a reference from a Gateway listener to the secret holding its certificate
+*/
+type SecretRef struct {
+	Namespace string
+	Name      string
+}
+
+/**
+a listener on a Gateway API Gateway, or a server on an Istio Gateway, that terminates TLS.  Hostnames is empty if
+the listener accepts any hostname.  OtherRefs describes any certificate references to things other than secrets
+*/
+type TLSListener struct {
+	Name       string
+	Hostnames  []string
+	SecretRefs []SecretRef
+	OtherRefs  []string
+}
+
+/**
+the TLS listeners of a Gateway API Gateway.  A certificate reference without a namespace is to the Gateway's own
+namespace
+*/
+func GatewayTLSListeners(gateway *unstructured.Unstructured) []TLSListener {
+	result := make([]TLSListener, 0)
+	listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	for _, l := range listeners {
+		listener, isMap := l.(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		certificateRefs, _, _ := unstructured.NestedSlice(listener, "tls", "certificateRefs")
+		if len(certificateRefs) == 0 {
+			continue
+		}
+		tlsListener := TLSListener{SecretRefs: make([]SecretRef, 0)}
+		tlsListener.Name, _, _ = unstructured.NestedString(listener, "name")
+		if hostname, _, _ := unstructured.NestedString(listener, "hostname"); hostname != "" {
+			tlsListener.Hostnames = []string{hostname}
+		}
+
+		for _, r := range certificateRefs {
+			ref, isMap := r.(map[string]interface{})
+			if !isMap {
+				continue
+			}
+			group, _, _ := unstructured.NestedString(ref, "group")
+			kind, _, _ := unstructured.NestedString(ref, "kind")
+			name, _, _ := unstructured.NestedString(ref, "name")
+			if group != "" || (kind != "" && kind != "Secret") {
+				tlsListener.OtherRefs = append(tlsListener.OtherRefs, kind+"."+group+" "+name)
+				continue
+			}
+			namespace, _, _ := unstructured.NestedString(ref, "namespace")
+			if namespace == "" {
+				namespace = gateway.GetNamespace()
+			}
+			tlsListener.SecretRefs = append(tlsListener.SecretRefs, SecretRef{Namespace: namespace, Name: name})
+		}
+		result = append(result, tlsListener)
+	}
+	return result
+}
+
+/**
+the secrets that a Gateway's listeners refer to
+*/
+func GatewaySecretRefs(gateway *unstructured.Unstructured) []SecretRef {
+	refs := make([]SecretRef, 0)
+	for _, listener := range GatewayTLSListeners(gateway) {
+		refs = append(refs, listener.SecretRefs...)
+	}
+	return refs
+}
+
+/**
+the namespaces that an Istio Gateway's `credentialName` secrets are read from.  Istio looks them up in the namespace
+of the gateway workload (usually istio-system), not the Gateway resource's, so this finds the pods that match the
+Gateway's `spec.selector`.  Falls back to the Gateway's own namespace if there is no selector or no pods match it
+*/
+func IstioCredentialNamespaces(ctx context.Context, clientset kubernetes.Interface, gateway *unstructured.Unstructured) ([]string, error) {
+	fallback := []string{gateway.GetNamespace()}
+	selector, _, _ := unstructured.NestedStringMap(gateway.Object, "spec", "selector")
+	if len(selector) == 0 {
+		return fallback, nil
+	}
+	pods, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(selector).String()})
+	if err != nil {
+		return fallback, err
+	}
+	seen := make(map[string]bool)
+	namespaces := make([]string, 0)
+	for _, pod := range pods.Items {
+		if !seen[pod.Namespace] {
+			seen[pod.Namespace] = true
+			namespaces = append(namespaces, pod.Namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		return fallback, nil
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+/**
+the servers of an Istio Gateway that terminate TLS with a `credentialName`, which names a secret in each of
+`credentialNamespaces` (see IstioCredentialNamespaces).  Servers that pass TLS through or use Istio's own mTLS certs
+are skipped, and the `namespace/` prefix is taken off their hosts
+*/
+func IstioTLSListeners(gateway *unstructured.Unstructured, credentialNamespaces []string) []TLSListener {
+	result := make([]TLSListener, 0)
+	servers, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "servers")
+	for i, s := range servers {
+		server, isMap := s.(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		credentialName, _, _ := unstructured.NestedString(server, "tls", "credentialName")
+		mode, _, _ := unstructured.NestedString(server, "tls", "mode")
+		if credentialName == "" || mode == "PASSTHROUGH" || mode == "AUTO_PASSTHROUGH" || mode == "ISTIO_MUTUAL" {
+			continue
+		}
+
+		tlsListener := TLSListener{SecretRefs: make([]SecretRef, 0, len(credentialNamespaces))}
+		for _, namespace := range credentialNamespaces {
+			tlsListener.SecretRefs = append(tlsListener.SecretRefs, SecretRef{Namespace: namespace, Name: credentialName})
+		}
+		tlsListener.Name, _, _ = unstructured.NestedString(server, "name")
+		if tlsListener.Name == "" {
+			tlsListener.Name, _, _ = unstructured.NestedString(server, "port", "name")
+		}
+		if tlsListener.Name == "" {
+			tlsListener.Name = strconv.Itoa(i)
+		}
+		hosts, _, _ := unstructured.NestedStringSlice(server, "hosts")
+		for _, host := range hosts {
+			if slash := strings.Index(host, "/"); slash != -1 {
+				host = host[slash+1:]
+			}
+			if host == "*" {
+				//accepts any hostname, so there's nothing to check the cert covers
+				tlsListener.Hostnames = nil
+				break
+			}
+			tlsListener.Hostnames = append(tlsListener.Hostnames, host)
+		}
+		result = append(result, tlsListener)
+	}
+	return result
+}
+
+/**
+lists a resource from the first of `versions` that the cluster serves.  Returns nil without an error if none of them
+is served, i.e. the API isn't installed
+*/
+func listFirstServed(ctx context.Context, dynamicClient dynamic.Interface, resource func(string) schema.GroupVersionResource, versions []string) ([]unstructured.Unstructured, error) {
+	for _, version := range versions {
+		list, err := dynamicClient.Resource(resource(version)).Namespace("").List(ctx, metav1.ListOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}
+	return nil, nil
+}
+
+/**
+lists Gateways from the first Gateway API version that the cluster serves.  Returns nil without an error if the
+Gateway API isn't installed
+*/
+func ListGateways(ctx context.Context, dynamicClient dynamic.Interface) ([]unstructured.Unstructured, error) {
+	return listFirstServed(ctx, dynamicClient, GatewayResource, GatewayVersions)
+}
+
+func ListReferenceGrants(ctx context.Context, dynamicClient dynamic.Interface) ([]unstructured.Unstructured, error) {
+	return listFirstServed(ctx, dynamicClient, ReferenceGrantResource, ReferenceGrantVersions)
+}
+
+/**
+lists Istio Gateways.  Returns nil without an error if Istio isn't installed
+*/
+func ListIstioGateways(ctx context.Context, dynamicClient dynamic.Interface) ([]unstructured.Unstructured, error) {
+	return listFirstServed(ctx, dynamicClient, IstioGatewayResource, IstioGatewayVersions)
+}
+
+/**
+returns true if one of the ReferenceGrants lets a Gateway in `fromNamespace` use the secret `to`.  References within
+a namespace don't need a grant
+*/
+func ReferenceGrantAllows(grants []unstructured.Unstructured, fromNamespace string, to SecretRef) bool {
+	if fromNamespace == to.Namespace {
+		return true
+	}
+	for i := range grants {
+		if grants[i].GetNamespace() != to.Namespace {
+			continue
+		}
+		if grantMatches(&grants[i], "from", func(ref map[string]interface{}) bool {
+			group, _, _ := unstructured.NestedString(ref, "group")
+			kind, _, _ := unstructured.NestedString(ref, "kind")
+			namespace, _, _ := unstructured.NestedString(ref, "namespace")
+			return group == gatewayAPIGroup && kind == "Gateway" && namespace == fromNamespace
+		}) && grantMatches(&grants[i], "to", func(ref map[string]interface{}) bool {
+			group, _, _ := unstructured.NestedString(ref, "group")
+			kind, _, _ := unstructured.NestedString(ref, "kind")
+			name, _, _ := unstructured.NestedString(ref, "name")
+			return group == "" && kind == "Secret" && (name == "" || name == to.Name)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+func grantMatches(grant *unstructured.Unstructured, field string, matches func(map[string]interface{}) bool) bool {
+	refs, _, _ := unstructured.NestedSlice(grant.Object, "spec", field)
+	for _, r := range refs {
+		if ref, isMap := r.(map[string]interface{}); isMap && matches(ref) {
+			return true
+		}
+	}
+	return false
+}
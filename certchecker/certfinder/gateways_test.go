@@ -0,0 +1,35 @@
+package certfinder
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"testing"
+)
+
+func TestReferenceGrantAllows(t *testing.T) {
+	grants := []unstructured.Unstructured{{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1beta1",
+		"kind":       "ReferenceGrant",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": "allow-infra"},
+		"spec": map[string]interface{}{
+			"from": []interface{}{map[string]interface{}{"group": gatewayAPIGroup, "kind": "Gateway", "namespace": "infra"}},
+			"to":   []interface{}{map[string]interface{}{"group": "", "kind": "Secret", "name": "web-tls"}},
+		},
+	}}}
+
+	cases := []struct {
+		from     string
+		to       SecretRef
+		expected bool
+	}{
+		{"infra", SecretRef{Namespace: "web", Name: "web-tls"}, true},
+		{"infra", SecretRef{Namespace: "web", Name: "other-tls"}, false},
+		{"other", SecretRef{Namespace: "web", Name: "web-tls"}, false},
+		{"infra", SecretRef{Namespace: "secure", Name: "web-tls"}, false},
+		{"web", SecretRef{Namespace: "web", Name: "anything"}, true},
+	}
+	for _, c := range cases {
+		if ReferenceGrantAllows(grants, c.from, c.to) != c.expected {
+			t.Errorf("expected a Gateway in %s using %s/%s to be allowed=%t", c.from, c.to.Namespace, c.to.Name, c.expected)
+		}
+	}
+}
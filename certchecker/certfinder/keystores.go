@@ -0,0 +1,126 @@
+package certfinder
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// KeystorePasswordKeyAnnotation names the key, in the same secret, that holds the keystore password
+const KeystorePasswordKeyAnnotation = "certchecker.guardian.co.uk/keystore-password-key"
+
+// KeystorePasswordSecretAnnotation points at another secret in the same namespace holding the password, as `name/key`
+const KeystorePasswordSecretAnnotation = "certchecker.guardian.co.uk/keystore-password-secret"
+
+// DefaultKeystorePasswordKeys are the sibling keys that are tried, in order, if the secret has no password annotation
+var DefaultKeystorePasswordKeys = []string{"keystore.password", "password", "storepass"}
+
+type keystoreLoader func(data []byte, password string) ([]certs.KeystoreEntry, error)
+
+var keystoreLoaders = map[string]keystoreLoader{
+	".p12":   certs.LoadPKCS12,
+	".pfx":   certs.LoadPKCS12,
+	".jks":   certs.LoadJavaKeystore,
+	".jceks": certs.LoadJavaKeystore,
+}
+
+/**
+works out the password for the keystore held in `dataKey` of the given secret.  In order of preference this comes
+from another secret named by KeystorePasswordSecretAnnotation, a sibling key named by KeystorePasswordKeyAnnotation,
+a sibling key called `<dataKey>.password` or one of the configured default sibling keys.  If none of these exist the
+password is assumed to be empty.  Wherever it comes from, a trailing newline (as left by `echo` or an editor when the
+secret was created) is taken off.
+*/
+func (s *SecretSource) keystorePassword(ctx context.Context, secret *v1.Secret, dataKey string) (string, error) {
+	password, err := s.findKeystorePassword(ctx, secret, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(password), "\r\n"), nil
+}
+
+func (s *SecretSource) findKeystorePassword(ctx context.Context, secret *v1.Secret, dataKey string) ([]byte, error) {
+	if ref, haveRef := secret.Annotations[KeystorePasswordSecretAnnotation]; haveRef {
+		parts := strings.SplitN(ref, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s should be in the form name/key, got '%s'", KeystorePasswordSecretAnnotation, ref)
+		}
+		passwordSecret, getErr := s.Clientset.CoreV1().Secrets(secret.Namespace).Get(ctx, parts[0], metav1.GetOptions{})
+		if getErr != nil {
+			return nil, getErr
+		}
+		password, havePassword := passwordSecret.Data[parts[1]]
+		if !havePassword {
+			return nil, fmt.Errorf("secret %s has no key %s", parts[0], parts[1])
+		}
+		return password, nil
+	}
+
+	if key, haveKey := secret.Annotations[KeystorePasswordKeyAnnotation]; haveKey {
+		password, havePassword := secret.Data[key]
+		if !havePassword {
+			return nil, fmt.Errorf("%s names key %s, which does not exist", KeystorePasswordKeyAnnotation, key)
+		}
+		return password, nil
+	}
+
+	candidates := append([]string{dataKey + ".password"}, s.KeystorePasswordKeys...)
+	for _, key := range candidates {
+		if password, havePassword := secret.Data[key]; havePassword {
+			return password, nil
+		}
+	}
+	return nil, nil
+}
+
+/**
+decodes any PKCS#12 or Java keystores held in the secret and returns each certificate in them as its own CertData,
+with the keystore entry alias recorded in the source.  A keystore that can't be opened, e.g. because the password is
+wrong or missing, is returned as a single CertData with its ReadError set
+*/
+func (s *SecretSource) extractKeystoreCerts(ctx context.Context, secret *v1.Secret) []CertData {
+	results := make([]CertData, 0)
+
+	for dataKey, content := range secret.Data {
+		loader, isKeystore := keystoreLoaders[strings.ToLower(filepath.Ext(dataKey))]
+		if !isKeystore {
+			continue
+		}
+
+		password, passwordErr := s.keystorePassword(ctx, secret, dataKey)
+		if passwordErr != nil {
+			log.Printf("ERROR Could not get keystore password for %s:%s[%s]: %s", secret.Namespace, secret.Name, dataKey, passwordErr)
+			results = append(results, CertData{
+				Source:    SecretDescriptor(secret, dataKey),
+				ReadError: fmt.Errorf("could not get keystore password: %s", passwordErr),
+			})
+			continue
+		}
+
+		entries, loadErr := loader(content, password)
+		if loadErr != nil {
+			log.Printf("ERROR Could not decode keystore %s:%s[%s]: %s", secret.Namespace, secret.Name, dataKey, loadErr)
+			results = append(results, CertData{
+				Source:    SecretDescriptor(secret, dataKey),
+				ReadError: fmt.Errorf("could not decode keystore: %s", loadErr),
+			})
+			continue
+		}
+
+		for _, entry := range entries {
+			source := SecretDescriptor(secret, dataKey)
+			source.Alias = entry.Alias
+			results = append(results, CertData{
+				Source:             source,
+				RawCertificateData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: entry.Certificate.Raw}),
+			})
+		}
+	}
+	return results
+}
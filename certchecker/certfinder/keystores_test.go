@@ -0,0 +1,109 @@
+package certfinder
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestExtractKeystoreCertsUnreadable(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "java",
+			Name:        "keystores",
+			Annotations: map[string]string{KeystorePasswordKeyAnnotation: "missing"},
+		},
+		Data: map[string][]byte{"server.p12": []byte("not a keystore")},
+	}
+	source := NewSecretSource(fake.NewSimpleClientset(), SourceOptions{})
+
+	found := source.extractKeystoreCerts(context.Background(), secret)
+	if len(found) != 1 || found[0].ReadError == nil || found[0].Source.DataKey != "server.p12" {
+		t.Fatalf("expected one record with a read error for a keystore with no password, got %v", found)
+	}
+
+	delete(secret.Annotations, KeystorePasswordKeyAnnotation)
+	found = source.extractKeystoreCerts(context.Background(), secret)
+	if len(found) != 1 || found[0].ReadError == nil {
+		t.Fatalf("expected one record with a read error for a keystore that can't be decoded, got %v", found)
+	}
+}
+
+func TestKeystorePassword(t *testing.T) {
+	passwordSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "java", Name: "keystore-passwords"},
+		Data:       map[string][]byte{"server": []byte("from-other-secret\n")},
+	}
+	source := NewSecretSource(fake.NewSimpleClientset(passwordSecret), SourceOptions{})
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		data        map[string][]byte
+		expected    string
+		expectErr   bool
+	}{
+		{
+			name:        "secret reference",
+			annotations: map[string]string{KeystorePasswordSecretAnnotation: "keystore-passwords/server"},
+			data:        map[string][]byte{"server.p12.password": []byte("sibling")},
+			expected:    "from-other-secret",
+		},
+		{
+			name:        "secret reference to a missing key",
+			annotations: map[string]string{KeystorePasswordSecretAnnotation: "keystore-passwords/client"},
+			expectErr:   true,
+		},
+		{
+			name:        "malformed secret reference",
+			annotations: map[string]string{KeystorePasswordSecretAnnotation: "keystore-passwords"},
+			expectErr:   true,
+		},
+		{
+			name:        "annotated key",
+			annotations: map[string]string{KeystorePasswordKeyAnnotation: "pass"},
+			data:        map[string][]byte{"pass": []byte("annotated\r\n"), "password": []byte("default")},
+			expected:    "annotated",
+		},
+		{
+			name:        "annotated key that is missing",
+			annotations: map[string]string{KeystorePasswordKeyAnnotation: "pass"},
+			expectErr:   true,
+		},
+		{
+			name:     "sibling key named after the keystore",
+			data:     map[string][]byte{"server.p12.password": []byte("sibling"), "password": []byte("default")},
+			expected: "sibling",
+		},
+		{
+			name:     "default sibling key",
+			data:     map[string][]byte{"storepass": []byte("default")},
+			expected: "default",
+		},
+		{
+			name:     "no password",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "java", Name: "keystores", Annotations: test.annotations},
+			Data:       test.data,
+		}
+		password, err := source.keystorePassword(context.Background(), secret, "server.p12")
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got password '%s'", test.name, password)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if password != test.expected {
+			t.Errorf("%s: expected password '%s', got '%s'", test.name, test.expected, password)
+		}
+	}
+}
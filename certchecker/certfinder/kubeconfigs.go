@@ -0,0 +1,159 @@
+package certfinder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"io/ioutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"log"
+	"sort"
+)
+
+const (
+	ClientCertificateDataKey    = "client-certificate-data"
+	CertificateAuthorityDataKey = "certificate-authority-data"
+)
+
+/**
+a rough check for whether a blob of secret data is a kubeconfig, before we go to the expense of parsing it
+*/
+func looksLikeKubeconfig(content []byte) bool {
+	return bytes.Contains(content, []byte("kind: Config")) || bytes.Contains(content, []byte(`"kind":"Config"`)) ||
+		bytes.Contains(content, []byte(`"kind": "Config"`))
+}
+
+/**
+returns the names of the contexts that use the given user (if `forUser`) or cluster
+*/
+func contextsUsing(config *clientcmdapi.Config, name string, forUser bool) []string {
+	results := make([]string, 0)
+	for contextName, context := range config.Contexts {
+		if (forUser && context.AuthInfo == name) || (!forUser && context.Cluster == name) {
+			results = append(results, contextName)
+		}
+	}
+	sort.Strings(results)
+	return results
+}
+
+func kubeconfigEntryCerts(base datapersistence.SourceDescriptor, dataKey string, alias string, contexts []string, data []byte) []CertData {
+	blocks := SplitCertificateFile(data)
+	results := make([]CertData, len(blocks))
+	for i, block := range blocks {
+		source := base
+		if source.Kind == datapersistence.SourceFile {
+			source.DataKey = dataKey
+		}
+		source.Alias = alias
+		if len(blocks) > 1 {
+			source.Alias = fmt.Sprintf("%s#%d", alias, i)
+		}
+		source.Contexts = contexts
+		results[i] = CertData{Source: source, RawCertificateData: block}
+	}
+	return results
+}
+
+/**
+parses the given kubeconfig content and returns the `client-certificate-data` of each user and the
+`certificate-authority-data` of each cluster.  Each one is labelled with an alias of `user/<name>` or `cluster/<name>`
+and the names of the contexts that refer to it.  `base` describes where the kubeconfig itself came from.
+*/
+func KubeconfigCertData(content []byte, base datapersistence.SourceDescriptor) ([]CertData, error) {
+	config, loadErr := clientcmd.Load(content)
+	if loadErr != nil {
+		return nil, loadErr
+	}
+
+	results := make([]CertData, 0)
+
+	userNames := make([]string, 0, len(config.AuthInfos))
+	for name := range config.AuthInfos {
+		userNames = append(userNames, name)
+	}
+	sort.Strings(userNames)
+	for _, name := range userNames {
+		user := config.AuthInfos[name]
+		if len(user.ClientCertificateData) > 0 {
+			results = append(results, kubeconfigEntryCerts(base, ClientCertificateDataKey, datapersistence.KubeconfigUserAliasPrefix+name, contextsUsing(config, name, true), user.ClientCertificateData)...)
+		}
+	}
+
+	clusterNames := make([]string, 0, len(config.Clusters))
+	for name := range config.Clusters {
+		clusterNames = append(clusterNames, name)
+	}
+	sort.Strings(clusterNames)
+	for _, name := range clusterNames {
+		cluster := config.Clusters[name]
+		if len(cluster.CertificateAuthorityData) > 0 {
+			results = append(results, kubeconfigEntryCerts(base, CertificateAuthorityDataKey, "cluster/"+name, contextsUsing(config, name, false), cluster.CertificateAuthorityData)...)
+		}
+	}
+	return results, nil
+}
+
+/**
+finds any kubeconfigs held in the secret and returns the certificates embedded in them
+*/
+func extractKubeconfigCerts(secret *v1.Secret) []CertData {
+	results := make([]CertData, 0)
+
+	for dataKey, content := range secret.Data {
+		if !looksLikeKubeconfig(content) {
+			continue
+		}
+		found, parseErr := KubeconfigCertData(content, SecretDescriptor(secret, dataKey))
+		if parseErr != nil {
+			log.Printf("ERROR Could not parse kubeconfig in %s:%s[%s]: %s", secret.Namespace, secret.Name, dataKey, parseErr)
+			continue
+		}
+		results = append(results, found...)
+	}
+	return results
+}
+
+/**
+KubeconfigFileSource finds the client and CA certificates embedded in kubeconfig files on disk, e.g. the admin.conf
+that kubeadm generates
+*/
+type KubeconfigFileSource struct {
+	Paths []string
+}
+
+func NewKubeconfigFileSource(paths []string) *KubeconfigFileSource {
+	return &KubeconfigFileSource{Paths: paths}
+}
+
+func (s *KubeconfigFileSource) Describe() string {
+	return "kubeconfig files"
+}
+
+/**
+reads each of the kubeconfigs in turn.  They are all named explicitly, so one that is missing or can't be parsed is an
+error rather than being skipped
+*/
+func (s *KubeconfigFileSource) FindCertificates(ctx context.Context) (*[]CertData, error) {
+	results := make([]CertData, 0)
+
+	for _, path := range s.Paths {
+		content, readErr := ioutil.ReadFile(path)
+		if readErr != nil {
+			return nil, readErr
+		}
+		found, parseErr := KubeconfigCertData(content, datapersistence.SourceDescriptor{
+			Kind: datapersistence.SourceFile,
+			Name: path,
+		})
+		if parseErr != nil {
+			return nil, fmt.Errorf("could not parse %s: %s", path, parseErr)
+		}
+		log.Printf("INFO KubeconfigFileSource %s: found %d certs", path, len(found))
+		results = append(results, found...)
+	}
+	return &results, nil
+}
@@ -0,0 +1,66 @@
+package certfinder
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"testing"
+)
+
+func TestKubeconfigCertData(t *testing.T) {
+	clientPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1, 2, 3}})
+	caPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{4, 5, 6}}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{7, 8, 9}})...)
+
+	content := []byte(`apiVersion: v1
+kind: Config
+clusters:
+  - name: prod
+    cluster:
+      server: https://prod.example.com
+      certificate-authority-data: ` + base64.StdEncoding.EncodeToString(caPEM) + `
+users:
+  - name: ci
+    user:
+      client-certificate-data: ` + base64.StdEncoding.EncodeToString(clientPEM) + `
+  - name: token-user
+    user:
+      token: abc
+contexts:
+  - name: ci@prod
+    context:
+      cluster: prod
+      user: ci
+  - name: other@prod
+    context:
+      cluster: prod
+      user: token-user
+`)
+	if !looksLikeKubeconfig(content) {
+		t.Error("looksLikeKubeconfig did not recognise a kubeconfig")
+	}
+
+	results, err := KubeconfigCertData(content, datapersistence.SourceDescriptor{Kind: datapersistence.SourceFile, Name: "admin.conf"})
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 1 client cert and 2 CA certs, got %d", len(results))
+	}
+
+	client := results[0].Source
+	if client.Alias != "user/ci" || client.DataKey != ClientCertificateDataKey {
+		t.Errorf("unexpected source for client cert: %s", client)
+	}
+	if len(client.Contexts) != 1 || client.Contexts[0] != "ci@prod" {
+		t.Errorf("expected client cert to be used by ci@prod, got %v", client.Contexts)
+	}
+
+	ca := results[1].Source
+	if ca.Alias != "cluster/prod#0" || ca.DataKey != CertificateAuthorityDataKey {
+		t.Errorf("unexpected source for CA cert: %s", ca)
+	}
+	if len(ca.Contexts) != 2 {
+		t.Errorf("expected CA cert to be used by both contexts, got %v", ca.Contexts)
+	}
+}
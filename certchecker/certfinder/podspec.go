@@ -0,0 +1,52 @@
+package certfinder
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"sort"
+)
+
+func addEnvSecrets(container *v1.Container, found map[string]bool) {
+	for _, env := range container.Env {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+			found[env.ValueFrom.SecretKeyRef.Name] = true
+		}
+	}
+	for _, envFrom := range container.EnvFrom {
+		if envFrom.SecretRef != nil {
+			found[envFrom.SecretRef.Name] = true
+		}
+	}
+}
+
+/**
+returns the names of the secrets that a pod spec uses, whether mounted as a volume (directly or through a projected
+volume) or read into the environment of any of its containers.  The names are sorted and each appears once
+*/
+func PodSpecSecrets(spec *v1.PodSpec) []string {
+	found := make(map[string]bool)
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			found[volume.Secret.SecretName] = true
+		}
+		if volume.Projected != nil {
+			for _, projection := range volume.Projected.Sources {
+				if projection.Secret != nil {
+					found[projection.Secret.Name] = true
+				}
+			}
+		}
+	}
+	for i := range spec.InitContainers {
+		addEnvSecrets(&spec.InitContainers[i], found)
+	}
+	for i := range spec.Containers {
+		addEnvSecrets(&spec.Containers[i], found)
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,6 +2,8 @@ package certfinder
 
 import (
 	"context"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -9,12 +11,29 @@ import (
 )
 
 type CertData struct {
-	Namespace          string
-	SecretName         string
+	Source             datapersistence.SourceDescriptor
 	RawCertificateData []byte
+	// RawKeyData is the PEM private key stored alongside the certificate, e.g. `tls.key`, if there is one
+	RawKeyData []byte
+	// Overrides holds any per-certificate settings from annotations, or nil if there are none
+	Overrides *certs.Overrides
+	// ReadError is set, with no certificate data, when the source found a container such as a keystore but couldn't
+	// get the certificates out of it, so that it is still reported
+	ReadError error
 }
 
-func ScanNamespaces(ctx context.Context, clientset *kubernetes.Clientset) (*[]v1.Namespace, error) {
+/**
+CertSource is implemented by anything that can find certificates, e.g. Secrets in a cluster.
+New sources should implement this and be added to DefaultSources
+*/
+type CertSource interface {
+	// Describe returns a short description of the source for logging
+	Describe() string
+	// FindCertificates returns the raw certificate data from every object this source knows about
+	FindCertificates(ctx context.Context) (*[]CertData, error)
+}
+
+func ScanNamespaces(ctx context.Context, clientset kubernetes.Interface) (*[]v1.Namespace, error) {
 	client := clientset.CoreV1().Namespaces()
 
 	results := make([]v1.Namespace, 0)
@@ -41,80 +60,48 @@ func ScanNamespaces(ctx context.Context, clientset *kubernetes.Clientset) (*[]v1
 	return &results, nil
 }
 
-func arrayContains(needle *v1.SecretType, haystack *[]string) bool {
-	for _, blade := range *haystack {
-		if string(*needle) == blade {
-			return true
-		}
-	}
-	return false
+/**
+settings that are passed through to the individual sources
+*/
+type SourceOptions struct {
+	// KeystorePasswordKeys overrides DefaultKeystorePasswordKeys if set
+	KeystorePasswordKeys []string
 }
 
-func ScanSecrets(ctx context.Context, clientset *kubernetes.Clientset, namespace string, typesMatch []string) (*[]v1.Secret, error) {
-	client := clientset.CoreV1().Secrets(namespace)
-
-	var continuation string
-	results := make([]v1.Secret, 0)
-
-	for {
-		result, err := client.List(ctx, metav1.ListOptions{
-			Continue: continuation,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		for _, secret := range result.Items {
-			if arrayContains(&secret.Type, &typesMatch) {
-				results = append(results, secret)
-			}
-		}
-
-		if result.Continue == "" {
-			break
-		} else {
-			continuation = result.Continue
-		}
-	}
-	return &results, nil
-}
-
-func extractCertData(secret *v1.Secret) *[]byte {
-	if tlsData, haveTlsData := secret.Data["tls.crt"]; haveTlsData {
-		return &tlsData
-	} else {
-		return nil
+/**
+returns the sources that should be checked on a normal run
+*/
+func DefaultSources(clientset kubernetes.Interface, opts SourceOptions) []CertSource {
+	return []CertSource{
+		NewSecretSource(clientset, opts),
 	}
 }
 
-func ScanForCertificates(ctx context.Context, clientset *kubernetes.Clientset) (*[]CertData, error) {
-	namespacesPtr, nsErr := ScanNamespaces(ctx, clientset)
-	if nsErr != nil {
-		log.Fatal("ERROR Could not scan for namespaces: ", nsErr)
-	}
-
-	log.Printf("INFO Found %d namespaces", len(*namespacesPtr))
-
+/**
+runs each of the given sources in turn and gathers up all of the certificates they find.
+A source that fails is logged and skipped, so that one bad source does not prevent the others from being checked;
+an error is only returned if every source failed.
+*/
+func ScanForCertificates(ctx context.Context, sources []CertSource) (*[]CertData, error) {
 	results := make([]CertData, 0)
 
-	for _, namespace := range *namespacesPtr {
-		log.Printf("INFO Checking %s...", namespace.Name)
-		certSecrets, secretsErr := ScanSecrets(ctx, clientset, namespace.Name, []string{"Opaque", "kubernetes.io/tls"})
-		if secretsErr == nil {
-			log.Printf("INFO %s: found %d secrets that may be certs", namespace.Name, len(*certSecrets))
-			for _, cert := range *certSecrets {
-				certData := extractCertData(&cert)
-				if certData != nil {
-					results = append(results, CertData{
-						Namespace:          namespace.Name,
-						SecretName:         cert.Name,
-						RawCertificateData: *certData,
-					})
-				}
-			}
-		} else {
-			log.Printf("ERROR Could not scan for secrets in '%s': %s", namespace.Name, secretsErr)
+	var lastErr error
+	failures := 0
+	for _, source := range sources {
+		log.Printf("INFO Scanning %s...", source.Describe())
+		found, err := source.FindCertificates(ctx)
+		if err != nil {
+			log.Printf("ERROR Could not scan %s: %s", source.Describe(), err)
+			lastErr = err
+			failures += 1
+			continue
 		}
+		log.Printf("INFO %s: found %d certs", source.Describe(), len(*found))
+		results = append(results, *found...)
+	}
+
+	if failures > 0 && failures == len(sources) {
+		return nil, lastErr
 	}
 
 	log.Printf("INFO All certs gathered, found a total of %d", len(results))
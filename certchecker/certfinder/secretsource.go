@@ -0,0 +1,160 @@
+package certfinder
+
+import (
+	"context"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+)
+
+const CertManagerCertificateAnnotation = "cert-manager.io/certificate-name"
+
+/**
+SecretSource finds certificates stored in the `tls.crt` key of Secrets, or in PKCS#12 and Java keystores or
+kubeconfigs held in Secrets, across every namespace in the cluster
+*/
+type SecretSource struct {
+	Clientset            kubernetes.Interface
+	TypesMatch           []string
+	KeystorePasswordKeys []string
+}
+
+func NewSecretSource(clientset kubernetes.Interface, opts SourceOptions) *SecretSource {
+	passwordKeys := opts.KeystorePasswordKeys
+	if passwordKeys == nil {
+		passwordKeys = DefaultKeystorePasswordKeys
+	}
+	return &SecretSource{
+		Clientset:            clientset,
+		TypesMatch:           []string{"Opaque", "kubernetes.io/tls", "cluster.x-k8s.io/secret"},
+		KeystorePasswordKeys: passwordKeys,
+	}
+}
+
+func (s *SecretSource) Describe() string {
+	return "secrets"
+}
+
+func arrayContains(needle *v1.SecretType, haystack *[]string) bool {
+	for _, blade := range *haystack {
+		if string(*needle) == blade {
+			return true
+		}
+	}
+	return false
+}
+
+func ScanSecrets(ctx context.Context, clientset kubernetes.Interface, namespace string, typesMatch []string) (*[]v1.Secret, error) {
+	client := clientset.CoreV1().Secrets(namespace)
+
+	var continuation string
+	results := make([]v1.Secret, 0)
+
+	for {
+		result, err := client.List(ctx, metav1.ListOptions{
+			Continue: continuation,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, secret := range result.Items {
+			if arrayContains(&secret.Type, &typesMatch) {
+				results = append(results, secret)
+			}
+		}
+
+		if result.Continue == "" {
+			break
+		} else {
+			continuation = result.Continue
+		}
+	}
+	return &results, nil
+}
+
+func extractCertData(secret *v1.Secret) *[]byte {
+	if tlsData, haveTlsData := secret.Data[v1.TLSCertKey]; haveTlsData {
+		return &tlsData
+	} else {
+		return nil
+	}
+}
+
+/**
+works out what owns the given secret. A controller owner reference takes priority; failing that we look for the
+annotation that cert-manager puts on the secrets that it manages
+*/
+func secretOwner(secret *v1.Secret) *datapersistence.OwnerReference {
+	if ref := metav1.GetControllerOf(secret); ref != nil {
+		return &datapersistence.OwnerReference{
+			ApiVersion: ref.APIVersion,
+			Kind:       ref.Kind,
+			Name:       ref.Name,
+		}
+	}
+	if certName, haveCertName := secret.Annotations[CertManagerCertificateAnnotation]; haveCertName {
+		return &datapersistence.OwnerReference{
+			ApiVersion: "cert-manager.io/v1",
+			Kind:       "Certificate",
+			Name:       certName,
+		}
+	}
+	return nil
+}
+
+func SecretDescriptor(secret *v1.Secret, dataKey string) datapersistence.SourceDescriptor {
+	return datapersistence.SourceDescriptor{
+		Kind:      datapersistence.SourceSecret,
+		Namespace: secret.Namespace,
+		Name:      secret.Name,
+		DataKey:   dataKey,
+		Owner:     secretOwner(secret),
+	}
+}
+
+func (s *SecretSource) FindCertificates(ctx context.Context) (*[]CertData, error) {
+	namespacesPtr, nsErr := ScanNamespaces(ctx, s.Clientset)
+	if nsErr != nil {
+		log.Print("ERROR Could not scan for namespaces: ", nsErr)
+		return nil, nsErr
+	}
+
+	log.Printf("INFO Found %d namespaces", len(*namespacesPtr))
+
+	results := make([]CertData, 0)
+
+	for _, namespace := range *namespacesPtr {
+		log.Printf("INFO Checking %s...", namespace.Name)
+		certSecrets, secretsErr := ScanSecrets(ctx, s.Clientset, namespace.Name, s.TypesMatch)
+		if secretsErr == nil {
+			log.Printf("INFO %s: found %d secrets that may be certs", namespace.Name, len(*certSecrets))
+			for i := range *certSecrets {
+				secret := &(*certSecrets)[i]
+				secretCerts := make([]CertData, 0)
+				certData := extractCertData(secret)
+				if certData != nil {
+					secretCerts = append(secretCerts, CertData{
+						Source:             SecretDescriptor(secret, v1.TLSCertKey),
+						RawCertificateData: *certData,
+						RawKeyData:         secret.Data[v1.TLSPrivateKeyKey],
+					})
+				}
+				secretCerts = append(secretCerts, s.extractKeystoreCerts(ctx, secret)...)
+				secretCerts = append(secretCerts, extractKubeconfigCerts(secret)...)
+
+				overrides := ParseOverrides(namespace.Annotations, secret.Annotations)
+				for j := range secretCerts {
+					secretCerts[j].Overrides = overrides
+				}
+				results = append(results, secretCerts...)
+			}
+		} else {
+			log.Printf("ERROR Could not scan for secrets in '%s': %s", namespace.Name, secretsErr)
+		}
+	}
+
+	return &results, nil
+}
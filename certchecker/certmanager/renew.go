@@ -0,0 +1,235 @@
+package certmanager
+
+import (
+	"context"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/reissue"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// RevisionAnnotation is set by cert-manager on each CertificateRequest to the revision of the Certificate it is for
+	RevisionAnnotation = "cert-manager.io/certificate-revision"
+	// CertificateNameAnnotation is set by cert-manager on each CertificateRequest to the name of its Certificate
+	CertificateNameAnnotation = "cert-manager.io/certificate-name"
+
+	ConditionIssuing = "Issuing"
+	ConditionReady   = "Ready"
+	// ManuallyTriggeredReason is the reason that `cmctl renew` gives when it triggers a renewal
+	ManuallyTriggeredReason = "ManuallyTriggered"
+
+	RemediationAction = "cert-manager-renew"
+
+	// DefaultStuckAfter is how long a CertificateRequest can be outstanding before it is treated as stuck
+	DefaultStuckAfter = time.Hour
+)
+
+var CertificateResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+var CertificateRequestResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificaterequests"}
+
+/**
+Renewer gets cert-manager to renew the Certificates behind secrets that are near expiry, when cert-manager should
+already have renewed them but hasn't.  If no renewal is under way it triggers one the way `cmctl renew` does, by
+setting the Certificate's Issuing condition.  If one is under way but its CertificateRequest has been outstanding for
+longer than StuckAfter, the request is deleted so that cert-manager creates a new one
+*/
+type Renewer struct {
+	Dynamic    dynamic.Interface
+	DryRun     bool
+	StuckAfter time.Duration
+	Now        func() time.Time
+}
+
+func NewRenewer(client dynamic.Interface, dryRun bool, stuckAfter time.Duration) *Renewer {
+	if stuckAfter == 0 {
+		stuckAfter = DefaultStuckAfter
+	}
+	return &Renewer{
+		Dynamic:    client,
+		DryRun:     dryRun,
+		StuckAfter: stuckAfter,
+		Now:        time.Now,
+	}
+}
+
+func ownedByCertificate(rec *datapersistence.CheckRecord) bool {
+	return datapersistence.IsAutoRenewed(rec) && reissue.NeedsReplacing(rec)
+}
+
+/**
+triggers the renewal of the Certificate behind every near-expiry secret in the results that needs it, returning a
+record of what was done.  Each Certificate is only looked at once, even if its secret has several results
+*/
+func (r *Renewer) RenewAll(ctx context.Context, results []datapersistence.CheckRecord) []datapersistence.RemediationRecord {
+	remediations := make([]datapersistence.RemediationRecord, 0)
+	seen := make(map[string]bool)
+	for i := range results {
+		rec := &results[i]
+		if !ownedByCertificate(rec) {
+			continue
+		}
+		key := rec.Source.Namespace + "/" + rec.Source.Owner.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		remediation := r.renew(ctx, rec)
+		if remediation == nil {
+			continue
+		}
+		if remediation.Error != "" {
+			log.Printf("ERROR Could not renew Certificate %s for %s: %s", key, rec.Source, remediation.Error)
+		} else {
+			log.Printf("INFO %s: %s", rec.Source, remediation.Details)
+		}
+		remediations = append(remediations, *remediation)
+	}
+	return remediations
+}
+
+func findCondition(certificate *unstructured.Unstructured, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(certificate.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, isMap := c.(map[string]interface{})
+		if isMap && condition["type"] == conditionType {
+			return condition
+		}
+	}
+	return nil
+}
+
+/**
+the time at which cert-manager should have started renewing the Certificate, or nil if it hasn't said
+*/
+func renewalTime(certificate *unstructured.Unstructured) *time.Time {
+	value, found, _ := unstructured.NestedString(certificate.Object, "status", "renewalTime")
+	if !found {
+		return nil
+	}
+	parsed, parseErr := time.Parse(time.RFC3339, value)
+	if parseErr != nil {
+		return nil
+	}
+	return &parsed
+}
+
+/**
+renews the Certificate behind a single record.  Returns nil if nothing needed doing, e.g. because cert-manager isn't
+due to renew it yet or a renewal has only just started
+*/
+func (r *Renewer) renew(ctx context.Context, rec *datapersistence.CheckRecord) *datapersistence.RemediationRecord {
+	now := r.Now()
+	remediation := &datapersistence.RemediationRecord{
+		Cluster: rec.Cluster,
+		Source:  rec.Source,
+		Action:  RemediationAction,
+		At:      now,
+		DryRun:  r.DryRun,
+	}
+	fail := func(err error) *datapersistence.RemediationRecord {
+		remediation.Error = err.Error()
+		return remediation
+	}
+
+	client := r.Dynamic.Resource(CertificateResource).Namespace(rec.Source.Namespace)
+	certificate, getErr := client.Get(ctx, rec.Source.Owner.Name, metav1.GetOptions{})
+	if getErr != nil {
+		return fail(fmt.Errorf("could not get Certificate %s: %s", rec.Source.Owner.Name, getErr))
+	}
+
+	if due := renewalTime(certificate); due != nil && due.After(now) {
+		log.Printf("INFO Certificate %s/%s is not due for renewal by cert-manager until %s, leaving it alone", rec.Source.Namespace, rec.Source.Owner.Name, due.Format(time.RFC3339))
+		return nil
+	}
+
+	if issuing := findCondition(certificate, ConditionIssuing); issuing != nil && issuing["status"] == string(metav1.ConditionTrue) {
+		return r.unstick(ctx, certificate, remediation, fail)
+	}
+
+	if r.DryRun {
+		remediation.Details = fmt.Sprintf("would trigger renewal of Certificate %s (dry run)", certificate.GetName())
+		return remediation
+	}
+	if err := setIssuing(certificate, now); err != nil {
+		return fail(err)
+	}
+	if _, updateErr := client.UpdateStatus(ctx, certificate, metav1.UpdateOptions{}); updateErr != nil {
+		return fail(fmt.Errorf("could not set the Issuing condition on Certificate %s: %s", certificate.GetName(), updateErr))
+	}
+	remediation.Details = fmt.Sprintf("triggered renewal of Certificate %s", certificate.GetName())
+	return remediation
+}
+
+/**
+sets the Issuing condition on the Certificate, as `cmctl renew` does
+*/
+func setIssuing(certificate *unstructured.Unstructured, now time.Time) error {
+	conditions, _, _ := unstructured.NestedSlice(certificate.Object, "status", "conditions")
+	issuing := map[string]interface{}{
+		"type":               ConditionIssuing,
+		"status":             string(metav1.ConditionTrue),
+		"reason":             ManuallyTriggeredReason,
+		"message":            "Certificate re-issuance triggered by certchecker",
+		"lastTransitionTime": now.UTC().Format(time.RFC3339),
+		"observedGeneration": certificate.GetGeneration(),
+	}
+	updated := make([]interface{}, 0, len(conditions)+1)
+	for _, c := range conditions {
+		if condition, isMap := c.(map[string]interface{}); isMap && condition["type"] == ConditionIssuing {
+			continue
+		}
+		updated = append(updated, c)
+	}
+	updated = append(updated, issuing)
+	return unstructured.SetNestedSlice(certificate.Object, updated, "status", "conditions")
+}
+
+/**
+a renewal is under way; if the CertificateRequest for it has been outstanding for too long, delete it so that
+cert-manager tries again
+*/
+func (r *Renewer) unstick(ctx context.Context, certificate *unstructured.Unstructured, remediation *datapersistence.RemediationRecord, fail func(error) *datapersistence.RemediationRecord) *datapersistence.RemediationRecord {
+	revision, _, _ := unstructured.NestedInt64(certificate.Object, "status", "revision")
+	nextRevision := strconv.FormatInt(revision+1, 10)
+
+	requests, listErr := r.Dynamic.Resource(CertificateRequestResource).Namespace(certificate.GetNamespace()).List(ctx, metav1.ListOptions{})
+	if listErr != nil {
+		return fail(fmt.Errorf("could not list CertificateRequests: %s", listErr))
+	}
+	for i := range requests.Items {
+		request := &requests.Items[i]
+		annotations := request.GetAnnotations()
+		if annotations[CertificateNameAnnotation] != certificate.GetName() || annotations[RevisionAnnotation] != nextRevision {
+			continue
+		}
+		if ready := findCondition(request, ConditionReady); ready != nil && ready["status"] == string(metav1.ConditionTrue) {
+			continue
+		}
+		age := remediation.At.Sub(request.GetCreationTimestamp().Time)
+		if age < r.StuckAfter {
+			log.Printf("INFO Certificate %s/%s is being renewed by %s, waiting for it", certificate.GetNamespace(), certificate.GetName(), request.GetName())
+			return nil
+		}
+
+		if r.DryRun {
+			remediation.Details = fmt.Sprintf("would delete CertificateRequest %s, outstanding for %s (dry run)", request.GetName(), age.Round(time.Minute))
+			return remediation
+		}
+		if deleteErr := r.Dynamic.Resource(CertificateRequestResource).Namespace(request.GetNamespace()).Delete(ctx, request.GetName(), metav1.DeleteOptions{}); deleteErr != nil {
+			return fail(fmt.Errorf("could not delete stuck CertificateRequest %s: %s", request.GetName(), deleteErr))
+		}
+		remediation.Details = fmt.Sprintf("deleted CertificateRequest %s, which had been outstanding for %s", request.GetName(), age.Round(time.Minute))
+		return remediation
+	}
+
+	log.Printf("INFO Certificate %s/%s is already being renewed", certificate.GetNamespace(), certificate.GetName())
+	return nil
+}
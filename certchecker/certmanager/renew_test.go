@@ -0,0 +1,143 @@
+package certmanager
+
+import (
+	"context"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+func newFakeClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		CertificateResource:        "CertificateList",
+		CertificateRequestResource: "CertificateRequestList",
+	}, objects...)
+}
+
+func makeCertificate(name string, renewalTime time.Time, revision int64, conditions ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": name},
+		"status": map[string]interface{}{
+			"renewalTime": renewalTime.Format(time.RFC3339),
+			"revision":    revision,
+			"conditions":  conditions,
+		},
+	}}
+}
+
+func makeRequest(name string, certificate string, revision string, created time.Time) *unstructured.Unstructured {
+	request := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "CertificateRequest",
+		"metadata": map[string]interface{}{
+			"namespace": "web",
+			"name":      name,
+			"annotations": map[string]interface{}{
+				CertificateNameAnnotation: certificate,
+				RevisionAnnotation:        revision,
+			},
+		},
+	}}
+	request.SetCreationTimestamp(metav1.NewTime(created))
+	return request
+}
+
+func managedRecord(secret string, certificate string, result datapersistence.ValidationResult) datapersistence.CheckRecord {
+	return datapersistence.CheckRecord{
+		Source: datapersistence.SourceDescriptor{
+			Kind:      datapersistence.SourceSecret,
+			Namespace: "web",
+			Name:      secret,
+			DataKey:   v1.TLSCertKey,
+			Owner:     &datapersistence.OwnerReference{ApiVersion: "cert-manager.io/v1", Kind: "Certificate", Name: certificate},
+		},
+		CheckResult: result,
+	}
+}
+
+func newTestRenewer(client *dynamicfake.FakeDynamicClient, dryRun bool) *Renewer {
+	renewer := NewRenewer(client, dryRun, 0)
+	renewer.Now = func() time.Time { return testNow }
+	return renewer
+}
+
+func TestRenewTriggersIssuing(t *testing.T) {
+	client := newFakeClient(
+		makeCertificate("overdue", testNow.Add(-48*time.Hour), 3, map[string]interface{}{"type": ConditionReady, "status": "True"}),
+		makeCertificate("not-due", testNow.Add(48*time.Hour), 1),
+	)
+	results := []datapersistence.CheckRecord{
+		managedRecord("overdue-tls", "overdue", datapersistence.NearExpiry),
+		managedRecord("overdue-tls", "overdue", datapersistence.NearExpiry),
+		managedRecord("not-due-tls", "not-due", datapersistence.NearExpiry),
+	}
+
+	remediations := newTestRenewer(client, false).RenewAll(context.Background(), results)
+	if len(remediations) != 1 {
+		t.Fatalf("expected one renewal, got %+v", remediations)
+	}
+	if remediations[0].Error != "" || remediations[0].Action != RemediationAction {
+		t.Errorf("unexpected remediation %+v", remediations[0])
+	}
+
+	certificate, _ := client.Resource(CertificateResource).Namespace("web").Get(context.Background(), "overdue", metav1.GetOptions{})
+	issuing := findCondition(certificate, ConditionIssuing)
+	if issuing == nil || issuing["status"] != "True" || issuing["reason"] != ManuallyTriggeredReason {
+		t.Errorf("expected the Issuing condition to be set, got %v", issuing)
+	}
+	if findCondition(certificate, ConditionReady) == nil {
+		t.Error("expected the other conditions to be kept")
+	}
+
+	notDue, _ := client.Resource(CertificateResource).Namespace("web").Get(context.Background(), "not-due", metav1.GetOptions{})
+	if findCondition(notDue, ConditionIssuing) != nil {
+		t.Error("a Certificate that cert-manager isn't due to renew yet should be left alone")
+	}
+}
+
+func TestRenewDeletesStuckRequest(t *testing.T) {
+	issuing := map[string]interface{}{"type": ConditionIssuing, "status": "True"}
+	client := newFakeClient(
+		makeCertificate("stuck", testNow.Add(-48*time.Hour), 3, issuing),
+		makeRequest("stuck-old", "stuck", "3", testNow.Add(-30*24*time.Hour)),
+		makeRequest("stuck-next", "stuck", "4", testNow.Add(-3*time.Hour)),
+		makeCertificate("busy", testNow.Add(-time.Hour), 0, issuing),
+		makeRequest("busy-next", "busy", "1", testNow.Add(-10*time.Minute)),
+	)
+	results := []datapersistence.CheckRecord{
+		managedRecord("stuck-tls", "stuck", datapersistence.Critical),
+		managedRecord("busy-tls", "busy", datapersistence.AfterExpiry),
+	}
+
+	remediations := newTestRenewer(client, true).RenewAll(context.Background(), results)
+	if len(remediations) != 1 || !remediations[0].DryRun {
+		t.Fatalf("expected one dry-run remediation, got %+v", remediations)
+	}
+	requests, _ := client.Resource(CertificateRequestResource).Namespace("web").List(context.Background(), metav1.ListOptions{})
+	if len(requests.Items) != 3 {
+		t.Fatalf("a dry run should not delete anything, got %d requests", len(requests.Items))
+	}
+
+	remediations = newTestRenewer(client, false).RenewAll(context.Background(), results)
+	if len(remediations) != 1 || remediations[0].Error != "" {
+		t.Fatalf("expected the stuck request to be deleted, got %+v", remediations)
+	}
+	requests, _ = client.Resource(CertificateRequestResource).Namespace("web").List(context.Background(), metav1.ListOptions{})
+	remaining := make(map[string]bool)
+	for _, request := range requests.Items {
+		remaining[request.GetName()] = true
+	}
+	if remaining["stuck-next"] || !remaining["stuck-old"] || !remaining["busy-next"] {
+		t.Errorf("expected only stuck-next to be deleted, have %v", remaining)
+	}
+}
@@ -1,16 +1,19 @@
 package certs
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"github.com/guardian/k8s-certchecker/datapersistence"
 	"log"
+	"sort"
+	"strings"
 	"time"
 )
 
-const ChromeMaxValidityHours = 398 * 24
-
 func LoadCert(certPEM []byte, description string) (*x509.Certificate, bool, error) {
 	block, _ := pem.Decode(certPEM)
 	if block == nil {
@@ -35,54 +38,211 @@ func LoadCert(certPEM []byte, description string) (*x509.Certificate, bool, erro
 
 // ValidateCertTimes
 /*
-decodes the certificate from the given PEM block and checks if it is expired or nearly expired.
+checks the certificate's dates as they stand at the time `at`: whether it is expired, nearly expired or not valid
+yet, and whether its lifetime is longer than the applicable lifetime policy allows.  Passing a future time gives a
+forecast of what the state will be then.
 
-returns a constant of enum ValidationResult indicating the status - Errored, NotValidYet, WithinRange, NearExpiry or AfterExpiry.
+every problem is recorded as a Finding on the returned record. CheckResult is then set from the most severe of those
+findings - one of NotValidYet, AfterExpiry, Critical, NearExpiry or ExceedsMaxLifetime - or WithinRange if there were none.
 
 arguments:
-- certPEM: byte block of the raw PEM data
-- warningPeriod: time.Duration indicating the "near expiry" period.  If the cert NotAfter date is before this time added to the
-current time, then the result is NearExpiry
-- description: descriptive string for logging
+- cert: the decoded certificate
+- at: the reference time to check against, normally time.Now(). This is recorded as CheckedAt
+- thresholds: the "near expiry" thresholds, each either a period before NotAfter or a percentage of the lifetime
+used. Past the warning threshold there is a NearExpiry finding; past the critical one there is a Critical finding
+instead.  The threshold that was crossed is recorded in TriggeredThreshold
+- lifetimes: the maximum lifetime rules to check the cert against. If the cert is longer-lived than the applicable
+policy allows, there is an ExceedsMaxLifetime finding
+- source: describes where the certificate came from, this is copied onto the returned record
 */
-func ValidateCertTimes(cert *x509.Certificate, warningPeriod time.Duration, certName string, secretName string) (datapersistence.CheckRecord, error) {
-	nowTime := time.Now()
-	warnTime := nowTime.Add(warningPeriod)
-
-	log.Printf("INFO LoadCert %s is %f%% used", secretName, PercentUsed(&cert.NotBefore, &cert.NotAfter))
+func ValidateCertTimes(cert *x509.Certificate, at time.Time, thresholds Thresholds, lifetimes *LifetimeRules, source datapersistence.SourceDescriptor) (datapersistence.CheckRecord, error) {
+	percentUsed := PercentUsed(&cert.NotBefore, &cert.NotAfter, at)
+	log.Printf("INFO LoadCert %s is %f%% used", source, percentUsed)
 	rec := datapersistence.CheckRecord{
-		Namespace:        certName,
-		SecretName:       secretName,
-		CheckedAt:        time.Now(),
-		CheckResult:      0,
-		ValidUntil:       cert.NotAfter,
-		PercentUsed:      PercentUsed(&cert.NotBefore, &cert.NotAfter),
-		TooLongForChrome: false,
+		Namespace:          source.Namespace,
+		SecretName:         secretNameFor(source),
+		Source:             source,
+		CheckedAt:          at,
+		CheckResult:        0,
+		ValidUntil:         cert.NotAfter,
+		Fingerprint:        Fingerprint(cert),
+		Identity:           Identity(cert),
+		PublicKeyHash:      PublicKeyFingerprint(cert),
+		IsCA:               cert.IsCA,
+		PercentUsed:        percentUsed,
+		ExceedsMaxLifetime: false,
+		Findings:           make([]datapersistence.Finding, 0),
+	}
+
+	findings := make([]datapersistence.Finding, 0)
+	if at.Before(cert.NotBefore) {
+		findings = append(findings, datapersistence.Finding{
+			Code:     datapersistence.NotValidYetFinding,
+			Severity: datapersistence.SeverityCritical,
+			Result:   datapersistence.ResultOf(datapersistence.NotValidYet),
+			Message:  fmt.Sprintf("certificate is not valid until %s", cert.NotBefore.Format(time.RFC3339)),
+		})
+	} else if at.After(cert.NotAfter) {
+		findings = append(findings, datapersistence.Finding{
+			Code:     datapersistence.ExpiredFinding,
+			Severity: datapersistence.SeverityCritical,
+			Result:   datapersistence.ResultOf(datapersistence.AfterExpiry),
+			Message:  fmt.Sprintf("certificate expired at %s", cert.NotAfter.Format(time.RFC3339)),
+		})
+	} else if thresholds.Critical.Triggered(cert, at) {
+		rec.TriggeredThreshold = "critical " + thresholds.Critical.String()
+		findings = append(findings, datapersistence.Finding{
+			Code:     datapersistence.CriticalExpiryFinding,
+			Severity: datapersistence.SeverityCritical,
+			Result:   datapersistence.ResultOf(datapersistence.Critical),
+			Message:  fmt.Sprintf("certificate expires at %s, past the critical threshold of %s", cert.NotAfter.Format(time.RFC3339), thresholds.Critical),
+		})
+	} else if thresholds.Warning.Triggered(cert, at) {
+		rec.TriggeredThreshold = "warning " + thresholds.Warning.String()
+		findings = append(findings, datapersistence.Finding{
+			Code:     datapersistence.NearExpiryFinding,
+			Severity: datapersistence.SeverityWarning,
+			Result:   datapersistence.ResultOf(datapersistence.NearExpiry),
+			Message:  fmt.Sprintf("certificate expires at %s, past the warning threshold of %s", cert.NotAfter.Format(time.RFC3339), thresholds.Warning),
+		})
+	}
+
+	if violated := lifetimes.Violated(cert); violated != nil {
+		rec.ExceedsMaxLifetime = true
+		rec.LifetimePolicy = violated.Name
+		findings = append(findings, datapersistence.Finding{
+			Code:     "ExceedsMaxLifetime",
+			Severity: datapersistence.SeverityWarning,
+			Result:   datapersistence.ResultOf(datapersistence.ExceedsMaxLifetime),
+			Message:  fmt.Sprintf("certificate lifetime is longer than the %d days allowed by %s", violated.MaxDays, violated.Name),
+		})
+	}
+
+	AddFindings(&rec, findings...)
+	return rec, nil
+}
+
+/**
+how seriously each ValidationResult is taken when more than one applies to the same certificate, so that CheckResult
+shows the worst of them.  Findings of the same severity that say something specific about the cert's dates outrank
+the general HasWarnings and HasCriticalFindings
+*/
+var resultPriority = map[datapersistence.ValidationResult]int{
+	datapersistence.WithinRange:         0,
+	datapersistence.HasWarnings:         1,
+	datapersistence.ExceedsMaxLifetime:  2,
+	datapersistence.StaleCertInUse:      3,
+	datapersistence.NearExpiry:          4,
+	datapersistence.HasCriticalFindings: 5,
+	datapersistence.Critical:            6,
+	datapersistence.NotValidYet:         7,
+	datapersistence.AfterExpiry:         8,
+	datapersistence.Errored:             9,
+}
+
+/**
+the ValidationResult that a finding counts as: its own Result if it has one, otherwise one that reflects its severity
+*/
+func findingResult(finding *datapersistence.Finding) datapersistence.ValidationResult {
+	if finding.Result != nil {
+		return *finding.Result
+	}
+	switch finding.Severity {
+	case datapersistence.SeverityCritical:
+		return datapersistence.HasCriticalFindings
+	case datapersistence.SeverityWarning:
+		return datapersistence.HasWarnings
+	default:
+		return datapersistence.WithinRange
 	}
+}
 
-	if nowTime.Before(cert.NotBefore) {
-		rec.CheckResult = datapersistence.NotValidYet
-		return rec, nil
-	} else if nowTime.After(cert.NotAfter) {
-		rec.CheckResult = datapersistence.AfterExpiry
-		return rec, nil
-	} else if warnTime.After(cert.NotAfter) {
-		rec.CheckResult = datapersistence.NearExpiry
-		return rec, nil
-	} else {
-		if cert.NotAfter.Sub(cert.NotBefore).Hours() > ChromeMaxValidityHours {
-			rec.CheckResult = datapersistence.TooLongForChrome
-			rec.TooLongForChrome = true
-			return rec, nil
-		} else {
-			rec.CheckResult = datapersistence.WithinRange
-			return rec, nil
+/**
+appends the given findings to the record and then recalculates its overall Severity and CheckResult from all of the
+findings it now has, so that CheckResult always reflects the worst of them
+*/
+func AddFindings(rec *datapersistence.CheckRecord, findings ...datapersistence.Finding) {
+	rec.Findings = append(rec.Findings, findings...)
+	rec.Severity, rec.CheckResult = WorstOf(rec.Findings)
+}
+
+/**
+the overall Severity and CheckResult of a record with the given findings: the highest severity, and the result of
+the worst finding.  No findings at all is SeverityInfo and WithinRange
+*/
+func WorstOf(findings []datapersistence.Finding) (datapersistence.Severity, datapersistence.ValidationResult) {
+	severity := datapersistence.SeverityInfo
+	var worst *datapersistence.Finding
+	for i := range findings {
+		finding := &findings[i]
+		if finding.Severity > severity {
+			severity = finding.Severity
 		}
+		if worst == nil || finding.Severity > worst.Severity ||
+			(finding.Severity == worst.Severity && resultPriority[findingResult(finding)] > resultPriority[findingResult(worst)]) {
+			worst = finding
+		}
+	}
+	if worst == nil {
+		return severity, datapersistence.WithinRange
+	}
+	return severity, findingResult(worst)
+}
+
+/**
+the legacy `secretName` field is only filled in when the certificate really did come from a Secret
+*/
+func secretNameFor(source datapersistence.SourceDescriptor) string {
+	if source.Kind == datapersistence.SourceSecret {
+		return source.Name
 	}
+	return ""
 }
 
-func PercentUsed(notBefore *time.Time, notAfter *time.Time) float64 {
+/**
+returns how much of the period between notBefore and notAfter has passed at the time `at`, as a percentage
+*/
+func PercentUsed(notBefore *time.Time, notAfter *time.Time, at time.Time) float64 {
 	certDuration := notAfter.Sub(*notBefore)
-	usedDuration := time.Now().Sub(*notBefore)
+	usedDuration := at.Sub(*notBefore)
 	return (usedDuration.Seconds() / certDuration.Seconds()) * 100
 }
+
+/**
+returns the hex-encoded SHA-256 fingerprint of the DER form of the certificate
+*/
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+/**
+returns the hex-encoded SHA-256 fingerprint of the certificate's public key.  Certificates with the same public key
+share a private key
+*/
+func PublicKeyFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+/**
+describes what a certificate is for, regardless of when it was issued: its subject followed by its sorted subject
+alternative names.  Renewals and copies of the same cert have the same identity
+*/
+func Identity(cert *x509.Certificate) string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses)+len(cert.EmailAddresses)+len(cert.URIs))
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+strings.ToLower(name))
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	sort.Strings(sans)
+	return fmt.Sprintf("%s [%s]", cert.Subject.String(), strings.Join(sans, ", "))
+}
@@ -4,10 +4,14 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"github.com/guardian/k8s-certchecker/datapersistence"
+	"net"
 	"testing"
 	"time"
 )
 
+//a fixed reference time, so that the tests don't depend on when they are run
+var testNow = time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
 func TestValidateCertTimesExpired(t *testing.T) {
 	fakeStartTime, err := time.Parse(time.RFC3339, "2020-01-01T00:00:00Z")
 	if err != nil {
@@ -43,8 +47,202 @@ func TestValidateCertTimesExpired(t *testing.T) {
 
 	warnTime := time.Duration(3600 * time.Second)
 
-	result, err := ValidateCertTimes(&fakeCert, warnTime, "test", "test")
+	source := datapersistence.SourceDescriptor{
+		Kind:      datapersistence.SourceSecret,
+		Namespace: "test",
+		Name:      "test",
+	}
+
+	result, err := ValidateCertTimes(&fakeCert, testNow, Thresholds{Warning: Threshold{Period: warnTime}}, DefaultLifetimeRules(), source)
 	if result.CheckResult != datapersistence.AfterExpiry {
 		t.Errorf("ValidateCertTimes gave wrong result, expected %d (AfterExpiry) got %d", datapersistence.AfterExpiry, result.CheckResult)
 	}
 }
+
+func TestValidateCertTimesMultipleFindings(t *testing.T) {
+	//issued a year ago with 400 days validity, so it is both near expiry and longer than the 398 day limit
+	fakeCert := x509.Certificate{
+		NotBefore: testNow.Add(-365 * 24 * time.Hour),
+		NotAfter:  testNow.Add(35 * 24 * time.Hour),
+	}
+	rules := &LifetimeRules{Policies: []LifetimePolicy{{Name: "test 398", MaxDays: 398}}}
+
+	result, err := ValidateCertTimes(&fakeCert, testNow, Thresholds{Warning: Threshold{Period: 60 * 24 * time.Hour}}, rules, datapersistence.SourceDescriptor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CheckResult != datapersistence.NearExpiry {
+		t.Errorf("expected overall result NearExpiry, got %d", result.CheckResult)
+	}
+	if !result.ExceedsMaxLifetime || result.LifetimePolicy != "test 398" {
+		t.Errorf("expected the lifetime policy to be violated too")
+	}
+	if len(result.Findings) != 2 {
+		t.Errorf("expected 2 findings, got %d: %v", len(result.Findings), result.Findings)
+	}
+	if result.Severity != datapersistence.SeverityWarning {
+		t.Errorf("expected overall severity warning, got %s", result.Severity)
+	}
+
+	AddFindings(&result, datapersistence.Finding{Code: "WeakRSAKey", Severity: datapersistence.SeverityCritical})
+	if result.Severity != datapersistence.SeverityCritical {
+		t.Errorf("a critical policy finding should raise the overall severity, got %s", result.Severity)
+	}
+	if result.CheckResult != datapersistence.HasCriticalFindings {
+		t.Errorf("a critical policy finding should outrank a warning about the dates, got %s", result.CheckResult)
+	}
+
+	AddFindings(&result, datapersistence.Finding{Code: "Expired", Severity: datapersistence.SeverityCritical, Result: datapersistence.ResultOf(datapersistence.AfterExpiry)})
+	if result.CheckResult != datapersistence.AfterExpiry {
+		t.Errorf("a critical date-based result should outrank a critical policy finding, got %s", result.CheckResult)
+	}
+}
+
+func TestAddFindingsWithoutResults(t *testing.T) {
+	rec := datapersistence.CheckRecord{}
+	AddFindings(&rec, datapersistence.Finding{Code: "Unused", Severity: datapersistence.SeverityInfo})
+	if rec.CheckResult != datapersistence.WithinRange {
+		t.Errorf("an info finding should leave the cert within range, got %s", rec.CheckResult)
+	}
+	AddFindings(&rec, datapersistence.Finding{Code: "ReusedKey", Severity: datapersistence.SeverityWarning})
+	if rec.CheckResult != datapersistence.HasWarnings {
+		t.Errorf("expected a warning finding to give HasWarnings, got %s", rec.CheckResult)
+	}
+
+	unreadable := datapersistence.CheckRecord{}
+	AddFindings(&unreadable, datapersistence.Finding{Code: "Unreadable", Severity: datapersistence.SeverityCritical, Result: datapersistence.ResultOf(datapersistence.Errored)})
+	AddFindings(&unreadable, datapersistence.Finding{Code: "Unused", Severity: datapersistence.SeverityInfo})
+	if unreadable.CheckResult != datapersistence.Errored {
+		t.Errorf("an Errored finding should be kept, got %s", unreadable.CheckResult)
+	}
+}
+
+func TestValidateCertTimesCriticalOverride(t *testing.T) {
+	fakeCert := x509.Certificate{
+		NotBefore: testNow.Add(-60 * 24 * time.Hour),
+		NotAfter:  testNow.Add(5 * 24 * time.Hour),
+	}
+	critical := Threshold{Period: 7 * 24 * time.Hour}
+	thresholds := Thresholds{Warning: Threshold{Period: 30 * 24 * time.Hour}}.WithOverrides(&Overrides{Critical: &critical})
+
+	result, err := ValidateCertTimes(&fakeCert, testNow, thresholds, DefaultLifetimeRules(), datapersistence.SourceDescriptor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CheckResult != datapersistence.Critical || result.Severity != datapersistence.SeverityCritical {
+		t.Errorf("expected a Critical result, got result %d severity %s", result.CheckResult, result.Severity)
+	}
+	if result.TriggeredThreshold != "critical 168h0m0s" {
+		t.Errorf("expected the critical threshold to be recorded, got '%s'", result.TriggeredThreshold)
+	}
+}
+
+func TestValidateCertTimesPercentThresholds(t *testing.T) {
+	//a 100-day cert with 85 days gone, so 85% used but still 15 days left
+	fakeCert := x509.Certificate{
+		NotBefore: testNow.Add(-85 * 24 * time.Hour),
+		NotAfter:  testNow.Add(15 * 24 * time.Hour),
+	}
+	thresholds := Thresholds{
+		Warning:  Threshold{PercentUsed: 80},
+		Critical: Threshold{PercentUsed: 90},
+	}
+
+	result, err := ValidateCertTimes(&fakeCert, testNow, thresholds, DefaultLifetimeRules(), datapersistence.SourceDescriptor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CheckResult != datapersistence.NearExpiry || result.Severity != datapersistence.SeverityWarning {
+		t.Errorf("expected a NearExpiry warning, got result %d severity %s", result.CheckResult, result.Severity)
+	}
+	if result.TriggeredThreshold != "warning 80%" {
+		t.Errorf("expected the warning threshold to be recorded, got '%s'", result.TriggeredThreshold)
+	}
+
+	thresholds.Critical = Threshold{PercentUsed: 85}
+	result, _ = ValidateCertTimes(&fakeCert, testNow, thresholds, DefaultLifetimeRules(), datapersistence.SourceDescriptor{})
+	if result.CheckResult != datapersistence.Critical {
+		t.Errorf("expected a Critical result at 85%% used, got %d", result.CheckResult)
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	percent, err := ParseThreshold("75%")
+	if err != nil || percent.PercentUsed != 75 || percent.Period != 0 {
+		t.Errorf("expected 75%% used, got %+v (%v)", percent, err)
+	}
+	period, err := ParseThreshold("30d")
+	if err != nil || period.Period != 30*24*time.Hour || period.PercentUsed != 0 {
+		t.Errorf("expected 30 days, got %+v (%v)", period, err)
+	}
+	empty, err := ParseThreshold("")
+	if err != nil || empty.IsSet() {
+		t.Errorf("expected an empty threshold to be unset, got %+v (%v)", empty, err)
+	}
+	for _, bad := range []string{"150%", "x%", "soon"} {
+		if _, err := ParseThreshold(bad); err == nil {
+			t.Errorf("expected '%s' to be rejected", bad)
+		}
+	}
+}
+
+func TestValidateCertTimesAsOf(t *testing.T) {
+	fakeCert := x509.Certificate{
+		NotBefore: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	thresholds := Thresholds{Warning: Threshold{Period: 30 * 24 * time.Hour}}
+	rules := &LifetimeRules{}
+
+	result, _ := ValidateCertTimes(&fakeCert, time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), thresholds, rules, datapersistence.SourceDescriptor{})
+	if result.CheckResult != datapersistence.WithinRange {
+		t.Errorf("expected WithinRange in June, got %d", result.CheckResult)
+	}
+
+	asOf := time.Date(2026, 12, 15, 0, 0, 0, 0, time.UTC)
+	result, _ = ValidateCertTimes(&fakeCert, asOf, thresholds, rules, datapersistence.SourceDescriptor{})
+	if result.CheckResult != datapersistence.NearExpiry {
+		t.Errorf("expected NearExpiry as of mid-December, got %d", result.CheckResult)
+	}
+	if !result.CheckedAt.Equal(asOf) {
+		t.Errorf("expected CheckedAt to be the reference time, got %s", result.CheckedAt)
+	}
+
+	result, _ = ValidateCertTimes(&fakeCert, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), thresholds, rules, datapersistence.SourceDescriptor{})
+	if result.CheckResult != datapersistence.AfterExpiry {
+		t.Errorf("expected AfterExpiry in 2027, got %d", result.CheckResult)
+	}
+}
+
+func TestPercentUsed(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(100 * 24 * time.Hour)
+	if used := PercentUsed(&notBefore, &notAfter, notBefore.Add(25*24*time.Hour)); used != 25 {
+		t.Errorf("expected 25%% used, got %f", used)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	original := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "www.example.com"},
+		DNSNames:    []string{"www.example.com", "Example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		NotAfter:    testNow,
+	}
+	renewed := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "www.example.com"},
+		DNSNames:    []string{"example.com", "www.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		NotAfter:    testNow.AddDate(0, 3, 0),
+	}
+	if Identity(original) != Identity(renewed) {
+		t.Errorf("expected a renewal to keep the identity, got '%s' and '%s'", Identity(original), Identity(renewed))
+	}
+	if Identity(original) != "CN=www.example.com [DNS:example.com, DNS:www.example.com, IP:10.0.0.1]" {
+		t.Errorf("unexpected identity '%s'", Identity(original))
+	}
+	renewed.DNSNames = append(renewed.DNSNames, "api.example.com")
+	if Identity(original) == Identity(renewed) {
+		t.Error("a cert with more SANs should have a different identity")
+	}
+}
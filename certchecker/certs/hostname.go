@@ -0,0 +1,55 @@
+package certs
+
+import (
+	"crypto/x509"
+	"net"
+	"strings"
+)
+
+func normaliseHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
+
+/**
+returns true if the cert is valid for `hostname`.  A wildcard SAN covers a single label, so `*.example.com` covers
+`www.example.com` but not `example.com` or `a.b.example.com`.  A wildcard hostname, as a Gateway listener may have,
+is only covered by the same wildcard
+*/
+func CoversHostname(cert *x509.Certificate, hostname string) bool {
+	hostname = normaliseHostname(hostname)
+	if ip := net.ParseIP(hostname); ip != nil {
+		for _, certIP := range cert.IPAddresses {
+			if certIP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, san := range cert.DNSNames {
+		san = normaliseHostname(san)
+		if san == hostname {
+			return true
+		}
+		if !strings.HasPrefix(san, "*.") || strings.HasPrefix(hostname, "*.") {
+			continue
+		}
+		if dot := strings.Index(hostname, "."); dot > 0 && hostname[dot+1:] == san[2:] {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+returns those of the hostnames that the cert is not valid for
+*/
+func UncoveredHostnames(cert *x509.Certificate, hostnames []string) []string {
+	uncovered := make([]string, 0)
+	for _, hostname := range hostnames {
+		if !CoversHostname(cert, hostname) {
+			uncovered = append(uncovered, hostname)
+		}
+	}
+	return uncovered
+}
@@ -0,0 +1,31 @@
+package certs
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+)
+
+func TestCoversHostname(t *testing.T) {
+	cert := &x509.Certificate{
+		DNSNames:    []string{"example.com", "*.apps.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	expected := map[string]bool{
+		"example.com":          true,
+		"Example.COM.":         true,
+		"www.example.com":      false,
+		"web.apps.example.com": true,
+		"a.b.apps.example.com": false,
+		"apps.example.com":     false,
+		"*.apps.example.com":   true,
+		"*.example.com":        false,
+		"10.0.0.1":             true,
+		"10.0.0.2":             false,
+	}
+	for hostname, covered := range expected {
+		if CoversHostname(cert, hostname) != covered {
+			t.Errorf("expected CoversHostname(%s) to be %t", hostname, covered)
+		}
+	}
+}
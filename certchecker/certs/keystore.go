@@ -0,0 +1,228 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"software.sslmate.com/src/go-pkcs12"
+	"unicode/utf16"
+)
+
+/**
+a single certificate held in a keystore, along with the alias (or PKCS#12 friendly name) it was stored under
+*/
+type KeystoreEntry struct {
+	Alias       string
+	Certificate *x509.Certificate
+}
+
+/**
+decodes every certificate in a PKCS#12 (.p12/.pfx) bundle.  The alias is taken from the bag's friendlyName
+attribute if there is one, otherwise it is the certificate's position in the bundle
+*/
+func LoadPKCS12(data []byte, password string) ([]KeystoreEntry, error) {
+	blocks, decodeErr := pkcs12.ToPEM(data, password)
+	if decodeErr != nil {
+		//ToPEM only understands bundles with a key in them, trust stores need decoding separately
+		trusted, trustErr := pkcs12.DecodeTrustStore(data, password)
+		if trustErr != nil {
+			return nil, decodeErr
+		}
+		//DecodeTrustStore doesn't give us the friendlyNames, so they are read separately
+		names, namesErr := pkcs12CertNames(data, password)
+		if namesErr != nil || len(names) != len(trusted) {
+			log.Printf("WARNING Could not read the aliases from a PKCS#12 trust store, numbering the certificates instead: %v", namesErr)
+			names = make([]string, len(trusted))
+		}
+		results := make([]KeystoreEntry, len(trusted))
+		for i, cert := range trusted {
+			alias := names[i]
+			if alias == "" {
+				alias = fmt.Sprintf("%d", i)
+			}
+			results[i] = KeystoreEntry{Alias: alias, Certificate: cert}
+		}
+		return results, nil
+	}
+
+	results := make([]KeystoreEntry, 0)
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, parseErr := x509.ParseCertificate(block.Bytes)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		alias := block.Headers["friendlyName"]
+		if alias == "" {
+			alias = fmt.Sprintf("%d", len(results))
+		}
+		results = append(results, KeystoreEntry{Alias: alias, Certificate: cert})
+	}
+	return results, nil
+}
+
+const (
+	jksMagic   = 0xFEEDFEED
+	jceksMagic = 0xCECECECE
+
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+	jksSecretKeyTag   = 3
+)
+
+/**
+the Java keystore integrity check: SHA-1 over the password (as UTF-16BE), the string "Mighty Aphrodite" and then
+the keystore content itself
+*/
+func javaKeystoreDigest(password string, content []byte) []byte {
+	hash := sha1.New()
+	for _, unit := range utf16.Encode([]rune(password)) {
+		hash.Write([]byte{byte(unit >> 8), byte(unit)})
+	}
+	hash.Write([]byte("Mighty Aphrodite"))
+	hash.Write(content)
+	return hash.Sum(nil)
+}
+
+type javaKeystoreReader struct {
+	r       *bytes.Reader
+	version uint32
+}
+
+func (k *javaKeystoreReader) uint32() (uint32, error) {
+	var v uint32
+	err := binary.Read(k.r, binary.BigEndian, &v)
+	return v, err
+}
+
+/**
+reads `length` bytes.  The length comes from the keystore itself, so it is checked against what is left before
+anything is allocated
+*/
+func (k *javaKeystoreReader) bytes(length uint32) ([]byte, error) {
+	if int64(length) > int64(k.r.Len()) {
+		return nil, fmt.Errorf("keystore claims a %d byte field but only %d bytes are left", length, k.r.Len())
+	}
+	buf := make([]byte, length)
+	_, err := io.ReadFull(k.r, buf)
+	return buf, err
+}
+
+func (k *javaKeystoreReader) utf() (string, error) {
+	var length uint16
+	if err := binary.Read(k.r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	buf, err := k.bytes(uint32(length))
+	return string(buf), err
+}
+
+func (k *javaKeystoreReader) certificate() (*x509.Certificate, error) {
+	if k.version == 2 {
+		if _, err := k.utf(); err != nil { //certificate type, always X.509 in practise
+			return nil, err
+		}
+	}
+	length, err := k.uint32()
+	if err != nil {
+		return nil, err
+	}
+	der, err := k.bytes(length)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+/**
+decodes every certificate in a JKS or JCEKS keystore.  Certificates in these formats are not encrypted, so the
+password is only used to verify the keystore's integrity.  Private key entries contribute every certificate in their
+chain, under the entry's alias.
+
+JCEKS secret-key entries are stored as serialized Java objects which we can't skip over, so if one is found we
+stop reading and return the certificates found up to that point.
+*/
+func LoadJavaKeystore(data []byte, password string) ([]KeystoreEntry, error) {
+	if len(data) < 32 {
+		return nil, errors.New("keystore is too short")
+	}
+	content := data[:len(data)-sha1.Size]
+	if !bytes.Equal(javaKeystoreDigest(password, content), data[len(content):]) {
+		return nil, errors.New("keystore integrity check failed, the password is probably wrong")
+	}
+
+	k := &javaKeystoreReader{r: bytes.NewReader(content)}
+	magic, err := k.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if magic != jksMagic && magic != jceksMagic {
+		return nil, errors.New("not a JKS or JCEKS keystore")
+	}
+	if k.version, err = k.uint32(); err != nil {
+		return nil, err
+	}
+	if k.version != 1 && k.version != 2 {
+		return nil, fmt.Errorf("unsupported keystore version %d", k.version)
+	}
+	count, err := k.uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]KeystoreEntry, 0)
+	for i := uint32(0); i < count; i++ {
+		tag, err := k.uint32()
+		if err != nil {
+			return nil, err
+		}
+		alias, err := k.utf()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := k.bytes(8); err != nil { //creation timestamp
+			return nil, err
+		}
+
+		switch tag {
+		case jksPrivateKeyTag:
+			keyLength, err := k.uint32()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := k.bytes(keyLength); err != nil {
+				return nil, err
+			}
+			chainLength, err := k.uint32()
+			if err != nil {
+				return nil, err
+			}
+			for j := uint32(0); j < chainLength; j++ {
+				cert, err := k.certificate()
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, KeystoreEntry{Alias: alias, Certificate: cert})
+			}
+		case jksTrustedCertTag:
+			cert, err := k.certificate()
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, KeystoreEntry{Alias: alias, Certificate: cert})
+		case jksSecretKeyTag:
+			log.Printf("WARNING LoadJavaKeystore entry %s is a secret key, can't read any further entries", alias)
+			return results, nil
+		default:
+			return nil, fmt.Errorf("unknown keystore entry type %d for %s", tag, alias)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,158 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"software.sslmate.com/src/go-pkcs12"
+	"testing"
+	"time"
+)
+
+func makeTestCert(t *testing.T, commonName string) *x509.Certificate {
+	cert, _ := makeTestCertAndKey(t, commonName)
+	return cert
+}
+
+func makeTestCertAndKey(t *testing.T, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, certErr := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if certErr != nil {
+		t.Fatal(certErr)
+	}
+	cert, parseErr := x509.ParseCertificate(der)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return cert, key
+}
+
+/**
+builds a version 2 JKS keystore holding a trusted certificate entry for each of the given aliases
+*/
+func makeTestJKS(t *testing.T, password string, entries map[string]*x509.Certificate) []byte {
+	buf := &bytes.Buffer{}
+	writeUTF := func(s string) {
+		binary.Write(buf, binary.BigEndian, uint16(len(s)))
+		buf.WriteString(s)
+	}
+	binary.Write(buf, binary.BigEndian, uint32(jksMagic))
+	binary.Write(buf, binary.BigEndian, uint32(2))
+	binary.Write(buf, binary.BigEndian, uint32(len(entries)))
+	for alias, cert := range entries {
+		binary.Write(buf, binary.BigEndian, uint32(jksTrustedCertTag))
+		writeUTF(alias)
+		binary.Write(buf, binary.BigEndian, time.Now().UnixNano()/int64(time.Millisecond))
+		writeUTF("X.509")
+		binary.Write(buf, binary.BigEndian, uint32(len(cert.Raw)))
+		buf.Write(cert.Raw)
+	}
+	buf.Write(javaKeystoreDigest(password, buf.Bytes()))
+	return buf.Bytes()
+}
+
+func TestLoadJavaKeystore(t *testing.T) {
+	first := makeTestCert(t, "first.example.com")
+	second := makeTestCert(t, "second.example.com")
+	data := makeTestJKS(t, "changeit", map[string]*x509.Certificate{"first": first, "second": second})
+
+	entries, err := LoadJavaKeystore(data, "changeit")
+	if err != nil {
+		t.Fatal("unexpected error loading keystore: ", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Certificate.Subject.CommonName != entry.Alias+".example.com" {
+			t.Errorf("entry %s has the wrong certificate %s", entry.Alias, entry.Certificate.Subject.CommonName)
+		}
+	}
+
+	_, err = LoadJavaKeystore(data, "wrong")
+	if err == nil {
+		t.Error("expected an error with the wrong password")
+	}
+}
+
+func TestLoadJavaKeystoreBadLength(t *testing.T) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, uint32(jksMagic))
+	binary.Write(buf, binary.BigEndian, uint32(2))
+	binary.Write(buf, binary.BigEndian, uint32(1))
+	binary.Write(buf, binary.BigEndian, uint32(jksTrustedCertTag))
+	binary.Write(buf, binary.BigEndian, uint16(4))
+	buf.WriteString("huge")
+	binary.Write(buf, binary.BigEndian, int64(0))
+	binary.Write(buf, binary.BigEndian, uint16(5))
+	buf.WriteString("X.509")
+	//claims a 4GB certificate
+	binary.Write(buf, binary.BigEndian, uint32(0xffffffff))
+	buf.Write(javaKeystoreDigest("changeit", buf.Bytes()))
+
+	if _, err := LoadJavaKeystore(buf.Bytes(), "changeit"); err == nil {
+		t.Error("expected an error for a length longer than the keystore")
+	}
+}
+
+func TestLoadPKCS12(t *testing.T) {
+	cert, key := makeTestCertAndKey(t, "server.example.com")
+	ca := makeTestCert(t, "ca.example.com")
+	data, encodeErr := pkcs12.Encode(rand.Reader, key, cert, []*x509.Certificate{ca}, "changeit")
+	if encodeErr != nil {
+		t.Fatal(encodeErr)
+	}
+
+	entries, err := LoadPKCS12(data, "changeit")
+	if err != nil {
+		t.Fatal("unexpected error loading PKCS#12: ", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if !entries[0].Certificate.Equal(cert) && !entries[1].Certificate.Equal(cert) {
+		t.Error("server certificate was not in the decoded entries")
+	}
+
+	_, err = LoadPKCS12(data, "wrong")
+	if err == nil {
+		t.Error("expected an error with the wrong password")
+	}
+}
+
+func TestLoadPKCS12TrustStore(t *testing.T) {
+	cert := makeTestCert(t, "server.example.com")
+	ca := makeTestCert(t, "ca.example.com")
+	data, encodeErr := pkcs12.EncodeTrustStoreEntries(rand.Reader, []pkcs12.TrustStoreEntry{
+		{Cert: cert, FriendlyName: "server"},
+		{Cert: ca, FriendlyName: "internal-ca"},
+	}, "changeit")
+	if encodeErr != nil {
+		t.Fatal(encodeErr)
+	}
+
+	entries, err := LoadPKCS12(data, "changeit")
+	if err != nil {
+		t.Fatal("unexpected error loading PKCS#12 trust store: ", err)
+	}
+	if len(entries) != 2 || !entries[0].Certificate.Equal(cert) || !entries[1].Certificate.Equal(ca) {
+		t.Fatalf("expected both trusted certificates back in order, got %d entries", len(entries))
+	}
+	if entries[0].Alias != "server" || entries[1].Alias != "internal-ca" {
+		t.Errorf("expected the friendlyNames as aliases, got %s and %s", entries[0].Alias, entries[1].Alias)
+	}
+}
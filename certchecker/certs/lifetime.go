@@ -0,0 +1,116 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**
+a maximum certificate lifetime that applies to certificates issued on or after IssuedFrom
+*/
+type LifetimePolicy struct {
+	Name       string    `json:"name"`
+	IssuedFrom time.Time `json:"issuedFrom"`
+	MaxDays    int       `json:"maxDays"`
+}
+
+func (p *LifetimePolicy) MaxLifetime() time.Duration {
+	return time.Duration(p.MaxDays) * 24 * time.Hour
+}
+
+func mustParseDate(date string) time.Time {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+/**
+the browser / CA/Browser Forum limits on public certificate lifetimes, keyed by the date the certificate was issued
+*/
+var DefaultLifetimePolicies = []LifetimePolicy{
+	{Name: "CA/B Forum 825 days", IssuedFrom: mustParseDate("2018-03-01"), MaxDays: 825},
+	{Name: "Chrome 398 days", IssuedFrom: mustParseDate("2020-09-01"), MaxDays: 398},
+	{Name: "CA/B Forum SC-081 200 days", IssuedFrom: mustParseDate("2026-03-15"), MaxDays: 200},
+	{Name: "CA/B Forum SC-081 100 days", IssuedFrom: mustParseDate("2027-03-15"), MaxDays: 100},
+	{Name: "CA/B Forum SC-081 47 days", IssuedFrom: mustParseDate("2029-03-15"), MaxDays: 47},
+}
+
+/**
+decides which lifetime policy applies to a certificate. Certs from one of the InternalIssuers (matched against either
+the issuer's common name or its full distinguished name) are exempt, as browsers only enforce these limits on
+publicly-trusted CAs
+*/
+type LifetimeRules struct {
+	Policies        []LifetimePolicy
+	InternalIssuers []string
+}
+
+func DefaultLifetimeRules() *LifetimeRules {
+	return &LifetimeRules{Policies: DefaultLifetimePolicies}
+}
+
+/**
+reads a table of lifetime policies from a json file, in the form
+`[{"name": "...", "issuedFrom": "2026-03-15T00:00:00Z", "maxDays": 200}, ...]`
+*/
+func LoadLifetimePolicies(filename string) ([]LifetimePolicy, error) {
+	content, readErr := ioutil.ReadFile(filename)
+	if readErr != nil {
+		return nil, readErr
+	}
+	var policies []LifetimePolicy
+	if unmarshalErr := json.Unmarshal(content, &policies); unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return policies, nil
+}
+
+func (r *LifetimeRules) isInternal(cert *x509.Certificate) bool {
+	for _, issuer := range r.InternalIssuers {
+		issuer = strings.TrimSpace(issuer)
+		if issuer != "" && (issuer == cert.Issuer.CommonName || issuer == cert.Issuer.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+returns the policy that applies to the given cert, i.e. the one with the latest IssuedFrom that is not after the
+cert's NotBefore, or nil if none applies.  The limits are only for subscriber certs, so none apply to CA certs
+*/
+func (r *LifetimeRules) PolicyFor(cert *x509.Certificate) *LifetimePolicy {
+	if r == nil || cert.IsCA || r.isInternal(cert) {
+		return nil
+	}
+	sorted := make([]LifetimePolicy, len(r.Policies))
+	copy(sorted, r.Policies)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].IssuedFrom.Before(sorted[j].IssuedFrom)
+	})
+
+	var applicable *LifetimePolicy
+	for i := range sorted {
+		if !cert.NotBefore.Before(sorted[i].IssuedFrom) {
+			applicable = &sorted[i]
+		}
+	}
+	return applicable
+}
+
+/**
+returns the policy that the cert's lifetime violates, or nil if it is within the limit (or no limit applies)
+*/
+func (r *LifetimeRules) Violated(cert *x509.Certificate) *LifetimePolicy {
+	policy := r.PolicyFor(cert)
+	if policy != nil && cert.NotAfter.Sub(cert.NotBefore) > policy.MaxLifetime() {
+		return policy
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package certs
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func makeLifetimeCert(notBefore string, days int, issuer string) *x509.Certificate {
+	start := mustParseDate(notBefore)
+	return &x509.Certificate{
+		Issuer:    pkix.Name{CommonName: issuer},
+		NotBefore: start,
+		NotAfter:  start.Add(time.Duration(days) * 24 * time.Hour),
+	}
+}
+
+func TestLifetimeRulesByIssueDate(t *testing.T) {
+	rules := DefaultLifetimeRules()
+
+	if violated := rules.Violated(makeLifetimeCert("2020-01-01", 500, "Public CA")); violated != nil {
+		t.Errorf("a 500 day cert issued in Jan 2020 should be within the 825 day limit, got %s", violated.Name)
+	}
+	if violated := rules.Violated(makeLifetimeCert("2021-01-01", 500, "Public CA")); violated == nil || violated.MaxDays != 398 {
+		t.Errorf("a 500 day cert issued in 2021 should violate the 398 day limit, got %v", violated)
+	}
+	if violated := rules.Violated(makeLifetimeCert("2026-04-01", 300, "Public CA")); violated == nil || violated.MaxDays != 200 {
+		t.Errorf("a 300 day cert issued in April 2026 should violate the 200 day limit, got %v", violated)
+	}
+	if violated := rules.Violated(makeLifetimeCert("2029-03-15", 47, "Public CA")); violated != nil {
+		t.Errorf("a 47 day cert issued on the day the 47 day limit starts should be OK, got %s", violated.Name)
+	}
+	if policy := rules.PolicyFor(makeLifetimeCert("2015-01-01", 10, "Public CA")); policy != nil {
+		t.Errorf("no policy should apply to a cert issued in 2015, got %s", policy.Name)
+	}
+}
+
+func TestLifetimeRulesInternalIssuer(t *testing.T) {
+	rules := DefaultLifetimeRules()
+	rules.InternalIssuers = []string{"Internal CA"}
+
+	if violated := rules.Violated(makeLifetimeCert("2026-04-01", 730, "Internal CA")); violated != nil {
+		t.Errorf("certs from an internal issuer should be exempt, got %s", violated.Name)
+	}
+}
+
+func TestLifetimeRulesCA(t *testing.T) {
+	ca := makeLifetimeCert("2026-04-01", 3650, "Public Root CA")
+	ca.IsCA = true
+	if policy := DefaultLifetimeRules().PolicyFor(ca); policy != nil {
+		t.Errorf("no policy should apply to a CA cert, got %s", policy.Name)
+	}
+}
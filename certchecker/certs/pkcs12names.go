@@ -0,0 +1,315 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"unicode/utf16"
+)
+
+/**
+go-pkcs12 will decode a trust store but throws away the friendlyName of each certificate bag while doing so, so the
+bags are read again here to get the aliases back.  Only as much of PKCS#12 (RFC 7292) as is needed for that is
+implemented; the MAC is not checked because DecodeTrustStore has already done so.
+*/
+
+var (
+	oidPKCS7Data                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7EncryptedData         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidPKCS12CertBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS9FriendlyName          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd40BitRC2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2                      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHmacWithSHA1               = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHmacWithSHA256             = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC                  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC                  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC                  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type pkcs12PFX struct {
+	Version  int
+	AuthSafe pkcs12ContentInfo
+	MacData  asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs12ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo struct {
+		ContentType      asn1.ObjectIdentifier
+		Algorithm        pkix.AlgorithmIdentifier
+		EncryptedContent []byte `asn1:"tag:0,optional"`
+	}
+}
+
+type pkcs12SafeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pkcs12PBES2Params struct {
+	KeyDerivation pkix.AlgorithmIdentifier
+	Encryption    pkix.AlgorithmIdentifier
+}
+
+type pkcs12PBKDF2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+/**
+returns the friendlyName of each certificate bag in the PKCS#12 data, in the order that the bags appear (which is the
+order DecodeTrustStore returns the certificates in).  A bag without a friendlyName gets an empty string
+*/
+func pkcs12CertNames(data []byte, password string) ([]string, error) {
+	var pfx pkcs12PFX
+	if err := unmarshalAll(data, &pfx); err != nil {
+		return nil, err
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidPKCS7Data) {
+		return nil, errors.New("only password-integrity PKCS#12 files are supported")
+	}
+	var authSafeData []byte
+	if err := unmarshalAll(pfx.AuthSafe.Content.Bytes, &authSafeData); err != nil {
+		return nil, err
+	}
+	var authSafe []pkcs12ContentInfo
+	if err := unmarshalAll(authSafeData, &authSafe); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, info := range authSafe {
+		var bagsData []byte
+		switch {
+		case info.ContentType.Equal(oidPKCS7Data):
+			if err := unmarshalAll(info.Content.Bytes, &bagsData); err != nil {
+				return nil, err
+			}
+		case info.ContentType.Equal(oidPKCS7EncryptedData):
+			var encrypted pkcs12EncryptedData
+			if err := unmarshalAll(info.Content.Bytes, &encrypted); err != nil {
+				return nil, err
+			}
+			var err error
+			bagsData, err = pkcs12Decrypt(encrypted.EncryptedContentInfo.Algorithm, encrypted.EncryptedContentInfo.EncryptedContent, password)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PKCS#12 content type %s", info.ContentType)
+		}
+
+		var bags []pkcs12SafeBag
+		if err := unmarshalAll(bagsData, &bags); err != nil {
+			return nil, err
+		}
+		for _, bag := range bags {
+			if bag.Id.Equal(oidPKCS12CertBag) {
+				names = append(names, friendlyName(bag.Attributes))
+			}
+		}
+	}
+	return names, nil
+}
+
+func unmarshalAll(data []byte, out interface{}) error {
+	rest, err := asn1.Unmarshal(data, out)
+	if err == nil && len(rest) > 0 {
+		err = errors.New("trailing data after ASN.1 value")
+	}
+	return err
+}
+
+func friendlyName(attributes []pkcs12Attribute) string {
+	for _, attribute := range attributes {
+		if !attribute.Id.Equal(oidPKCS9FriendlyName) {
+			continue
+		}
+		var value asn1.RawValue
+		if err := unmarshalAll(attribute.Value.Bytes, &value); err != nil || value.Tag != asn1.TagBMPString || len(value.Bytes)%2 != 0 {
+			return ""
+		}
+		units := make([]uint16, len(value.Bytes)/2)
+		for i := range units {
+			units[i] = uint16(value.Bytes[2*i])<<8 | uint16(value.Bytes[2*i+1])
+		}
+		return string(utf16.Decode(units))
+	}
+	return ""
+}
+
+/**
+the password as a null-terminated BMPString, which is what the PKCS#12 key derivation expects
+*/
+func bmpPassword(password string) []byte {
+	units := utf16.Encode([]rune(password))
+	result := make([]byte, 0, 2*len(units)+2)
+	for _, unit := range units {
+		result = append(result, byte(unit>>8), byte(unit))
+	}
+	return append(result, 0, 0)
+}
+
+/**
+the PKCS#12 key derivation function from RFC 7292 appendix B.2, using SHA-1 as every PBE scheme we support does.
+`id` is 1 for key material and 2 for an IV
+*/
+func pkcs12KDF(salt []byte, password []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha1.Size, 64
+	fill := func(pattern []byte) []byte {
+		if len(pattern) == 0 {
+			return nil
+		}
+		length := v * ((len(pattern) + v - 1) / v)
+		return bytes.Repeat(pattern, (length+len(pattern)-1)/len(pattern))[:length]
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+
+	result := make([]byte, 0, size+u)
+	for len(result) < size {
+		a := sha1.Sum(append(append([]byte{}, d...), i...))
+		for r := 1; r < iterations; r++ {
+			a = sha1.Sum(a[:])
+		}
+		result = append(result, a[:]...)
+
+		b := fill(a[:])
+		for j := 0; j < len(i); j += v {
+			//I_j = (I_j + B + 1) mod 2^(v*8)
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return result[:size]
+}
+
+func pkcs12Decrypt(algorithm pkix.AlgorithmIdentifier, encrypted []byte, password string) ([]byte, error) {
+	var decrypt func(dst, src []byte)
+	var iv []byte
+	blockSize := 8
+
+	switch {
+	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDES), algorithm.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2):
+		var params pkcs12PBEParams
+		if err := unmarshalAll(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		iv = pkcs12KDF(params.Salt, bmpPassword(password), params.Iterations, 2, 8)
+		if algorithm.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2) {
+			decrypt = newRC2Decrypter(pkcs12KDF(params.Salt, bmpPassword(password), params.Iterations, 1, 5), 40)
+		} else {
+			block, err := des.NewTripleDESCipher(pkcs12KDF(params.Salt, bmpPassword(password), params.Iterations, 1, 24))
+			if err != nil {
+				return nil, err
+			}
+			decrypt = block.Decrypt
+		}
+	case algorithm.Algorithm.Equal(oidPBES2):
+		block, pbes2IV, err := pbes2Cipher(algorithm, password)
+		if err != nil {
+			return nil, err
+		}
+		decrypt, iv, blockSize = block.Decrypt, pbes2IV, block.BlockSize()
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#12 encryption algorithm %s", algorithm.Algorithm)
+	}
+
+	if len(encrypted) == 0 || len(encrypted)%blockSize != 0 || len(iv) != blockSize {
+		return nil, errors.New("encrypted PKCS#12 content is not a whole number of blocks")
+	}
+	//CBC by hand, since the RC2 decrypter is just a function rather than a cipher.Block
+	decrypted := make([]byte, len(encrypted))
+	previous := iv
+	for start := 0; start < len(encrypted); start += blockSize {
+		current := encrypted[start : start+blockSize]
+		decrypt(decrypted[start:start+blockSize], current)
+		for k := 0; k < blockSize; k++ {
+			decrypted[start+k] ^= previous[k]
+		}
+		previous = current
+	}
+
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > blockSize || !bytes.Equal(decrypted[len(decrypted)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("could not decrypt PKCS#12 content, the password is probably wrong")
+	}
+	return decrypted[:len(decrypted)-padding], nil
+}
+
+/**
+sets up the PBKDF2 and AES-CBC scheme from RFC 8018 that newer versions of Java and OpenSSL use.  Unlike the older
+PKCS#12 schemes this takes the password as UTF-8
+*/
+func pbes2Cipher(algorithm pkix.AlgorithmIdentifier, password string) (cipher.Block, []byte, error) {
+	var params pkcs12PBES2Params
+	if err := unmarshalAll(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, nil, err
+	}
+	if !params.KeyDerivation.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, fmt.Errorf("unsupported PBES2 key derivation %s", params.KeyDerivation.Algorithm)
+	}
+	var kdfParams pkcs12PBKDF2Params
+	if err := unmarshalAll(params.KeyDerivation.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, nil, err
+	}
+
+	var keyLength int
+	switch {
+	case params.Encryption.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case params.Encryption.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case params.Encryption.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, nil, fmt.Errorf("unsupported PBES2 encryption %s", params.Encryption.Algorithm)
+	}
+	var iv []byte
+	if err := unmarshalAll(params.Encryption.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, err
+	}
+
+	prf := sha1.New
+	if kdfParams.PRF.Algorithm.Equal(oidHmacWithSHA256) {
+		prf = sha256.New
+	} else if kdfParams.PRF.Algorithm != nil && !kdfParams.PRF.Algorithm.Equal(oidHmacWithSHA1) {
+		return nil, nil, fmt.Errorf("unsupported PBKDF2 hash %s", kdfParams.PRF.Algorithm)
+	}
+	key := pbkdf2.Key([]byte(password), kdfParams.Salt, kdfParams.Iterations, keyLength, prf)
+	block, err := aes.NewCipher(key)
+	return block, iv, err
+}
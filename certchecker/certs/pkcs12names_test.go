@@ -0,0 +1,54 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"golang.org/x/crypto/pbkdf2"
+	"testing"
+)
+
+/**
+encrypts `content` the way newer versions of Java protect certificate bags, with PBKDF2-HMAC-SHA256 and AES-256-CBC
+*/
+func pbes2Encrypt(t *testing.T, content []byte, password string) (pkix.AlgorithmIdentifier, []byte) {
+	salt := []byte("saltsalt")
+	iv := bytes.Repeat([]byte{7}, aes.BlockSize)
+	kdfParams, err := asn1.Marshal(pkcs12PBKDF2Params{Salt: salt, Iterations: 1000, KeyLength: 32, PRF: pkix.AlgorithmIdentifier{Algorithm: oidHmacWithSHA256, Parameters: asn1.NullRawValue}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivParam, _ := asn1.Marshal(iv)
+	params, err := asn1.Marshal(pkcs12PBES2Params{
+		KeyDerivation: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		Encryption:    pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := aes.NewCipher(pbkdf2.Key([]byte(password), salt, 1000, 32, sha256.New))
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	padded := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}, padded
+}
+
+func TestPKCS12DecryptPBES2(t *testing.T) {
+	algorithm, encrypted := pbes2Encrypt(t, []byte("certificate bags"), "changeit")
+
+	decrypted, err := pkcs12Decrypt(algorithm, encrypted, "changeit")
+	if err != nil {
+		t.Fatal("unexpected error decrypting: ", err)
+	}
+	if string(decrypted) != "certificate bags" {
+		t.Errorf("decrypted content was wrong: %q", decrypted)
+	}
+
+	if decrypted, err := pkcs12Decrypt(algorithm, encrypted, "wrong"); err == nil && string(decrypted) == "certificate bags" {
+		t.Error("expected the wrong password not to decrypt the content")
+	}
+}
@@ -0,0 +1,210 @@
+package certs
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"strings"
+)
+
+/**
+Policy is a single check of a certificate's cryptographic properties.  Each one returns zero or more findings
+describing the problems it found.
+*/
+type Policy interface {
+	Name() string
+	Evaluate(cert *x509.Certificate) []datapersistence.Finding
+}
+
+/**
+SourcePolicy is implemented by policies that only make sense for certificates from some sources.  The policy is
+skipped for any source that AppliesTo returns false for
+*/
+type SourcePolicy interface {
+	AppliesTo(source datapersistence.SourceDescriptor) bool
+}
+
+/**
+flags RSA keys that are shorter than MinBits
+*/
+type RSAKeySizePolicy struct {
+	MinBits int
+}
+
+func (p RSAKeySizePolicy) Name() string {
+	return "rsa-key-size"
+}
+
+func (p RSAKeySizePolicy) Evaluate(cert *x509.Certificate) []datapersistence.Finding {
+	if key, isRsa := cert.PublicKey.(*rsa.PublicKey); isRsa && key.N.BitLen() < p.MinBits {
+		return []datapersistence.Finding{{
+			Code:     "WeakRSAKey",
+			Severity: datapersistence.SeverityCritical,
+			Message:  fmt.Sprintf("RSA key is %d bits, the minimum is %d", key.N.BitLen(), p.MinBits),
+		}}
+	}
+	return nil
+}
+
+/**
+flags certificates signed with MD5 or SHA-1
+*/
+type SignatureAlgorithmPolicy struct{}
+
+func (p SignatureAlgorithmPolicy) Name() string {
+	return "signature-algorithm"
+}
+
+func (p SignatureAlgorithmPolicy) Evaluate(cert *x509.Certificate) []datapersistence.Finding {
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return []datapersistence.Finding{{
+			Code:     "WeakSignatureAlgorithm",
+			Severity: datapersistence.SeverityCritical,
+			Message:  fmt.Sprintf("certificate is signed with %s", cert.SignatureAlgorithm),
+		}}
+	}
+	return nil
+}
+
+/**
+flags DSA keys, which are deprecated and not accepted by browsers
+*/
+type DSAKeyPolicy struct{}
+
+func (p DSAKeyPolicy) Name() string {
+	return "dsa-key"
+}
+
+func (p DSAKeyPolicy) Evaluate(cert *x509.Certificate) []datapersistence.Finding {
+	if _, isDsa := cert.PublicKey.(*dsa.PublicKey); isDsa || cert.PublicKeyAlgorithm == x509.DSA {
+		return []datapersistence.Finding{{
+			Code:     "DSAKey",
+			Severity: datapersistence.SeverityCritical,
+			Message:  "certificate has a DSA key",
+		}}
+	}
+	return nil
+}
+
+/**
+flags ECDSA keys that are not on one of the Approved curves
+*/
+type ECDSACurvePolicy struct {
+	Approved []elliptic.Curve
+}
+
+func (p ECDSACurvePolicy) Name() string {
+	return "ecdsa-curve"
+}
+
+func (p ECDSACurvePolicy) Evaluate(cert *x509.Certificate) []datapersistence.Finding {
+	key, isEcdsa := cert.PublicKey.(*ecdsa.PublicKey)
+	if !isEcdsa {
+		return nil
+	}
+	for _, curve := range p.Approved {
+		if key.Curve == curve {
+			return nil
+		}
+	}
+	return []datapersistence.Finding{{
+		Code:     "UnapprovedCurve",
+		Severity: datapersistence.SeverityWarning,
+		Message:  fmt.Sprintf("ECDSA key uses curve %s, which is not approved", key.Curve.Params().Name),
+	}}
+}
+
+/**
+flags end-entity certificates that can't be used for TLS servers because their extended key usages leave out
+serverAuth, including client certificates that only have clientAuth.  A certificate with no extended key usage at all
+is unrestricted (RFC 5280 4.2.1.12) so it isn't flagged, and neither are CA certificates.  Certificates from sources
+that only hold client certificates, such as kubeconfig users, aren't checked at all.
+*/
+type ServerAuthPolicy struct{}
+
+func (p ServerAuthPolicy) Name() string {
+	return "server-auth"
+}
+
+func (p ServerAuthPolicy) AppliesTo(source datapersistence.SourceDescriptor) bool {
+	return !source.IsClientCert()
+}
+
+func (p ServerAuthPolicy) Evaluate(cert *x509.Certificate) []datapersistence.Finding {
+	if cert.IsCA || (len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0) {
+		return nil
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth || usage == x509.ExtKeyUsageAny {
+			return nil
+		}
+	}
+	return []datapersistence.Finding{{
+		Code:     "MissingServerAuth",
+		Severity: datapersistence.SeverityWarning,
+		Message:  "certificate's extended key usages do not include serverAuth, so TLS clients will reject it as a server cert",
+	}}
+}
+
+var DefaultPolicies = []Policy{
+	RSAKeySizePolicy{MinBits: 2048},
+	SignatureAlgorithmPolicy{},
+	DSAKeyPolicy{},
+	ECDSACurvePolicy{Approved: []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()}},
+	ServerAuthPolicy{},
+}
+
+/**
+returns the names of all of the DefaultPolicies
+*/
+func DefaultPolicyNames() []string {
+	names := make([]string, len(DefaultPolicies))
+	for i, policy := range DefaultPolicies {
+		names[i] = policy.Name()
+	}
+	return names
+}
+
+/**
+picks the named policies out of DefaultPolicies.  Returns an error if any of the names is not recognised
+*/
+func PoliciesByName(names []string) ([]Policy, error) {
+	results := make([]Policy, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, policy := range DefaultPolicies {
+			if policy.Name() == name {
+				results = append(results, policy)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown policy '%s', valid policies are %s", name, strings.Join(DefaultPolicyNames(), ","))
+		}
+	}
+	return results, nil
+}
+
+/**
+runs each of the given policies that apply to the certificate's source against it and returns everything they found
+*/
+func EvaluatePolicies(cert *x509.Certificate, source datapersistence.SourceDescriptor, policies []Policy) []datapersistence.Finding {
+	findings := make([]datapersistence.Finding, 0)
+	for _, policy := range policies {
+		if sourcePolicy, isSourcePolicy := policy.(SourcePolicy); isSourcePolicy && !sourcePolicy.AppliesTo(source) {
+			continue
+		}
+		findings = append(findings, policy.Evaluate(cert)...)
+	}
+	return findings
+}
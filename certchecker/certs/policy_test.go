@@ -0,0 +1,97 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"math/big"
+	"testing"
+)
+
+func findingCodes(cert *x509.Certificate) map[string]bool {
+	codes := make(map[string]bool)
+	for _, finding := range EvaluatePolicies(cert, datapersistence.SourceDescriptor{}, DefaultPolicies) {
+		codes[finding.Code] = true
+	}
+	return codes
+}
+
+func TestPoliciesWeakCert(t *testing.T) {
+	weakModulus := new(big.Int).Lsh(big.NewInt(1), 1023)
+	fakeCert := x509.Certificate{
+		PublicKey:          &rsa.PublicKey{N: weakModulus, E: 65537},
+		SignatureAlgorithm: x509.SHA1WithRSA,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+
+	codes := findingCodes(&fakeCert)
+	for _, expected := range []string{"WeakRSAKey", "WeakSignatureAlgorithm", "MissingServerAuth"} {
+		if !codes[expected] {
+			t.Errorf("expected a %s finding, got %v", expected, codes)
+		}
+	}
+}
+
+func TestPoliciesGoodCert(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeCert := x509.Certificate{
+		PublicKey:          &key.PublicKey,
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	codes := findingCodes(&fakeCert)
+	if len(codes) != 0 {
+		t.Errorf("expected no findings for a good cert, got %v", codes)
+	}
+}
+
+func TestServerAuthPolicy(t *testing.T) {
+	cases := []struct {
+		name    string
+		cert    x509.Certificate
+		flagged bool
+	}{
+		{"no extended key usage", x509.Certificate{}, false},
+		{"serverAuth", x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}}, false},
+		{"any", x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}, false},
+		{"clientAuth only", x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, true},
+		{"CA", x509.Certificate{IsCA: true, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, false},
+	}
+	for _, c := range cases {
+		if flagged := len(ServerAuthPolicy{}.Evaluate(&c.cert)) > 0; flagged != c.flagged {
+			t.Errorf("%s: expected flagged to be %t", c.name, c.flagged)
+		}
+	}
+}
+
+func TestServerAuthPolicySkipsClientCerts(t *testing.T) {
+	clientCert := x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	kubeconfigUser := datapersistence.SourceDescriptor{Kind: datapersistence.SourceFile, Name: "admin.conf", DataKey: "client-certificate-data", Alias: "user/admin"}
+	if findings := EvaluatePolicies(&clientCert, kubeconfigUser, []Policy{ServerAuthPolicy{}}); len(findings) != 0 {
+		t.Errorf("expected a kubeconfig user's client cert not to be checked for serverAuth, got %v", findings)
+	}
+
+	secret := datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: "web-tls", DataKey: "tls.crt"}
+	if findings := EvaluatePolicies(&clientCert, secret, []Policy{ServerAuthPolicy{}}); len(findings) != 1 {
+		t.Errorf("expected a clientAuth-only cert in a TLS secret to be flagged, got %v", findings)
+	}
+}
+
+func TestPoliciesByName(t *testing.T) {
+	policies, err := PoliciesByName([]string{"rsa-key-size", " dsa-key"})
+	if err != nil || len(policies) != 2 {
+		t.Errorf("expected 2 policies, got %d (%v)", len(policies), err)
+	}
+
+	_, err = PoliciesByName([]string{"no-such-policy"})
+	if err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
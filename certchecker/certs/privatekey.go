@@ -0,0 +1,70 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+)
+
+/**
+parses a PEM-encoded private key in any of the usual forms: PKCS#8, PKCS#1 RSA or SEC 1 EC
+*/
+func LoadPrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("could not decode PEM block")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, isSigner := key.(crypto.Signer)
+		if !isSigner {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block type %s", block.Type)
+	}
+}
+
+/**
+returns true if the private key is the one that belongs to the certificate's public key
+*/
+func KeyMatchesCert(cert *x509.Certificate, key crypto.Signer) bool {
+	publicKey, isComparable := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return isComparable && publicKey.Equal(cert.PublicKey)
+}
+
+/**
+checks the private key stored alongside a certificate.  A key that doesn't belong to the certificate is critical,
+since TLS will fail as soon as anything tries to use the pair
+*/
+func CheckPrivateKey(cert *x509.Certificate, keyPEM []byte) []datapersistence.Finding {
+	key, loadErr := LoadPrivateKey(keyPEM)
+	if loadErr != nil {
+		return []datapersistence.Finding{{
+			Code:     "UnreadableKey",
+			Severity: datapersistence.SeverityWarning,
+			Message:  fmt.Sprintf("could not read the private key: %s", loadErr),
+		}}
+	}
+	if !KeyMatchesCert(cert, key) {
+		return []datapersistence.Finding{{
+			Code:     "KeyMismatch",
+			Severity: datapersistence.SeverityCritical,
+			Message:  "the private key does not match the certificate's public key",
+		}}
+	}
+	return nil
+}
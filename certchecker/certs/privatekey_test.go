@@ -0,0 +1,38 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func ecKeyPEM(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestCheckPrivateKey(t *testing.T) {
+	certKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	cert := &x509.Certificate{PublicKey: &certKey.PublicKey}
+
+	if findings := CheckPrivateKey(cert, ecKeyPEM(t, certKey)); len(findings) != 0 {
+		t.Errorf("expected no findings for the matching key, got %v", findings)
+	}
+
+	findings := CheckPrivateKey(cert, ecKeyPEM(t, otherKey))
+	if len(findings) != 1 || findings[0].Code != "KeyMismatch" {
+		t.Errorf("expected a KeyMismatch finding, got %v", findings)
+	}
+
+	findings = CheckPrivateKey(cert, []byte("not a key"))
+	if len(findings) != 1 || findings[0].Code != "UnreadableKey" {
+		t.Errorf("expected an UnreadableKey finding, got %v", findings)
+	}
+}
@@ -0,0 +1,119 @@
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+a single expiry threshold. This is either a Period, meaning "less than this much time left", or a PercentUsed,
+meaning "more than this much of the certificate's lifetime used up".  A zero Threshold is never triggered.
+*/
+type Threshold struct {
+	Period      time.Duration
+	PercentUsed float64
+}
+
+func (t Threshold) IsSet() bool {
+	return t.Period > 0 || t.PercentUsed > 0
+}
+
+func (t Threshold) String() string {
+	if t.PercentUsed > 0 {
+		return strconv.FormatFloat(t.PercentUsed, 'f', -1, 64) + "%"
+	}
+	return t.Period.String()
+}
+
+/**
+returns true if the certificate has crossed this threshold at the given time
+*/
+func (t Threshold) Triggered(cert *x509.Certificate, at time.Time) bool {
+	if t.PercentUsed > 0 {
+		return PercentUsed(&cert.NotBefore, &cert.NotAfter, at) >= t.PercentUsed
+	}
+	if t.Period > 0 {
+		return at.Add(t.Period).After(cert.NotAfter)
+	}
+	return false
+}
+
+/**
+parses a threshold, either as a percentage of the lifetime used (`80%`) or as a time period (see ParsePeriod).
+An empty string gives a zero Threshold, i.e. one that is not checked
+*/
+func ParseThreshold(value string) (Threshold, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Threshold{}, nil
+	}
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return Threshold{}, fmt.Errorf("'%s' is not a percentage between 0 and 100", value)
+		}
+		return Threshold{PercentUsed: percent}, nil
+	}
+	period, err := ParsePeriod(value)
+	if err != nil {
+		return Threshold{}, err
+	}
+	return Threshold{Period: period}, nil
+}
+
+/**
+how close to expiry a certificate can get before it is reported.  Past the Warning threshold there is a warning
+NearExpiry finding, past the Critical threshold there is a critical one instead.
+*/
+type Thresholds struct {
+	Warning  Threshold
+	Critical Threshold
+}
+
+/**
+per-certificate settings, normally read from annotations on the secret or its namespace.  Nil thresholds mean that
+the global Thresholds apply.  Problems holds a finding for each annotation that could not be understood.
+*/
+type Overrides struct {
+	Warning  *Threshold
+	Critical *Threshold
+	Ignore   bool
+	Owner    string
+	Problems []datapersistence.Finding
+}
+
+/**
+returns a copy of the thresholds with any set in the overrides replacing the global ones
+*/
+func (t Thresholds) WithOverrides(o *Overrides) Thresholds {
+	if o == nil {
+		return t
+	}
+	if o.Warning != nil {
+		t.Warning = *o.Warning
+	}
+	if o.Critical != nil {
+		t.Critical = *o.Critical
+	}
+	return t
+}
+
+/**
+parses a time period.  As well as anything that time.ParseDuration accepts, this allows a whole number of days
+in the form `30d`, which is a lot more natural for certificate lifetimes
+*/
+func ParsePeriod(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("could not parse '%s' as a number of days", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	certfinder2 "github.com/guardian/k8s-certchecker/certchecker/certfinder"
+	certs2 "github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"log"
+	"strings"
+	"time"
+)
+
+/**
+the settings that decide what counts as a problem with a certificate
+*/
+type checkSettings struct {
+	Thresholds certs2.Thresholds
+	Lifetimes  *certs2.LifetimeRules
+	Policies   []certs2.Policy
+	AsOf       time.Time
+}
+
+/**
+the time that certificates are checked against. This is the current time unless a forecast was asked for with -as-of
+*/
+func (s *checkSettings) ReferenceTime() time.Time {
+	if s.AsOf.IsZero() {
+		return time.Now()
+	}
+	return s.AsOf
+}
+
+/**
+returns the -as-of time for the report, or nil if this is a normal check against the current time
+*/
+func (s *checkSettings) ReportAsOf() *time.Time {
+	if s.AsOf.IsZero() {
+		return nil
+	}
+	asOf := s.AsOf
+	return &asOf
+}
+
+/**
+decodes and validates each of the found certificates, logging the outcome. Anything that can't be decoded is
+recorded as Errored rather than stopping the run. Every record is labelled with `cluster`, and carries the findings
+of each of the configured crypto policies and of the check that any private key stored with it matches.  Every cert
+is checked against the same reference time.
+*/
+func checkCertificates(foundCerts *[]certfinder2.CertData, settings *checkSettings, cluster string) []datapersistence.CheckRecord {
+	results := make([]datapersistence.CheckRecord, 0)
+	at := settings.ReferenceTime()
+
+	for _, entry := range *foundCerts {
+		description := entry.Source.String()
+		if entry.Overrides != nil && entry.Overrides.Ignore {
+			log.Printf("%s is ignored by annotation, not checking", description)
+			continue
+		}
+		var cert *x509.Certificate
+		err := entry.ReadError
+		if err == nil {
+			cert, _, err = certs2.LoadCert(entry.RawCertificateData, description)
+		}
+		if err != nil {
+			log.Printf("ERROR Could not load %s as an x509 certificate: %s", description, err)
+			results = append(results, datapersistence.CheckRecord{
+				Cluster:     cluster,
+				Namespace:   entry.Source.Namespace,
+				Source:      entry.Source,
+				CheckedAt:   at,
+				CheckResult: datapersistence.Errored,
+				Severity:    datapersistence.SeverityCritical,
+				Findings: []datapersistence.Finding{{
+					Code:     "Unreadable",
+					Severity: datapersistence.SeverityCritical,
+					Result:   datapersistence.ResultOf(datapersistence.Errored),
+					Message:  err.Error(),
+				}},
+			})
+			continue
+		}
+
+		result, err := certs2.ValidateCertTimes(cert, at, settings.Thresholds.WithOverrides(entry.Overrides), settings.Lifetimes, entry.Source)
+		if err != nil {
+			log.Fatalf("Could not validate %s: %s", description, err)
+		}
+
+		result.Cluster = cluster
+		certs2.AddFindings(&result, certs2.EvaluatePolicies(cert, entry.Source, settings.Policies)...)
+		if len(entry.RawKeyData) > 0 {
+			certs2.AddFindings(&result, certs2.CheckPrivateKey(cert, entry.RawKeyData)...)
+		}
+		if entry.Overrides != nil {
+			result.Owner = entry.Overrides.Owner
+			certs2.AddFindings(&result, entry.Overrides.Problems...)
+		}
+		results = append(results, result)
+
+		if len(result.Findings) == 0 {
+			log.Printf("%s is OK", description)
+		}
+		for _, finding := range result.Findings {
+			log.Printf("%s %s: %s", description, finding.Severity, finding.Message)
+		}
+	}
+	return results
+}
+
+/**
+the commandline options that are shared between cluster and file scans
+*/
+type checkFlags struct {
+	Warning          *string
+	Critical         *string
+	Policies         *string
+	LifetimePolicies *string
+	InternalIssuers  *string
+	AsOf             *string
+}
+
+func registerCheckFlags(flags *flag.FlagSet) *checkFlags {
+	return &checkFlags{
+		Warning:          flags.String("warning", "720h", "expiry warning threshold, either a period before expiry (e.g. 720h or 30d) or a percentage of the lifetime used (e.g. 80%)"),
+		Critical:         flags.String("critical", "", "expiry critical threshold, in the same form as -warning (not checked if empty)"),
+		Policies:         flags.String("policies", strings.Join(certs2.DefaultPolicyNames(), ","), "comma-separated list of crypto policies to check"),
+		LifetimePolicies: flags.String("lifetime-policies", "", "json file with a table of maximum lifetime policies, replacing the built-in CA/Browser Forum ones"),
+		InternalIssuers:  flags.String("internal-issuers", "", "comma-separated list of issuer common names or DNs that are exempt from the lifetime policies"),
+		AsOf:             flags.String("as-of", "", "check the certs as they will be at this date (e.g. 2026-12-01 or an RFC3339 time) instead of now"),
+	}
+}
+
+/**
+builds the checkSettings from the parsed commandline options
+*/
+func (f *checkFlags) Settings() (*checkSettings, error) {
+	warning, warningErr := certs2.ParseThreshold(*f.Warning)
+	if warningErr != nil {
+		return nil, fmt.Errorf("could not parse warning threshold '%s': %s", *f.Warning, warningErr)
+	}
+	critical, criticalErr := certs2.ParseThreshold(*f.Critical)
+	if criticalErr != nil {
+		return nil, fmt.Errorf("could not parse critical threshold '%s': %s", *f.Critical, criticalErr)
+	}
+
+	policies, policyErr := certs2.PoliciesByName(strings.Split(*f.Policies, ","))
+	if policyErr != nil {
+		return nil, policyErr
+	}
+
+	lifetimes := certs2.DefaultLifetimeRules()
+	if *f.LifetimePolicies != "" {
+		lifetimePolicies, loadErr := certs2.LoadLifetimePolicies(*f.LifetimePolicies)
+		if loadErr != nil {
+			return nil, fmt.Errorf("could not load lifetime policies from %s: %s", *f.LifetimePolicies, loadErr)
+		}
+		lifetimes.Policies = lifetimePolicies
+	}
+	if *f.InternalIssuers != "" {
+		lifetimes.InternalIssuers = strings.Split(*f.InternalIssuers, ",")
+	}
+
+	var asOf time.Time
+	if *f.AsOf != "" {
+		var asOfErr error
+		asOf, asOfErr = parseAsOf(*f.AsOf)
+		if asOfErr != nil {
+			return nil, asOfErr
+		}
+		log.Printf("INFO Checking certificates as of %s", asOf.Format(time.RFC3339))
+	}
+
+	return &checkSettings{
+		Thresholds: certs2.Thresholds{
+			Warning:  warning,
+			Critical: critical,
+		},
+		Lifetimes: lifetimes,
+		Policies:  policies,
+		AsOf:      asOf,
+	}, nil
+}
+
+/**
+parses the -as-of option, which is either a plain date (taken as midnight UTC) or a full RFC3339 time
+*/
+func parseAsOf(value string) (time.Time, error) {
+	if asOf, err := time.Parse("2006-01-02", value); err == nil {
+		return asOf, nil
+	}
+	asOf, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse '%s' as a date (YYYY-MM-DD) or RFC3339 time", value)
+	}
+	return asOf, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"log"
+	"sort"
+)
+
+type clusterClient struct {
+	Name      string
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+}
+
+func newClusterClient(name string, restConfig *rest.Config) (*clusterClient, error) {
+	clientset, clientErr := kubernetes.NewForConfig(restConfig)
+	if clientErr != nil {
+		return nil, clientErr
+	}
+	dynamicClient, dynamicErr := dynamic.NewForConfig(restConfig)
+	if dynamicErr != nil {
+		return nil, dynamicErr
+	}
+	return &clusterClient{Name: name, Clientset: clientset, Dynamic: dynamicClient}, nil
+}
+
+/**
+builds a client for the cluster we are running in, or failing that the current context of the given kubeconfig.
+`clusterName` is used to label the results if it is set, otherwise the kubeconfig context name is used
+*/
+func getDefaultCluster(kubeconfigPath string, clusterName string) (*clusterClient, error) {
+	clusterConfig, configErr := rest.InClusterConfig()
+	if configErr == nil {
+		return newClusterClient(clusterName, clusterConfig)
+	}
+	log.Printf("INFO Could not get in-cluster configuration: %s, falling back to out-of-cluster", configErr)
+
+	kubeconfig, loadErr := clientcmd.LoadFromFile(kubeconfigPath)
+	if loadErr != nil {
+		return nil, fmt.Errorf("could not get either in-cluster configuration or out-of-cluster: %s", loadErr)
+	}
+	if clusterName == "" {
+		clusterName = kubeconfig.CurrentContext
+	}
+	return getContextCluster(kubeconfigPath, kubeconfig.CurrentContext, clusterName)
+}
+
+func getContextCluster(kubeconfigPath string, contextName string, clusterName string) (*clusterClient, error) {
+	loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+	restConfig, configErr := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if configErr != nil {
+		return nil, configErr
+	}
+	return newClusterClient(clusterName, restConfig)
+}
+
+/**
+returns a client for each of the requested kubeconfig contexts (or every context in the kubeconfig if `allContexts`
+is set), or just the default cluster if neither was asked for.  A context that can't be set up doesn't stop the
+others; instead a ClusterStatus describing the problem is returned for it.
+*/
+func getClusterClients(kubeconfigPath string, contexts []string, allContexts bool, clusterName string) ([]clusterClient, []datapersistence.ClusterStatus) {
+	clients := make([]clusterClient, 0)
+	failures := make([]datapersistence.ClusterStatus, 0)
+
+	if allContexts {
+		kubeconfig, loadErr := clientcmd.LoadFromFile(kubeconfigPath)
+		if loadErr != nil {
+			log.Printf("ERROR Could not load kubeconfig from %s: %s", kubeconfigPath, loadErr)
+			return clients, append(failures, datapersistence.ClusterStatus{Error: loadErr.Error()})
+		}
+		contexts = make([]string, 0, len(kubeconfig.Contexts))
+		for contextName := range kubeconfig.Contexts {
+			contexts = append(contexts, contextName)
+		}
+		sort.Strings(contexts)
+	}
+
+	if len(contexts) == 0 {
+		cluster, clusterErr := getDefaultCluster(kubeconfigPath, clusterName)
+		if clusterErr != nil {
+			log.Printf("ERROR %s", clusterErr)
+			return clients, append(failures, datapersistence.ClusterStatus{Name: clusterName, Error: clusterErr.Error()})
+		}
+		return append(clients, *cluster), failures
+	}
+
+	for _, contextName := range contexts {
+		cluster, clusterErr := getContextCluster(kubeconfigPath, contextName, contextName)
+		if clusterErr != nil {
+			log.Printf("ERROR Could not set up a client for context %s: %s", contextName, clusterErr)
+			failures = append(failures, datapersistence.ClusterStatus{Name: contextName, Error: clusterErr.Error()})
+			continue
+		}
+		clients = append(clients, *cluster)
+	}
+	return clients, failures
+}
+
+/**
+returns an error if none of the clusters could be scanned, including if there weren't any to scan.  An empty report
+would look like a clean bill of health, so this should fail loudly instead
+*/
+func checkAnyScanned(clusters []datapersistence.ClusterStatus) error {
+	for _, status := range clusters {
+		if status.Error == "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("none of the %d cluster(s) could be scanned, so no report was written", len(clusters))
+}
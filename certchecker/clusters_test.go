@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: staging
+clusters:
+  - name: prod
+    cluster:
+      server: https://prod.example.com
+  - name: staging
+    cluster:
+      server: https://staging.example.com
+users:
+  - name: admin
+    user:
+      token: not-a-real-token
+contexts:
+  - name: prod
+    context:
+      cluster: prod
+      user: admin
+  - name: staging
+    context:
+      cluster: staging
+      user: admin
+  - name: broken
+    context:
+      cluster: deleted
+      user: admin
+`
+
+func clusterNames(clients []clusterClient) []string {
+	names := make([]string, 0, len(clients))
+	for _, client := range clients {
+		names = append(names, client.Name)
+	}
+	return names
+}
+
+func failureNames(failures []datapersistence.ClusterStatus) []string {
+	names := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		if failure.Error == "" {
+			names = append(names, failure.Name+" (no error)")
+		} else {
+			names = append(names, failure.Name)
+		}
+	}
+	return names
+}
+
+func TestGetClusterClients(t *testing.T) {
+	//make sure that the in-cluster configuration isn't picked up if the tests are run in a pod
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	dir, _ := ioutil.TempDir("", "kubeconfig")
+	defer os.RemoveAll(dir)
+	kubeconfigPath := path.Join(dir, "config")
+	if err := ioutil.WriteFile(kubeconfigPath, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		kubeconfigPath   string
+		contexts         []string
+		allContexts      bool
+		clusterName      string
+		expectedClusters []string
+		expectedFailures []string
+	}{
+		{"current context", kubeconfigPath, nil, false, "", []string{"staging"}, []string{}},
+		{"current context, named", kubeconfigPath, nil, false, "eu-staging", []string{"eu-staging"}, []string{}},
+		{"listed contexts", kubeconfigPath, []string{"prod", "missing"}, false, "", []string{"prod"}, []string{"missing"}},
+		//the contexts are sorted, and the one that can't be set up doesn't stop the others
+		{"all contexts", kubeconfigPath, nil, true, "", []string{"prod", "staging"}, []string{"broken"}},
+		{"all contexts overrides the list", kubeconfigPath, []string{"prod"}, true, "", []string{"prod", "staging"}, []string{"broken"}},
+		{"no kubeconfig", path.Join(dir, "missing"), nil, false, "prod", []string{}, []string{"prod"}},
+		{"no kubeconfig, all contexts", path.Join(dir, "missing"), nil, true, "", []string{}, []string{""}},
+	}
+	for _, test := range tests {
+		clients, failures := getClusterClients(test.kubeconfigPath, test.contexts, test.allContexts, test.clusterName)
+		if names := clusterNames(clients); !reflect.DeepEqual(names, test.expectedClusters) {
+			t.Errorf("%s: expected clusters %v, got %v", test.name, test.expectedClusters, names)
+		}
+		if names := failureNames(failures); !reflect.DeepEqual(names, test.expectedFailures) {
+			t.Errorf("%s: expected failures %v, got %v", test.name, test.expectedFailures, names)
+		}
+	}
+}
+
+func TestCheckAnyScanned(t *testing.T) {
+	tests := []struct {
+		name        string
+		clusters    []datapersistence.ClusterStatus
+		expectError bool
+	}{
+		{"no clusters", []datapersistence.ClusterStatus{}, true},
+		{"all failed", []datapersistence.ClusterStatus{{Name: "prod", Error: "forbidden"}, {Name: "staging", Error: "timed out"}}, true},
+		{"one scanned", []datapersistence.ClusterStatus{{Name: "prod", Error: "forbidden"}, {Name: "staging", Certificates: 3}}, false},
+		//a cluster with no certs in it was still scanned
+		{"scanned but empty", []datapersistence.ClusterStatus{{Name: "prod"}}, false},
+	}
+	for _, test := range tests {
+		err := checkAnyScanned(test.clusters)
+		if (err != nil) != test.expectError {
+			t.Errorf("%s: expected an error to be %v, got %v", test.name, test.expectError, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	certs2 "github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"log"
+	"time"
+)
+
+/**
+identifies the records that a duplicate entry was made from: every cert with the same fingerprint in the same place
+*/
+func duplicateKey(cluster string, source *datapersistence.SourceDescriptor, fingerprint string) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", cluster, source.Kind, source.Namespace, source.Name, fingerprint)
+}
+
+func describeEntry(entry *datapersistence.DuplicateEntry) string {
+	location := entry.Source.Namespace + "/" + entry.Source.Name
+	if entry.Source.Kind == datapersistence.SourceFile {
+		location = entry.Source.Name
+	}
+	if entry.Cluster != "" {
+		location = entry.Cluster + ":" + location
+	}
+	return location
+}
+
+func hasFinding(rec *datapersistence.CheckRecord, code string) bool {
+	for _, finding := range rec.Findings {
+		if finding.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+adds a warning finding to every record that is a stale copy of a cert that has since been renewed elsewhere, and to
+every record whose key is also used by certs for something else.  A record that already has a finding of the same kind,
+e.g. from flagging its own cluster's duplicates before those of all the clusters, isn't given another one
+*/
+func flagDuplicates(results []datapersistence.CheckRecord, duplicates *datapersistence.Duplicates) {
+	findings := make(map[string][]datapersistence.Finding)
+
+	for _, group := range duplicates.StaleCopies {
+		newest := &group.Entries[0]
+		for j := range group.Entries {
+			if group.Entries[j].ValidUntil.After(newest.ValidUntil) {
+				newest = &group.Entries[j]
+			}
+		}
+		for j := range group.Entries {
+			entry := &group.Entries[j]
+			if !entry.Stale {
+				continue
+			}
+			key := duplicateKey(entry.Cluster, &entry.Source, entry.Fingerprint)
+			findings[key] = append(findings[key], datapersistence.Finding{
+				Code:     "StaleCopy",
+				Severity: datapersistence.SeverityWarning,
+				Message:  fmt.Sprintf("a newer copy of this certificate, valid until %s, is in %s", newest.ValidUntil.Format(time.RFC3339), describeEntry(newest)),
+			})
+		}
+	}
+
+	for _, group := range duplicates.ReusedKeys {
+		for j := range group.Entries {
+			entry := &group.Entries[j]
+			var other *datapersistence.DuplicateEntry
+			for k := range group.Entries {
+				if group.Entries[k].Identity != entry.Identity {
+					other = &group.Entries[k]
+					break
+				}
+			}
+			key := duplicateKey(entry.Cluster, &entry.Source, entry.Fingerprint)
+			findings[key] = append(findings[key], datapersistence.Finding{
+				Code:     "ReusedKey",
+				Severity: datapersistence.SeverityWarning,
+				Message:  fmt.Sprintf("the same private key is used by %s in %s", other.Identity, describeEntry(other)),
+			})
+		}
+	}
+
+	for i := range results {
+		rec := &results[i]
+		newFindings := make([]datapersistence.Finding, 0)
+		for _, finding := range findings[duplicateKey(rec.Cluster, &rec.Source, rec.Fingerprint)] {
+			if hasFinding(rec, finding.Code) {
+				continue
+			}
+			log.Printf("%s %s: %s", rec.Source, finding.Severity, finding.Message)
+			newFindings = append(newFindings, finding)
+		}
+		if len(newFindings) > 0 {
+			certs2.AddFindings(rec, newFindings...)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"strings"
+	"testing"
+	"time"
+)
+
+func findingCodes(rec *datapersistence.CheckRecord) []string {
+	codes := make([]string, 0, len(rec.Findings))
+	for _, finding := range rec.Findings {
+		codes = append(codes, finding.Code)
+	}
+	return codes
+}
+
+func TestFlagDuplicates(t *testing.T) {
+	renewed := time.Date(2027, 1, 10, 0, 0, 0, 0, time.UTC)
+	old := time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC)
+	wildcard := "CN=*.example.com [DNS:*.example.com]"
+	secret := func(cluster string, namespace string, name string, fingerprint string, identity string, validUntil time.Time) datapersistence.CheckRecord {
+		return datapersistence.CheckRecord{
+			Cluster:       cluster,
+			Source:        datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: namespace, Name: name, DataKey: "tls.crt"},
+			Fingerprint:   fingerprint,
+			Identity:      identity,
+			PublicKeyHash: fingerprint + "-key",
+			ValidUntil:    validUntil,
+			CheckResult:   datapersistence.WithinRange,
+		}
+	}
+
+	tests := []struct {
+		name          string
+		results       []datapersistence.CheckRecord
+		expectedCodes map[string]string
+		newerIn       string
+	}{
+		{
+			"stale copy",
+			[]datapersistence.CheckRecord{
+				secret("prod", "web", "wildcard-tls", "new", wildcard, renewed),
+				secret("prod", "legacy", "wildcard-tls", "old", wildcard, old),
+			},
+			map[string]string{"web": "", "legacy": "StaleCopy"},
+			"prod:web/wildcard-tls",
+		},
+		{
+			"stale copy in another cluster",
+			[]datapersistence.CheckRecord{
+				secret("prod", "web", "wildcard-tls", "new", wildcard, renewed),
+				secret("staging", "legacy", "wildcard-tls", "old", wildcard, old),
+			},
+			map[string]string{"web": "", "legacy": "StaleCopy"},
+			"prod:web/wildcard-tls",
+		},
+		{
+			"reused key",
+			[]datapersistence.CheckRecord{
+				secret("prod", "web", "wildcard-tls", "new", wildcard, renewed),
+				func() datapersistence.CheckRecord {
+					rec := secret("prod", "api", "api-tls", "api", "CN=api.example.org [DNS:api.example.org]", renewed)
+					rec.PublicKeyHash = "new-key"
+					return rec
+				}(),
+			},
+			map[string]string{"web": "ReusedKey", "api": "ReusedKey"},
+			"",
+		},
+		{
+			"no duplicates",
+			[]datapersistence.CheckRecord{
+				secret("prod", "web", "wildcard-tls", "new", wildcard, renewed),
+				secret("prod", "api", "api-tls", "api", "CN=api.example.org [DNS:api.example.org]", renewed),
+			},
+			map[string]string{"web": "", "api": ""},
+			"",
+		},
+	}
+	for _, test := range tests {
+		flagDuplicates(test.results, datapersistence.FindDuplicates(test.results))
+		//flagging the same records again, as happens when several clusters are scanned, shouldn't add anything
+		flagDuplicates(test.results, datapersistence.FindDuplicates(test.results))
+
+		for i := range test.results {
+			rec := &test.results[i]
+			codes := strings.Join(findingCodes(rec), ",")
+			if codes != test.expectedCodes[rec.Source.Namespace] {
+				t.Errorf("%s: expected %s to have findings '%s', got '%s'", test.name, rec.Source, test.expectedCodes[rec.Source.Namespace], codes)
+				continue
+			}
+			if codes == "" {
+				if rec.Severity != datapersistence.SeverityInfo || rec.CheckResult != datapersistence.WithinRange {
+					t.Errorf("%s: expected %s to be left alone, got %s %s", test.name, rec.Source, rec.Severity, rec.CheckResult)
+				}
+				continue
+			}
+			if rec.Severity != datapersistence.SeverityWarning || rec.CheckResult != datapersistence.HasWarnings {
+				t.Errorf("%s: expected %s to have warnings, got %s %s", test.name, rec.Source, rec.Severity, rec.CheckResult)
+			}
+			if test.newerIn != "" && !strings.Contains(rec.Findings[0].Message, test.newerIn) {
+				t.Errorf("%s: expected the finding to name %s, got '%s'", test.name, test.newerIn, rec.Findings[0].Message)
+			}
+		}
+	}
+}
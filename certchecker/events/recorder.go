@@ -0,0 +1,202 @@
+package events
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ReportingController = "certchecker.guardian.co.uk/certchecker"
+	EventAction         = "CheckCertificate"
+	//the events/v1 API rejects notes longer than this
+	maxNoteLength = 1024
+)
+
+/**
+the Event reason for each finding code that is worth telling the owner of a Secret about.  Findings with codes that
+aren't listed here don't produce events
+*/
+var EventReasons = map[string]string{
+	"Expired":        "CertificateExpired",
+	"CriticalExpiry": "CertificateNearExpiry",
+	"NearExpiry":     "CertificateNearExpiry",
+	"NotValidYet":    "CertificateNotValidYet",
+	"KeyMismatch":    "KeyMismatch",
+	"Unreadable":     "CertificateUnreadable",
+	"StaleCopy":      "StaleCertificateCopy",
+}
+
+/**
+Recorder creates Warning Events on the Secrets that have problems, so that they show up in `kubectl describe secret`.
+
+Each secret gets at most one event per reason per run, with a name derived from the secret and the reason.  If that
+event still exists from an earlier run, its series count is increased rather than creating another one
+*/
+type Recorder struct {
+	Clientset kubernetes.Interface
+	Instance  string
+	Now       func() time.Time
+}
+
+func NewRecorder(clientset kubernetes.Interface) *Recorder {
+	instance, _ := os.Hostname()
+	if instance == "" {
+		instance = "certchecker"
+	}
+	return &Recorder{
+		Clientset: clientset,
+		Instance:  instance,
+		Now:       time.Now,
+	}
+}
+
+type secretKey struct {
+	Namespace string
+	Name      string
+}
+
+/**
+the findings for one secret, grouped by event reason. Each note is a line describing one finding
+*/
+type secretProblems map[string][]string
+
+/**
+groups the findings in the results by secret and event reason
+*/
+func collectProblems(results []datapersistence.CheckRecord) map[secretKey]secretProblems {
+	problems := make(map[secretKey]secretProblems)
+	for i := range results {
+		rec := &results[i]
+		if rec.Source.Kind != datapersistence.SourceSecret || rec.Source.Namespace == "" {
+			continue
+		}
+		for _, finding := range rec.Findings {
+			reason, haveReason := EventReasons[finding.Code]
+			if !haveReason {
+				continue
+			}
+			key := secretKey{Namespace: rec.Source.Namespace, Name: rec.Source.Name}
+			if problems[key] == nil {
+				problems[key] = make(secretProblems)
+			}
+			problems[key][reason] = append(problems[key][reason], describeFinding(rec, &finding))
+		}
+	}
+	return problems
+}
+
+func describeFinding(rec *datapersistence.CheckRecord, finding *datapersistence.Finding) string {
+	where := rec.Source.DataKey
+	if rec.Source.Alias != "" {
+		where += "/" + rec.Source.Alias
+	}
+	if where == "" {
+		return finding.Message
+	}
+	return where + ": " + finding.Message
+}
+
+/**
+a name for the event that is the same on every run, so that repeats can be found and counted
+*/
+func eventName(secretName string, reason string) string {
+	sum := sha1.Sum([]byte(secretName + "/" + reason))
+	if len(secretName) > 200 {
+		secretName = secretName[:200]
+	}
+	return fmt.Sprintf("%s.certchecker-%s", secretName, hex.EncodeToString(sum[:])[:10])
+}
+
+func truncateNote(note string) string {
+	if len(note) <= maxNoteLength {
+		return note
+	}
+	return note[:maxNoteLength-3] + "..."
+}
+
+/**
+creates or updates the events for every secret with problems in the results.  Returns the number of events that
+could not be written; failures are logged and don't stop the others
+*/
+func (r *Recorder) RecordResults(ctx context.Context, results []datapersistence.CheckRecord) int {
+	failures := 0
+	for key, problems := range collectProblems(results) {
+		secret, getErr := r.Clientset.CoreV1().Secrets(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+		if getErr != nil {
+			log.Printf("ERROR Could not look up secret %s:%s to record events on: %s", key.Namespace, key.Name, getErr)
+			failures++
+			continue
+		}
+
+		reasons := make([]string, 0, len(problems))
+		for reason := range problems {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			note := truncateNote(strings.Join(problems[reason], "; "))
+			if err := r.record(ctx, secret, reason, note); err != nil {
+				log.Printf("ERROR Could not record %s event on %s:%s: %s", reason, key.Namespace, key.Name, err)
+				failures++
+			}
+		}
+	}
+	return failures
+}
+
+func (r *Recorder) record(ctx context.Context, secret *corev1.Secret, reason string, note string) error {
+	client := r.Clientset.EventsV1().Events(secret.Namespace)
+	name := eventName(secret.Name, reason)
+	now := r.Now()
+
+	existing, getErr := client.Get(ctx, name, metav1.GetOptions{})
+	if getErr == nil {
+		if existing.Series == nil {
+			existing.Series = &eventsv1.EventSeries{Count: 1}
+		}
+		existing.Series.Count++
+		existing.Series.LastObservedTime = metav1.NewMicroTime(now)
+		existing.Note = note
+		_, updateErr := client.Update(ctx, existing, metav1.UpdateOptions{})
+		return updateErr
+	} else if !apierrors.IsNotFound(getErr) {
+		return getErr
+	}
+
+	event := &eventsv1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: secret.Namespace,
+		},
+		EventTime:           metav1.NewMicroTime(now),
+		ReportingController: ReportingController,
+		ReportingInstance:   r.Instance,
+		Action:              EventAction,
+		Reason:              reason,
+		Type:                corev1.EventTypeWarning,
+		Note:                note,
+		Regarding: corev1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "Secret",
+			Namespace:       secret.Namespace,
+			Name:            secret.Name,
+			UID:             secret.UID,
+			ResourceVersion: secret.ResourceVersion,
+		},
+	}
+	_, createErr := client.Create(ctx, event, metav1.CreateOptions{})
+	return createErr
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func TestRecordResults(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web-tls", UID: "1234"},
+	})
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	recorder := NewRecorder(clientset)
+	recorder.Now = func() time.Time { return now }
+
+	source := datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: "web-tls", DataKey: "tls.crt"}
+	results := []datapersistence.CheckRecord{{
+		Source: source,
+		Findings: []datapersistence.Finding{
+			{Code: "NearExpiry", Severity: datapersistence.SeverityWarning, Message: "certificate expires soon"},
+			{Code: "KeyMismatch", Severity: datapersistence.SeverityCritical, Message: "the key does not match"},
+			{Code: "WeakRSAKey", Severity: datapersistence.SeverityCritical, Message: "not an event reason"},
+		},
+	}}
+
+	if failures := recorder.RecordResults(context.Background(), results); failures != 0 {
+		t.Fatalf("expected no failures, got %d", failures)
+	}
+	events, _ := clientset.EventsV1().Events("web").List(context.Background(), metav1.ListOptions{})
+	if len(events.Items) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events.Items))
+	}
+	for _, event := range events.Items {
+		if event.Type != corev1.EventTypeWarning || event.Regarding.Kind != "Secret" || event.Regarding.UID != "1234" {
+			t.Errorf("unexpected event %+v", event)
+		}
+		if event.Reason == "CertificateNearExpiry" && event.Note != "tls.crt: certificate expires soon" {
+			t.Errorf("unexpected note '%s'", event.Note)
+		}
+		if event.Series != nil {
+			t.Errorf("a new event should not have a series yet")
+		}
+	}
+
+	now = now.Add(time.Hour)
+	recorder.RecordResults(context.Background(), results)
+	recorder.RecordResults(context.Background(), results)
+	events, _ = clientset.EventsV1().Events("web").List(context.Background(), metav1.ListOptions{})
+	if len(events.Items) != 2 {
+		t.Fatalf("repeats should update the existing events, got %d events", len(events.Items))
+	}
+	for _, event := range events.Items {
+		if event.Series == nil || event.Series.Count != 3 || !event.Series.LastObservedTime.Time.Equal(now) {
+			t.Errorf("expected a series count of 3 last observed at %s, got %+v", now, event.Series)
+		}
+	}
+}
+
+func TestRecordResultsSkipsOtherSources(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	results := []datapersistence.CheckRecord{{
+		Source:   datapersistence.SourceDescriptor{Kind: datapersistence.SourceFile, Name: "/etc/ssl/cert.pem"},
+		Findings: []datapersistence.Finding{{Code: "Expired", Severity: datapersistence.SeverityCritical}},
+	}}
+	if failures := NewRecorder(clientset).RecordResults(context.Background(), results); failures != 0 {
+		t.Errorf("expected files to be skipped, got %d failures", failures)
+	}
+}
@@ -0,0 +1,231 @@
+package gateways
+
+import (
+	"context"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certfinder"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"strings"
+	"time"
+)
+
+/**
+Checker finds the TLS listeners of Gateway API and Istio Gateways, maps them onto the secrets that hold their certs
+and checks that each cert covers the hostnames its listener serves
+*/
+type Checker struct {
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	Now       func() time.Time
+}
+
+func NewChecker(clientset kubernetes.Interface, dynamicClient dynamic.Interface) *Checker {
+	return &Checker{
+		Clientset: clientset,
+		Dynamic:   dynamicClient,
+		Now:       time.Now,
+	}
+}
+
+/**
+adds the finding and works out the record's Severity and CheckResult again in the same way as for certificates, so
+that e.g. a hostname the cert doesn't cover makes the result HasCriticalFindings
+*/
+func addFinding(rec *datapersistence.GatewayTLSRecord, finding datapersistence.Finding) {
+	rec.Findings = append(rec.Findings, finding)
+	rec.Severity, rec.CheckResult = certs.WorstOf(rec.Findings)
+}
+
+func gatewaySource(kind datapersistence.SourceKind, gateway *unstructured.Unstructured, listener *certfinder.TLSListener) datapersistence.SourceDescriptor {
+	return datapersistence.SourceDescriptor{
+		Kind:      kind,
+		Namespace: gateway.GetNamespace(),
+		Name:      gateway.GetName(),
+		DataKey:   listener.Name,
+	}
+}
+
+/**
+checks every TLS listener on every Gateway in the cluster, returning a record for each secret that a listener refers
+to.  Gateways are skipped if the Gateway API or Istio isn't installed
+*/
+func (c *Checker) CheckAll(ctx context.Context) []datapersistence.GatewayTLSRecord {
+	records := make([]datapersistence.GatewayTLSRecord, 0)
+
+	gateways, listErr := certfinder.ListGateways(ctx, c.Dynamic)
+	if listErr != nil {
+		log.Printf("ERROR Could not list Gateways: %s", listErr)
+	}
+	var grants []unstructured.Unstructured
+	if len(gateways) > 0 {
+		var grantsErr error
+		if grants, grantsErr = certfinder.ListReferenceGrants(ctx, c.Dynamic); grantsErr != nil {
+			log.Printf("WARNING Could not list ReferenceGrants, so certificate references to other namespaces will be reported as not permitted: %s", grantsErr)
+		}
+	}
+	for i := range gateways {
+		gateway := &gateways[i]
+		for _, listener := range certfinder.GatewayTLSListeners(gateway) {
+			source := gatewaySource(datapersistence.SourceGateway, gateway, &listener)
+			for _, other := range listener.OtherRefs {
+				log.Printf("INFO %s refers to %s, which is not a secret so can't be checked", listenerName(source), other)
+			}
+			for _, ref := range listener.SecretRefs {
+				if !certfinder.ReferenceGrantAllows(grants, gateway.GetNamespace(), ref) {
+					records = append(records, c.notPermitted(source, &listener, ref))
+					continue
+				}
+				records = append(records, c.Check(ctx, source, &listener, ref))
+			}
+		}
+	}
+
+	istioGateways, istioErr := certfinder.ListIstioGateways(ctx, c.Dynamic)
+	if istioErr != nil {
+		log.Printf("ERROR Could not list Istio Gateways: %s", istioErr)
+	}
+	for i := range istioGateways {
+		gateway := &istioGateways[i]
+		namespaces, namespacesErr := certfinder.IstioCredentialNamespaces(ctx, c.Clientset, gateway)
+		if namespacesErr != nil {
+			log.Printf("WARNING Could not find the workload of Istio Gateway %s/%s, looking for its credentials in its own namespace: %s", gateway.GetNamespace(), gateway.GetName(), namespacesErr)
+		}
+		for _, listener := range certfinder.IstioTLSListeners(gateway, namespaces) {
+			source := gatewaySource(datapersistence.SourceIstioGateway, gateway, &listener)
+			for _, ref := range listener.SecretRefs {
+				records = append(records, c.Check(ctx, source, &listener, ref))
+			}
+		}
+	}
+
+	for _, rec := range records {
+		switch {
+		case rec.CheckResult == datapersistence.Errored:
+			log.Printf("ERROR %s can't use %s: %s", listenerName(rec.Source), rec.Secret, describe(&rec))
+		case len(rec.Findings) > 0:
+			log.Printf("WARNING %s: %s", listenerName(rec.Source), describe(&rec))
+		default:
+			log.Printf("INFO %s is serving a cert from %s that covers its hostnames", listenerName(rec.Source), rec.Secret)
+		}
+	}
+	return records
+}
+
+/**
+e.g. "IstioGateway istio-system/public listener https"
+*/
+func listenerName(source datapersistence.SourceDescriptor) string {
+	return fmt.Sprintf("%s %s/%s listener %s", source.Kind, source.Namespace, source.Name, source.DataKey)
+}
+
+func describe(rec *datapersistence.GatewayTLSRecord) string {
+	messages := make([]string, 0)
+	for _, finding := range rec.Findings {
+		messages = append(messages, finding.Message)
+	}
+	if rec.Error != "" {
+		messages = append(messages, rec.Error)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (c *Checker) newRecord(source datapersistence.SourceDescriptor, listener *certfinder.TLSListener, ref certfinder.SecretRef) datapersistence.GatewayTLSRecord {
+	return datapersistence.GatewayTLSRecord{
+		Source:    source,
+		Hostnames: listener.Hostnames,
+		Secret: datapersistence.SourceDescriptor{
+			Kind:      datapersistence.SourceSecret,
+			Namespace: ref.Namespace,
+			Name:      ref.Name,
+			DataKey:   v1.TLSCertKey,
+		},
+		CheckedAt: c.Now(),
+	}
+}
+
+func (c *Checker) notPermitted(source datapersistence.SourceDescriptor, listener *certfinder.TLSListener, ref certfinder.SecretRef) datapersistence.GatewayTLSRecord {
+	rec := c.newRecord(source, listener, ref)
+	addFinding(&rec, datapersistence.Finding{
+		Code:     "RefNotPermitted",
+		Severity: datapersistence.SeverityCritical,
+		Result:   datapersistence.ResultOf(datapersistence.Errored),
+		Message:  fmt.Sprintf("no ReferenceGrant in %s lets Gateways in %s use the secret", ref.Namespace, source.Namespace),
+	})
+	return rec
+}
+
+/**
+the keys that a listener's cert can be read from, most preferred first.  As well as the usual tls.crt, Istio accepts
+generic secrets that keep the cert in `cert` (with `key` and `cacert`)
+*/
+func certKeys(kind datapersistence.SourceKind) []string {
+	if kind == datapersistence.SourceIstioGateway {
+		return []string{v1.TLSCertKey, "cert"}
+	}
+	return []string{v1.TLSCertKey}
+}
+
+/**
+checks a single secret that a listener refers to: that it exists, holds a readable cert and that the cert is valid
+for every hostname the listener serves.  Problems are recorded on the returned record rather than returned as an
+error, so that they end up in the report
+*/
+func (c *Checker) Check(ctx context.Context, source datapersistence.SourceDescriptor, listener *certfinder.TLSListener, ref certfinder.SecretRef) datapersistence.GatewayTLSRecord {
+	rec := c.newRecord(source, listener, ref)
+
+	secret, getErr := c.Clientset.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if errors.IsNotFound(getErr) {
+		addFinding(&rec, datapersistence.Finding{
+			Code:     "SecretNotFound",
+			Severity: datapersistence.SeverityCritical,
+			Result:   datapersistence.ResultOf(datapersistence.Errored),
+			Message:  "the secret does not exist",
+		})
+		return rec
+	}
+	if getErr != nil {
+		rec.CheckResult = datapersistence.Errored
+		rec.Error = getErr.Error()
+		return rec
+	}
+	var certData []byte
+	keys := certKeys(source.Kind)
+	for _, key := range keys {
+		if data, haveData := secret.Data[key]; haveData {
+			certData = data
+			rec.Secret.DataKey = key
+			break
+		}
+	}
+	if certData == nil {
+		rec.CheckResult = datapersistence.Errored
+		rec.Error = fmt.Sprintf("secret %s has no %s", rec.Secret, strings.Join(keys, " or "))
+		return rec
+	}
+	cert, _, loadErr := certs.LoadCert(certData, rec.Secret.String())
+	if loadErr != nil {
+		rec.CheckResult = datapersistence.Errored
+		rec.Error = loadErr.Error()
+		return rec
+	}
+
+	rec.CheckResult = datapersistence.WithinRange
+	rec.Fingerprint = certs.Fingerprint(cert)
+	rec.ValidUntil = cert.NotAfter
+	if uncovered := certs.UncoveredHostnames(cert, listener.Hostnames); len(uncovered) > 0 {
+		addFinding(&rec, datapersistence.Finding{
+			Code:     "HostnameNotCovered",
+			Severity: datapersistence.SeverityCritical,
+			Message:  fmt.Sprintf("the cert is not valid for %s, which the listener serves", strings.Join(uncovered, ", ")),
+		})
+	}
+	return rec
+}
@@ -0,0 +1,169 @@
+package gateways
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/guardian/k8s-certchecker/certchecker/certfinder"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tlsSecret(t *testing.T, namespace string, name string, dnsNames ...string) *v1.Secret {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Type:       v1.SecretTypeTLS,
+		Data:       map[string][]byte{v1.TLSCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})},
+	}
+}
+
+func object(apiVersion string, kind string, namespace string, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"spec":       spec,
+	}}
+}
+
+func listener(name string, hostname string, refs ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"hostname": hostname,
+		"protocol": "HTTPS",
+		"tls":      map[string]interface{}{"mode": "Terminate", "certificateRefs": refs},
+	}
+}
+
+/**
+objects are created through the client rather than passed to the constructor, which would guess the wrong resource
+for Gateways
+*/
+func newDynamicClient(t *testing.T, objects ...*unstructured.Unstructured) *dynamicfake.FakeDynamicClient {
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for _, version := range certfinder.GatewayVersions {
+		listKinds[certfinder.GatewayResource(version)] = "GatewayList"
+	}
+	for _, version := range certfinder.ReferenceGrantVersions {
+		listKinds[certfinder.ReferenceGrantResource(version)] = "ReferenceGrantList"
+	}
+	for _, version := range certfinder.IstioGatewayVersions {
+		listKinds[certfinder.IstioGatewayResource(version)] = "GatewayList"
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	for _, obj := range objects {
+		gv, _ := schema.ParseGroupVersion(obj.GetAPIVersion())
+		resource := gv.WithResource(strings.ToLower(obj.GetKind()) + "s")
+		if _, err := client.Resource(resource).Namespace(obj.GetNamespace()).Create(context.Background(), obj, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client
+}
+
+func TestCheckAll(t *testing.T) {
+	//Istio also accepts generic secrets with the cert in `cert`
+	generic := tlsSecret(t, "istio-system", "grpc-cred", "grpc.example.com")
+	generic.Type = v1.SecretTypeOpaque
+	generic.Data = map[string][]byte{"cert": generic.Data[v1.TLSCertKey]}
+	clientset := fake.NewSimpleClientset(
+		tlsSecret(t, "web", "web-tls", "*.example.com"),
+		tlsSecret(t, "infra", "api-tls", "api.example.org"),
+		tlsSecret(t, "secure", "admin-tls", "admin.example.com"),
+		generic,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istio-ingressgateway-7d9f", Labels: map[string]string{"istio": "ingressgateway"}}},
+	)
+	dynamicClient := newDynamicClient(t,
+		object("gateway.networking.k8s.io/v1", "Gateway", "infra", "public", map[string]interface{}{
+			"listeners": []interface{}{
+				listener("https", "www.example.com", map[string]interface{}{"name": "web-tls", "namespace": "web"}),
+				listener("api", "api.other.org", map[string]interface{}{"name": "api-tls"}),
+				listener("admin", "admin.example.com", map[string]interface{}{"name": "admin-tls", "namespace": "secure"}),
+				map[string]interface{}{"name": "http", "protocol": "HTTP"},
+			},
+		}),
+		object("gateway.networking.k8s.io/v1beta1", "ReferenceGrant", "web", "allow-infra", map[string]interface{}{
+			"from": []interface{}{map[string]interface{}{"group": "gateway.networking.k8s.io", "kind": "Gateway", "namespace": "infra"}},
+			"to":   []interface{}{map[string]interface{}{"group": "", "kind": "Secret"}},
+		}),
+		object("networking.istio.io/v1", "Gateway", "shop", "mesh", map[string]interface{}{
+			"selector": map[string]interface{}{"istio": "ingressgateway"},
+			"servers": []interface{}{
+				map[string]interface{}{
+					"port":  map[string]interface{}{"name": "https", "number": int64(443)},
+					"hosts": []interface{}{"*/shop.example.com"},
+					"tls":   map[string]interface{}{"mode": "SIMPLE", "credentialName": "shop-tls"},
+				},
+				map[string]interface{}{
+					"port":  map[string]interface{}{"name": "grpc", "number": int64(8443)},
+					"hosts": []interface{}{"grpc.example.com"},
+					"tls":   map[string]interface{}{"mode": "SIMPLE", "credentialName": "grpc-cred"},
+				},
+				map[string]interface{}{
+					"port":  map[string]interface{}{"name": "passthrough", "number": int64(8443)},
+					"hosts": []interface{}{"*"},
+					"tls":   map[string]interface{}{"mode": "PASSTHROUGH"},
+				},
+			},
+		}),
+	)
+
+	records := NewChecker(clientset, dynamicClient).CheckAll(context.Background())
+	byListener := make(map[string]datapersistence.GatewayTLSRecord)
+	for _, rec := range records {
+		byListener[listenerName(rec.Source)] = rec
+	}
+	if len(byListener) != 5 {
+		t.Fatalf("expected 5 TLS listeners to be checked, got %+v", records)
+	}
+
+	https := byListener["Gateway infra/public listener https"]
+	if https.CheckResult != datapersistence.WithinRange || len(https.Findings) != 0 || https.Fingerprint == "" {
+		t.Errorf("expected the wildcard cert granted from web to cover www.example.com, got %+v", https)
+	}
+	api := byListener["Gateway infra/public listener api"]
+	if len(api.Findings) != 1 || api.Findings[0].Code != "HostnameNotCovered" || api.Severity != datapersistence.SeverityCritical ||
+		api.CheckResult != datapersistence.HasCriticalFindings {
+		t.Errorf("expected api.other.org not to be covered, got %+v", api)
+	}
+	admin := byListener["Gateway infra/public listener admin"]
+	if admin.CheckResult != datapersistence.Errored || len(admin.Findings) != 1 || admin.Findings[0].Code != "RefNotPermitted" {
+		t.Errorf("expected the reference to secure to need a ReferenceGrant, got %+v", admin)
+	}
+	shop := byListener["IstioGateway shop/mesh listener https"]
+	if shop.Secret.Namespace != "istio-system" || len(shop.Findings) != 1 || shop.Findings[0].Code != "SecretNotFound" ||
+		shop.CheckResult != datapersistence.Errored {
+		t.Errorf("expected the credential to be looked for in the gateway workload's namespace, got %+v", shop)
+	}
+	if len(shop.Hostnames) != 1 || shop.Hostnames[0] != "shop.example.com" {
+		t.Errorf("expected the namespace to be taken off the Istio host, got %v", shop.Hostnames)
+	}
+	grpc := byListener["IstioGateway shop/mesh listener grpc"]
+	if grpc.CheckResult != datapersistence.WithinRange || len(grpc.Findings) != 0 || grpc.Secret.DataKey != "cert" {
+		t.Errorf("expected the cert to be read from the generic secret's cert key, got %+v", grpc)
+	}
+}
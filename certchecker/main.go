@@ -3,43 +3,154 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
 	certfinder2 "github.com/guardian/k8s-certchecker/certchecker/certfinder"
-	certs2 "github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/certchecker/events"
+	"github.com/guardian/k8s-certchecker/certchecker/gateways"
+	"github.com/guardian/k8s-certchecker/certchecker/notify"
+	"github.com/guardian/k8s-certchecker/certchecker/probe"
+	"github.com/guardian/k8s-certchecker/certchecker/writeback"
 	"github.com/guardian/k8s-certchecker/datapersistence"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 	homedir2 "k8s.io/client-go/util/homedir"
 	"log"
 	"os"
 	"path"
+	"strings"
 	"time"
 	//add auth plugins, required to use e.g. openid connect
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
 
-func getClientset(kubeconfigPath string) *kubernetes.Clientset {
-	clusterConfig, configErr := rest.InClusterConfig()
-	if configErr == nil {
-		return kubernetes.NewForConfigOrDie(clusterConfig)
+type scanSettings struct {
+	*checkSettings
+	SourceOptions  certfinder2.SourceOptions
+	ProbeEndpoints bool
+	ProbeTimeout   time.Duration
+	EmitEvents     bool
+	WriteBack      bool
+	WriteBackDry   bool
+	FindConsumers  bool
+	// CertManagerNamespace is where cert-manager keeps the secrets of ClusterIssuers
+	CertManagerNamespace string
+	CheckGateways        bool
+	FindDuplicates       bool
+	Remediation          *remediationSettings
+}
+
+/**
+scans a single cluster for certificates, checks them, (optionally) fixes them and (optionally) checks its gateways
+and probes its endpoints.  The results are returned as a report for just this cluster.  An error is returned only if
+the cluster could not be scanned at all
+*/
+func scanCluster(ctx context.Context, cluster *clusterClient, settings *scanSettings) (*datapersistence.PersistenceRecord, error) {
+	if cluster.Name != "" {
+		log.Printf("INFO Scanning cluster %s", cluster.Name)
+	}
+	foundCerts, scanErr := certfinder2.ScanForCertificates(ctx, certfinder2.DefaultSources(cluster.Clientset, settings.SourceOptions))
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	log.Printf("INFO Got %d certs", len(*foundCerts))
+
+	results := checkCertificates(foundCerts, settings.checkSettings, cluster.Name)
+	if settings.FindConsumers {
+		consumers := certfinder2.IndexConsumers(ctx, cluster.Clientset, cluster.Dynamic, settings.CertManagerNamespace)
+		if settings.Remediation != nil {
+			settings.Remediation.addOwnSecrets(consumers)
+		}
+		consumers.Attach(results)
+	}
+	clusterReport := &datapersistence.PersistenceRecord{
+		Cluster: cluster.Name,
+		Results: results,
+	}
+	if settings.FindDuplicates {
+		clusterReport.Duplicates = datapersistence.FindDuplicates(results)
+		flagDuplicates(results, clusterReport.Duplicates)
+	}
+
+	if settings.EmitEvents {
+		if settings.AsOf.IsZero() {
+			events.NewRecorder(cluster.Clientset).RecordResults(ctx, results)
+		} else {
+			log.Print("INFO Not recording events for an -as-of forecast")
+		}
+	}
+	if settings.WriteBack {
+		if settings.AsOf.IsZero() {
+			writeback.NewWriter(cluster.Clientset, settings.WriteBackDry).WriteBack(ctx, results)
+		} else {
+			log.Print("INFO Not writing status back for an -as-of forecast")
+		}
+	}
+
+	if settings.Remediation != nil {
+		if settings.AsOf.IsZero() {
+			clusterReport.Remediations = remediate(ctx, cluster, settings.Remediation, results)
+		} else {
+			log.Print("INFO Not fixing certs for an -as-of forecast")
+		}
+	}
+
+	if settings.CheckGateways {
+		clusterReport.GatewayTLS = gateways.NewChecker(cluster.Clientset, cluster.Dynamic).CheckAll(ctx)
+		for i := range clusterReport.GatewayTLS {
+			clusterReport.GatewayTLS[i].Cluster = cluster.Name
+		}
 	}
-	log.Printf("INFO Could not get in-cluster configuration: %s, falling back to out-of-cluster", configErr)
-	localConfig, localErr := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	if localErr == nil {
-		return kubernetes.NewForConfigOrDie(localConfig)
+
+	if !settings.ProbeEndpoints {
+		return clusterReport, nil
 	}
 
-	panic(fmt.Sprintf("ERROR Could not get either in-cluster configuration or out-of-cluster: %s", localErr))
+	prober := probe.NewProber(cluster.Clientset, settings.ProbeTimeout)
+	probes := prober.ProbeAll(ctx)
+	for i := range probes {
+		probeResult := &probes[i]
+		probeResult.Cluster = cluster.Name
+		switch probeResult.CheckResult {
+		case datapersistence.StaleCertInUse:
+			log.Printf("%s (%s) has a stale cert in use, %s does not match the stored %s", probeResult.Source, probeResult.Endpoint, probeResult.PresentedFingerprint, probeResult.StoredFingerprint)
+		case datapersistence.Errored:
+			log.Printf("%s (%s) could not be probed: %s", probeResult.Source, probeResult.Endpoint, probeResult.Error)
+		case datapersistence.NotCompared:
+			log.Printf("%s (%s) is reachable, but there is no stored cert to compare it with", probeResult.Source, probeResult.Endpoint)
+		default:
+			log.Printf("%s (%s) is serving the expected cert", probeResult.Source, probeResult.Endpoint)
+		}
+	}
+	clusterReport.Probes = probes
+	return clusterReport, nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "scan-files" {
+		scanFilesMain(os.Args[2:])
+		return
+	}
+
 	homedir := homedir2.HomeDir()
 	pwd, _ := os.Getwd()
 	//inputFile := flag.String("input", "", "filename to read")
 	kubeConfig := flag.String("kubeconfig", path.Join(homedir, ".kube", "config"), "kubeconfig file (only used if out of cluster)")
+	contextsList := flag.String("contexts", "", "comma-separated list of kubeconfig contexts to scan, instead of the current cluster")
+	allContexts := flag.Bool("all-contexts", false, "scan every context in the kubeconfig")
+	clusterName := flag.String("cluster-name", "", "name to record for the cluster when not using -contexts or -all-contexts")
 	outputPath := flag.String("out", pwd, "path to create an output record in")
-	durationString := flag.String("warning", "720h", "expiry warning period")
+	checkOptions := registerCheckFlags(flag.CommandLine)
+	probeEndpoints := flag.Bool("probe", false, "dial the TLS endpoints of Services and Ingresses and check they are serving the stored cert")
+	keystorePasswordKeys := flag.String("keystore-password-keys", strings.Join(certfinder2.DefaultKeystorePasswordKeys, ","), "comma-separated list of sibling keys to try for keystore passwords")
+	probeTimeout := flag.Duration("probe-timeout", 5*time.Second, "connection timeout when probing endpoints")
+	emitEvents := flag.Bool("events", false, "create Warning events on secrets whose certs are expired, near expiry or don't match their key")
+	writeBack := flag.Bool("write-back", false, "label and annotate each scanned secret with the status of its certs")
+	writeBackDryRun := flag.Bool("write-back-dry-run", false, "with -write-back, have the server validate the changes without saving them")
+	findDuplicates := flag.Bool("find-duplicates", false, "report certs that are copied into several secrets, stale copies of renewed certs and private keys shared between certs")
+	checkGateways := flag.Bool("check-gateways", false, "check that the certs used by Gateway API and Istio Gateways exist, may be used and cover their listeners' hostnames")
+	findConsumers := flag.Bool("find-consumers", false, "record the workloads, ingresses and gateways that use each secret and flag the secrets that nothing uses")
+	certManagerNamespace := flag.String("cert-manager-namespace", "cert-manager", "namespace that cert-manager keeps ClusterIssuer secrets in (its --cluster-resource-namespace)")
+	writeReports := flag.Bool("write-reports", false, "write the results into each scanned cluster as CertificateReport resources")
+	remediationOptions := registerRemediationFlags(flag.CommandLine)
+	notifyOptions := registerNotifyFlags(flag.CommandLine)
+	emailOptions := registerEmailFlags(flag.CommandLine)
 	flag.Parse()
 
 	//if *inputFile == "" {
@@ -47,9 +158,17 @@ func main() {
 	//	os.Exit(1)
 	//}
 
-	warningDuration, durParseErr := time.ParseDuration(*durationString)
-	if durParseErr != nil {
-		log.Fatalf("Could not parse '%s' into a duration: %s", *durationString, durParseErr)
+	checks, checksErr := checkOptions.Settings()
+	if checksErr != nil {
+		log.Fatalf("Invalid options: %s", checksErr)
+	}
+	digest, digestErr := emailOptions.Notifier()
+	if digestErr != nil {
+		log.Fatalf("Invalid options: %s", digestErr)
+	}
+	remediation, remediationErr := remediationOptions.Settings()
+	if remediationErr != nil {
+		log.Fatalf("Invalid options: %s", remediationErr)
 	}
 
 	//fp, openErr := os.Open(*inputFile)
@@ -62,47 +181,95 @@ func main() {
 	//	log.Fatalf("Could not read data from %s: %s", *inputFile, readErr)
 	//}
 
-	clientset := getClientset(*kubeConfig)
+	settings := &scanSettings{
+		checkSettings: checks,
+		SourceOptions: certfinder2.SourceOptions{
+			KeystorePasswordKeys: strings.Split(*keystorePasswordKeys, ","),
+		},
+		ProbeEndpoints:       *probeEndpoints,
+		ProbeTimeout:         *probeTimeout,
+		EmitEvents:           *emitEvents,
+		WriteBack:            *writeBack,
+		WriteBackDry:         *writeBackDryRun,
+		FindConsumers:        *findConsumers,
+		CertManagerNamespace: *certManagerNamespace,
+		CheckGateways:        *checkGateways,
+		FindDuplicates:       *findDuplicates,
+		Remediation:          remediation,
+	}
 
-	foundCerts, scanErr := certfinder2.ScanForCertificates(context.Background(), clientset)
-	if scanErr != nil {
-		log.Fatal("Could not scan for certs: ", scanErr)
+	var contexts []string
+	if *contextsList != "" {
+		contexts = strings.Split(*contextsList, ",")
 	}
-	log.Printf("INFO Got %d certs", len(*foundCerts))
+	clusters, clusterFailures := getClusterClients(*kubeConfig, contexts, *allContexts, *clusterName)
 
-	results := make([]datapersistence.CheckRecord, 0)
+	report := datapersistence.PersistenceRecord{
+		CheckedAt: time.Now(),
+		AsOf:      checks.ReportAsOf(),
+		Clusters:  clusterFailures,
+		Results:   make([]datapersistence.CheckRecord, 0),
+	}
+	if len(clusters) == 1 && len(clusterFailures) == 0 {
+		report.Cluster = clusters[0].Name
+	}
 
-	for _, entry := range *foundCerts {
-		description := fmt.Sprintf("%s:%s", entry.Namespace, entry.SecretName)
-		cert, _, err := certs2.LoadCert(entry.RawCertificateData, description)
-		if err != nil {
-			log.Fatalf("Could not load %s as an x509 certificate: %s", description, err)
+	for i := range clusters {
+		status := datapersistence.ClusterStatus{Name: clusters[i].Name}
+		clusterReport, scanErr := scanCluster(context.Background(), &clusters[i], settings)
+		if scanErr != nil {
+			log.Printf("ERROR Could not scan cluster %s for certs: %s", clusters[i].Name, scanErr)
+			status.Error = scanErr.Error()
+		} else {
+			status.Certificates = len(clusterReport.Results)
+			report.Results = append(report.Results, clusterReport.Results...)
+			report.Probes = append(report.Probes, clusterReport.Probes...)
+			report.GatewayTLS = append(report.GatewayTLS, clusterReport.GatewayTLS...)
+			report.Remediations = append(report.Remediations, clusterReport.Remediations...)
 		}
-
-		result, err := certs2.ValidateCertTimes(cert, warningDuration, entry.Namespace, entry.SecretName)
-		if err != nil {
-			log.Fatalf("Could not validate %s: %s", description, err)
+		if digest != nil {
+			routesErr := notify.LoadEmailRoutes(context.Background(), clusters[i].Clientset, clusters[i].Name, *emailOptions.RoutesMap, digest.Router)
+			if routesErr != nil {
+				log.Printf("ERROR Could not load email routes for cluster %s: %s", clusters[i].Name, routesErr)
+			}
 		}
+		report.Clusters = append(report.Clusters, status)
 
-		results = append(results, result)
-		switch result.CheckResult {
-		case datapersistence.NotValidYet:
-			log.Printf("%s is not valid yet", description)
-		case datapersistence.NearExpiry:
-			log.Printf("%s is near expiry", description)
-		case datapersistence.AfterExpiry:
-			log.Printf("%s has already expired", description)
-		case datapersistence.WithinRange:
-			log.Printf("%s is OK", description)
-		case datapersistence.TooLongForChrome:
-			log.Printf("%s is too long to be valid in Chrome", description)
+		//a forecast would replace the cluster's current reports with a picture of the future
+		if *writeReports && scanErr == nil && report.AsOf == nil {
+			clusterReport.CheckedAt = report.CheckedAt
+			clusterReport.AsOf = report.AsOf
+			clusterReport.Clusters = []datapersistence.ClusterStatus{status}
+			if apiErr := datapersistence.WriteReportToAPI(context.Background(), clusters[i].Dynamic, clusterReport); apiErr != nil {
+				log.Printf("ERROR Could not write CertificateReports to cluster %s: %s", clusters[i].Name, apiErr)
+			}
 		}
 	}
 
-	writeErr := datapersistence.WriteData(*outputPath, &results)
+	if scannedErr := checkAnyScanned(report.Clusters); scannedErr != nil {
+		log.Fatalf("ERROR %s", scannedErr)
+	}
+
+	if settings.FindDuplicates {
+		//copies can be spread across clusters as well as namespaces
+		report.Duplicates = datapersistence.FindDuplicates(report.Results)
+		flagDuplicates(report.Results, report.Duplicates)
+	}
+
+	//this has to be read before the new report is written, otherwise it would be the "previous" report
+	previous, previousErr := datapersistence.ReadLatestReport(*outputPath)
+	if previousErr != nil {
+		log.Printf("INFO No previous report to compare with in %s: %s", *outputPath, previousErr)
+	}
+
+	writeErr := datapersistence.WriteReport(*outputPath, &report)
 	if writeErr != nil {
 		log.Fatalf("ERROR Could not write out final report: %s", writeErr)
-	} else {
-		log.Print("All done.")
 	}
+
+	notifyTransitions(context.Background(), notifyOptions.Notifiers(), previous, &report)
+	if digest != nil && report.AsOf == nil {
+		digest.SendDigest(context.Background(), &report)
+	}
+	log.Print("All done.")
 }
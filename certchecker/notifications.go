@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/notify"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"log"
+	"os"
+	"strings"
+)
+
+/**
+the commandline options that configure where notifications go.  Each takes a comma-separated list of URLs
+*/
+type notifyFlags struct {
+	Webhooks *string
+	Slack    *string
+	Teams    *string
+}
+
+func registerNotifyFlags(flags *flag.FlagSet) *notifyFlags {
+	return &notifyFlags{
+		Webhooks: flags.String("notify-webhook", "", "comma-separated list of URLs to POST json notifications of status changes to"),
+		Slack:    flags.String("notify-slack", "", "comma-separated list of Slack incoming webhook URLs to notify of status changes"),
+		Teams:    flags.String("notify-teams", "", "comma-separated list of Microsoft Teams incoming webhook URLs to notify of status changes"),
+	}
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(item); trimmed != "" {
+			items = append(items, trimmed)
+		}
+	}
+	return items
+}
+
+func (f *notifyFlags) Notifiers() []notify.Notifier {
+	notifiers := make([]notify.Notifier, 0)
+	for _, url := range splitList(*f.Webhooks) {
+		notifiers = append(notifiers, notify.NewWebhookNotifier(url))
+	}
+	for _, url := range splitList(*f.Slack) {
+		notifiers = append(notifiers, notify.NewSlackNotifier(url))
+	}
+	for _, url := range splitList(*f.Teams) {
+		notifiers = append(notifiers, notify.NewTeamsNotifier(url))
+	}
+	return notifiers
+}
+
+/**
+tells the notifiers about every certificate whose result has changed since the previous report.  Nothing is sent if
+nothing has changed, so that people only hear about it when something needs doing.  Forecasts made with -as-of
+are not notified, since nothing has really changed
+*/
+func notifyTransitions(ctx context.Context, notifiers []notify.Notifier, previous *datapersistence.PersistenceRecord, report *datapersistence.PersistenceRecord) {
+	if len(notifiers) == 0 {
+		return
+	}
+	if report.AsOf != nil {
+		log.Print("INFO Not sending notifications for an -as-of forecast")
+		return
+	}
+	transitions := notify.FindTransitions(previous, report)
+	if len(transitions) == 0 {
+		log.Print("INFO No certificate status changes since the previous report, not sending notifications")
+		return
+	}
+	notify.NotifyAll(ctx, notifiers, &notify.Notification{
+		CheckedAt:   report.CheckedAt,
+		Transitions: transitions,
+	})
+}
+
+/**
+the commandline options for the email digest.  The password is read from the SMTP_PASSWORD environment variable so
+that it doesn't show up in the process list
+*/
+type emailFlags struct {
+	Server    *string
+	Security  *string
+	Username  *string
+	From      *string
+	To        *string
+	RoutesMap *string
+}
+
+func registerEmailFlags(flags *flag.FlagSet) *emailFlags {
+	return &emailFlags{
+		Server:    flags.String("email-smtp", "", "host:port of the SMTP server to send a digest of expiring and expired certs through (no digest if empty)"),
+		Security:  flags.String("email-security", notify.SMTPSecurityStartTLS, "how to secure the SMTP connection: starttls, tls or none"),
+		Username:  flags.String("email-username", "", "username to log in to the SMTP server with, the password is read from SMTP_PASSWORD"),
+		From:      flags.String("email-from", "", "sender address for the email digest"),
+		To:        flags.String("email-to", "", "comma-separated list of addresses to send the digest for namespaces without their own recipients to"),
+		RoutesMap: flags.String("email-routes", "", "namespace/name of a config map mapping namespaces to comma-separated lists of recipients"),
+	}
+}
+
+/**
+returns the digest notifier, or nil if no SMTP server was given
+*/
+func (f *emailFlags) Notifier() (*notify.DigestNotifier, error) {
+	if *f.Server == "" {
+		return nil, nil
+	}
+	switch *f.Security {
+	case notify.SMTPSecurityStartTLS, notify.SMTPSecurityTLS, notify.SMTPSecurityNone:
+	default:
+		return nil, fmt.Errorf("-email-security must be one of starttls, tls or none, not '%s'", *f.Security)
+	}
+	if *f.From == "" {
+		return nil, fmt.Errorf("-email-from is required with -email-smtp")
+	}
+	settings := &notify.SMTPSettings{
+		Address:  *f.Server,
+		Security: *f.Security,
+		Username: *f.Username,
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     *f.From,
+	}
+	return notify.NewDigestNotifier(settings, notify.NewEmailRouter(splitList(*f.To))), nil
+}
@@ -0,0 +1,294 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	htmltemplate "html/template"
+	"log"
+	"math"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+/**
+decides who gets told about which namespace.  Namespaces with no route of their own go to Default
+*/
+type EmailRouter struct {
+	Default []string
+	routes  map[string][]string
+}
+
+func NewEmailRouter(defaultRecipients []string) *EmailRouter {
+	return &EmailRouter{
+		Default: defaultRecipients,
+		routes:  make(map[string][]string),
+	}
+}
+
+func routeKey(cluster string, namespace string) string {
+	return cluster + "/" + namespace
+}
+
+/**
+sends mail about `namespace` in `cluster` to `recipients` as well as anyone already routed
+*/
+func (r *EmailRouter) Add(cluster string, namespace string, recipients []string) {
+	key := routeKey(cluster, namespace)
+	r.routes[key] = append(r.routes[key], recipients...)
+}
+
+func (r *EmailRouter) For(cluster string, namespace string) []string {
+	if recipients, haveRoute := r.routes[routeKey(cluster, namespace)]; haveRoute {
+		return recipients
+	}
+	return r.Default
+}
+
+/**
+one certificate in a digest.  DaysLeft is rounded down, so a cert that expired a few hours ago has -1 days left
+*/
+type DigestEntry struct {
+	Source      string
+	Result      string
+	Severity    string
+	ValidUntil  time.Time
+	DaysLeft    int
+	Expired     bool
+	AutoRenewed bool
+}
+
+/**
+the certificates in one namespace that belong to one owner
+*/
+type DigestGroup struct {
+	Cluster      string
+	Namespace    string
+	Owner        string
+	Certificates []DigestEntry
+}
+
+/**
+the data that the digest templates are rendered with
+*/
+type DigestData struct {
+	CheckedAt time.Time
+	Expired   int
+	Expiring  int
+	Groups    []DigestGroup
+}
+
+/**
+true if the record belongs in a digest of expiring and expired certificates.  This goes by the date findings, so a
+cert whose result is taken over by e.g. a weak key is still included
+*/
+func inDigest(rec *datapersistence.CheckRecord) bool {
+	switch rec.ExpiryResult() {
+	case datapersistence.NearExpiry, datapersistence.Critical, datapersistence.AfterExpiry:
+		return true
+	default:
+		return false
+	}
+}
+
+/**
+DigestNotifier emails a summary of every expiring and expired certificate in a report, grouped by namespace and
+owner.  Each recipient gets a single message covering all of the namespaces that are routed to them
+*/
+type DigestNotifier struct {
+	SMTP         *SMTPSettings
+	Router       *EmailRouter
+	Subject      string
+	TextTemplate *texttemplate.Template
+	HTMLTemplate *htmltemplate.Template
+}
+
+func NewDigestNotifier(settings *SMTPSettings, router *EmailRouter) *DigestNotifier {
+	return &DigestNotifier{
+		SMTP:         settings,
+		Router:       router,
+		Subject:      "Certificate expiry digest",
+		TextTemplate: texttemplate.Must(texttemplate.New("digest.txt").Funcs(digestFuncs).Parse(defaultTextDigest)),
+		HTMLTemplate: htmltemplate.Must(htmltemplate.New("digest.html").Funcs(digestFuncs).Parse(defaultHTMLDigest)),
+	}
+}
+
+/**
+builds the digest data for each set of recipients.  The map is keyed by the comma-joined recipient list
+*/
+func (n *DigestNotifier) BuildDigests(report *datapersistence.PersistenceRecord) map[string]*DigestData {
+	referenceTime := report.CheckedAt
+	if report.AsOf != nil {
+		referenceTime = *report.AsOf
+	}
+
+	groups := make(map[string]map[string]*DigestGroup)
+	for i := range report.Results {
+		rec := &report.Results[i]
+		if !inDigest(rec) {
+			continue
+		}
+		recipients := n.Router.For(rec.Cluster, rec.Namespace)
+		if len(recipients) == 0 {
+			log.Printf("WARNING No email recipients for %s, leaving it out of the digest", rec.Source)
+			continue
+		}
+		recipientsKey := strings.Join(recipients, ",")
+		if groups[recipientsKey] == nil {
+			groups[recipientsKey] = make(map[string]*DigestGroup)
+		}
+		groupKey := rec.Owner + "\x00" + rec.Cluster + "\x00" + rec.Namespace
+		group, haveGroup := groups[recipientsKey][groupKey]
+		if !haveGroup {
+			group = &DigestGroup{Cluster: rec.Cluster, Namespace: rec.Namespace, Owner: rec.Owner}
+			groups[recipientsKey][groupKey] = group
+		}
+		group.Certificates = append(group.Certificates, DigestEntry{
+			Source:      rec.Source.String(),
+			Result:      rec.ExpiryResult().String(),
+			Severity:    rec.Severity.String(),
+			ValidUntil:  rec.ValidUntil,
+			DaysLeft:    int(math.Floor(rec.ValidUntil.Sub(referenceTime).Hours() / 24)),
+			Expired:     rec.ExpiryResult() == datapersistence.AfterExpiry,
+			AutoRenewed: datapersistence.IsAutoRenewed(rec),
+		})
+	}
+
+	digests := make(map[string]*DigestData)
+	for recipientsKey, byGroup := range groups {
+		digest := &DigestData{CheckedAt: referenceTime}
+		for _, group := range byGroup {
+			sort.SliceStable(group.Certificates, func(i, j int) bool {
+				return group.Certificates[i].ValidUntil.Before(group.Certificates[j].ValidUntil)
+			})
+			for _, entry := range group.Certificates {
+				if entry.Expired {
+					digest.Expired++
+				} else {
+					digest.Expiring++
+				}
+			}
+			digest.Groups = append(digest.Groups, *group)
+		}
+		sort.Slice(digest.Groups, func(i, j int) bool {
+			a, b := digest.Groups[i], digest.Groups[j]
+			if a.Owner != b.Owner {
+				return a.Owner < b.Owner
+			}
+			if a.Cluster != b.Cluster {
+				return a.Cluster < b.Cluster
+			}
+			return a.Namespace < b.Namespace
+		})
+		digests[recipientsKey] = digest
+	}
+	return digests
+}
+
+/**
+renders the digest as a multipart/alternative message with plain-text and HTML bodies
+*/
+func (n *DigestNotifier) BuildMessage(recipients []string, digest *DigestData) ([]byte, error) {
+	var textBody, htmlBody bytes.Buffer
+	if err := n.TextTemplate.Execute(&textBody, digest); err != nil {
+		return nil, fmt.Errorf("could not render text template: %s", err)
+	}
+	if err := n.HTMLTemplate.Execute(&htmlBody, digest); err != nil {
+		return nil, fmt.Errorf("could not render html template: %s", err)
+	}
+
+	var message bytes.Buffer
+	parts := multipart.NewWriter(&message)
+	subject := fmt.Sprintf("%s: %d expired, %d expiring", n.Subject, digest.Expired, digest.Expiring)
+	fmt.Fprintf(&message, "From: %s\r\n", n.SMTP.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", digest.CheckedAt.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"text/plain; charset=utf-8", textBody.Bytes()},
+		{"text/html; charset=utf-8", htmlBody.Bytes()},
+	} {
+		writer, partErr := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if partErr != nil {
+			return nil, partErr
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		encoder.Write(part.body)
+		encoder.Close()
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
+
+/**
+sends a digest to each set of recipients that has something to hear about.  A failure for one set of recipients
+doesn't stop the others; the number of failures is returned
+*/
+func (n *DigestNotifier) SendDigest(ctx context.Context, report *datapersistence.PersistenceRecord) int {
+	failures := 0
+	digests := n.BuildDigests(report)
+	if len(digests) == 0 {
+		log.Print("INFO No expiring or expired certificates, not sending an email digest")
+	}
+	for recipientsKey, digest := range digests {
+		recipients := strings.Split(recipientsKey, ",")
+		message, buildErr := n.BuildMessage(recipients, digest)
+		if buildErr == nil {
+			buildErr = n.SMTP.Send(ctx, recipients, message)
+		}
+		if buildErr != nil {
+			log.Printf("ERROR Could not send email digest to %s: %s", recipientsKey, buildErr)
+			failures++
+		} else {
+			log.Printf("INFO Sent email digest of %d certificates to %s", digest.Expired+digest.Expiring, recipientsKey)
+		}
+	}
+	return failures
+}
+
+var digestFuncs = map[string]interface{}{
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+}
+
+const defaultTextDigest = `Certificate expiry digest for {{date .CheckedAt}}
+
+{{.Expired}} expired and {{.Expiring}} expiring certificates.
+{{range .Groups}}
+== {{if .Cluster}}{{.Cluster}} / {{end}}{{.Namespace}}{{if .Owner}} (owner: {{.Owner}}){{end}} ==
+{{range .Certificates}}- {{.Source}}: {{.Result}}, valid until {{date .ValidUntil}} ({{.DaysLeft}} days){{if .AutoRenewed}}, renewed by cert-manager{{else}}, needs manual renewal{{end}}
+{{end}}{{end}}`
+
+const defaultHTMLDigest = `<html>
+<body>
+<h2>Certificate expiry digest for {{date .CheckedAt}}</h2>
+<p>{{.Expired}} expired and {{.Expiring}} expiring certificates.</p>
+{{range .Groups}}
+<h3>{{if .Cluster}}{{.Cluster}} / {{end}}{{.Namespace}}{{if .Owner}} (owner: {{.Owner}}){{end}}</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Certificate</th><th>Status</th><th>Valid until</th><th>Days left</th><th>Renewal</th></tr>
+{{range .Certificates}}<tr><td>{{.Source}}</td><td>{{.Result}}</td><td>{{date .ValidUntil}}</td><td>{{.DaysLeft}}</td><td>{{if .AutoRenewed}}cert-manager{{else}}manual{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`
@@ -0,0 +1,240 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+what the stub SMTP server was sent in a single session
+*/
+type receivedMail struct {
+	Auth       string
+	From       string
+	Recipients []string
+	Data       string
+}
+
+/**
+a minimal in-process SMTP server that accepts `sessions` connections and records what each one sent
+*/
+func startSMTPStub(t *testing.T, sessions int) (string, chan receivedMail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan receivedMail, sessions)
+	go func() {
+		defer listener.Close()
+		for i := 0; i < sessions; i++ {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			received <- serveSMTPSession(textproto.NewConn(conn))
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func serveSMTPSession(conn *textproto.Conn) receivedMail {
+	defer conn.Close()
+	var mail receivedMail
+	conn.PrintfLine("220 localhost ESMTP stub")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return mail
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			conn.PrintfLine("250-localhost")
+			conn.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			decoded, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			mail.Auth = string(decoded)
+			conn.PrintfLine("235 ok")
+		case "MAIL":
+			mail.From = line
+			conn.PrintfLine("250 ok")
+		case "RCPT":
+			mail.Recipients = append(mail.Recipients, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			conn.PrintfLine("250 ok")
+		case "DATA":
+			conn.PrintfLine("354 go ahead")
+			data, _ := ioutil.ReadAll(conn.DotReader())
+			mail.Data = string(data)
+			conn.PrintfLine("250 queued")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+			return mail
+		default:
+			conn.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func digestTestReport() *datapersistence.PersistenceRecord {
+	checkedAt := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	entry := func(namespace string, name string, owner string, result datapersistence.ValidationResult, validUntil time.Time) datapersistence.CheckRecord {
+		return datapersistence.CheckRecord{
+			Cluster:     "prod",
+			Namespace:   namespace,
+			Owner:       owner,
+			Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: namespace, Name: name},
+			CheckResult: result,
+			ValidUntil:  validUntil,
+		}
+	}
+	return &datapersistence.PersistenceRecord{
+		CheckedAt: checkedAt,
+		Results: []datapersistence.CheckRecord{
+			entry("web", "web-tls", "web-team", datapersistence.NearExpiry, checkedAt.AddDate(0, 0, 10)),
+			entry("web", "fine-tls", "web-team", datapersistence.WithinRange, checkedAt.AddDate(0, 3, 0)),
+			entry("payments", "card-tls", "payments-team", datapersistence.AfterExpiry, checkedAt.AddDate(0, 0, -2)),
+			entry("misc", "<odd>-tls", "", datapersistence.Critical, checkedAt.AddDate(0, 0, 2)),
+		},
+	}
+}
+
+func TestLoadEmailRoutes(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: map[string]string{EmailAnnotation: "web@example.com, oncall@example.com"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "certchecker", Name: "routes"}, Data: map[string]string{"payments": "payments@example.com"}},
+	)
+	router := NewEmailRouter([]string{"security@example.com"})
+	if err := LoadEmailRoutes(context.Background(), clientset, "prod", "certchecker/routes", router); err != nil {
+		t.Fatal(err)
+	}
+
+	if recipients := router.For("prod", "web"); strings.Join(recipients, ",") != "web@example.com,oncall@example.com" {
+		t.Errorf("unexpected recipients for web: %v", recipients)
+	}
+	if recipients := router.For("prod", "payments"); strings.Join(recipients, ",") != "payments@example.com" {
+		t.Errorf("unexpected recipients for payments: %v", recipients)
+	}
+	if recipients := router.For("prod", "misc"); strings.Join(recipients, ",") != "security@example.com" {
+		t.Errorf("expected unrouted namespaces to go to the default, got %v", recipients)
+	}
+	if recipients := router.For("staging", "web"); strings.Join(recipients, ",") != "security@example.com" {
+		t.Errorf("routes should only apply to their own cluster, got %v", recipients)
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	address, received := startSMTPStub(t, 2)
+	router := NewEmailRouter([]string{"security@example.com"})
+	router.Add("prod", "web", []string{"web@example.com"})
+	notifier := NewDigestNotifier(&SMTPSettings{
+		Address:  address,
+		Security: SMTPSecurityNone,
+		Username: "certchecker",
+		Password: "secret",
+		From:     "certchecker@example.com",
+		Timeout:  5 * time.Second,
+	}, router)
+
+	if failures := notifier.SendDigest(context.Background(), digestTestReport()); failures != 0 {
+		t.Fatalf("expected no failures, got %d", failures)
+	}
+
+	mails := make(map[string]receivedMail)
+	for i := 0; i < 2; i++ {
+		select {
+		case mail := <-received:
+			mails[strings.Join(mail.Recipients, ",")] = mail
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for mail")
+		}
+	}
+
+	web, haveWeb := mails["web@example.com"]
+	if !haveWeb {
+		t.Fatalf("expected a digest for web@example.com, got %v", mails)
+	}
+	if web.Auth != "\x00certchecker\x00secret" {
+		t.Errorf("unexpected auth '%q'", web.Auth)
+	}
+	webBody, _ := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(web.Data)))
+	if !strings.Contains(string(webBody), "web:web-tls: near expiry, valid until 2026-10-29 (10 days)") {
+		t.Errorf("expected the web digest to list web-tls, got %s", webBody)
+	}
+	if strings.Contains(string(webBody), "fine-tls") || strings.Contains(string(webBody), "card-tls") {
+		t.Errorf("web digest should only contain the web team's expiring certs")
+	}
+	if !strings.Contains(web.Data, "Content-Type: text/html") || !strings.Contains(web.Data, "Content-Type: text/plain") {
+		t.Errorf("expected both html and plain-text parts")
+	}
+
+	security, haveSecurity := mails["security@example.com"]
+	if !haveSecurity {
+		t.Fatalf("expected a digest for security@example.com, got %v", mails)
+	}
+	securityBody, _ := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(security.Data)))
+	body := string(securityBody)
+	if !strings.Contains(security.Data, "Subject: Certificate expiry digest: 1 expired, 1 expiring") {
+		t.Errorf("unexpected subject in %s", security.Data)
+	}
+	if strings.Index(body, "== prod / misc ==") > strings.Index(body, "== prod / payments (owner: payments-team) ==") {
+		t.Errorf("expected groups to be ordered by owner")
+	}
+	if !strings.Contains(body, "&lt;odd&gt;-tls") {
+		t.Errorf("expected names to be escaped in the html part")
+	}
+}
+
+func TestBuildDigestsJustExpired(t *testing.T) {
+	report := digestTestReport()
+	report.Results[2].ValidUntil = report.CheckedAt.Add(-6 * time.Hour)
+	notifier := NewDigestNotifier(&SMTPSettings{}, NewEmailRouter([]string{"security@example.com"}))
+
+	digest := notifier.BuildDigests(report)["security@example.com"]
+	if digest == nil {
+		t.Fatal("expected a digest for security@example.com")
+	}
+	if digest.Expired != 1 || digest.Expiring != 2 {
+		t.Errorf("expected a cert that expired hours ago to count as expired, got %d expired and %d expiring", digest.Expired, digest.Expiring)
+	}
+	for _, group := range digest.Groups {
+		for _, entry := range group.Certificates {
+			if entry.Source == "payments:card-tls" && entry.DaysLeft != -1 {
+				t.Errorf("expected card-tls to have -1 days left, got %d", entry.DaysLeft)
+			}
+		}
+	}
+}
+
+func TestBuildDigestsWeakAndExpiring(t *testing.T) {
+	report := digestTestReport()
+	report.Results[0].CheckResult = datapersistence.HasCriticalFindings
+	report.Results[0].Findings = []datapersistence.Finding{
+		{Code: datapersistence.NearExpiryFinding, Severity: datapersistence.SeverityWarning, Result: datapersistence.ResultOf(datapersistence.NearExpiry)},
+		{Code: "WeakRSAKey", Severity: datapersistence.SeverityCritical},
+	}
+	notifier := NewDigestNotifier(&SMTPSettings{}, NewEmailRouter([]string{"security@example.com"}))
+
+	digest := notifier.BuildDigests(report)["security@example.com"]
+	if digest == nil || digest.Expiring != 2 {
+		t.Fatalf("expected a near-expiry cert with a weak key to be in the digest, got %+v", digest)
+	}
+	for _, group := range digest.Groups {
+		for _, entry := range group.Certificates {
+			if entry.Source == "web:web-tls" && entry.Result != "near expiry" {
+				t.Errorf("expected web-tls to be listed as near expiry, got %s", entry.Result)
+			}
+		}
+	}
+}
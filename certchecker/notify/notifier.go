@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"log"
+	"time"
+)
+
+/**
+a change in the result of a certificate between the previous report and this one.  New is set when the certificate
+was not in the previous report at all, in which case Previous is meaningless
+*/
+type Transition struct {
+	Cluster  string                           `json:"cluster,omitempty"`
+	Source   datapersistence.SourceDescriptor `json:"source"`
+	New      bool                             `json:"new"`
+	Previous datapersistence.ValidationResult `json:"previous"`
+	Current  datapersistence.ValidationResult `json:"current"`
+	Record   datapersistence.CheckRecord      `json:"record"`
+}
+
+/**
+true if the certificate has gone back to being OK
+*/
+func (t *Transition) Resolved() bool {
+	return t.Current == datapersistence.WithinRange
+}
+
+func (t *Transition) String() string {
+	if t.New {
+		return fmt.Sprintf("%s is %s", t.Source, t.Current)
+	}
+	return fmt.Sprintf("%s has gone from %s to %s", t.Source, t.Previous, t.Current)
+}
+
+type Notification struct {
+	CheckedAt   time.Time    `json:"checkedAt"`
+	Transitions []Transition `json:"transitions"`
+}
+
+/**
+Notifier sends a Notification somewhere people will see it
+*/
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, notification *Notification) error
+}
+
+func recordKey(rec *datapersistence.CheckRecord) string {
+	return rec.Cluster + "/" + rec.Source.String()
+}
+
+/**
+compares the results in `current` with those in `previous` and returns a Transition for every certificate whose
+result has changed.  Certificates that are new since the previous report are only included if they have a problem,
+so the first run only tells you about things that need attention.  `previous` can be nil.
+*/
+func FindTransitions(previous *datapersistence.PersistenceRecord, current *datapersistence.PersistenceRecord) []Transition {
+	previousResults := make(map[string]datapersistence.ValidationResult)
+	if previous != nil {
+		for i := range previous.Results {
+			previousResults[recordKey(&previous.Results[i])] = previous.Results[i].CheckResult
+		}
+	}
+
+	transitions := make([]Transition, 0)
+	for i := range current.Results {
+		rec := &current.Results[i]
+		previousResult, existed := previousResults[recordKey(rec)]
+		if existed && previousResult == rec.CheckResult {
+			continue
+		}
+		if !existed && rec.CheckResult == datapersistence.WithinRange {
+			continue
+		}
+		transitions = append(transitions, Transition{
+			Cluster:  rec.Cluster,
+			Source:   rec.Source,
+			New:      !existed,
+			Previous: previousResult,
+			Current:  rec.CheckResult,
+			Record:   *rec,
+		})
+	}
+	return transitions
+}
+
+/**
+sends the notification to every notifier.  A notifier that fails doesn't stop the others; the number of failures
+is returned
+*/
+func NotifyAll(ctx context.Context, notifiers []Notifier, notification *Notification) int {
+	failures := 0
+	for _, notifier := range notifiers {
+		err := notifier.Notify(ctx, notification)
+		if err != nil {
+			log.Printf("ERROR Could not send notification via %s: %s", notifier.Name(), err)
+			failures++
+		} else {
+			log.Printf("INFO Sent notification of %d changes via %s", len(notification.Transitions), notifier.Name())
+		}
+	}
+	return failures
+}
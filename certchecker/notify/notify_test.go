@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func record(name string, result datapersistence.ValidationResult) datapersistence.CheckRecord {
+	return datapersistence.CheckRecord{
+		Cluster:     "prod",
+		Namespace:   "web",
+		Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: name},
+		CheckResult: result,
+		ValidUntil:  time.Date(2026, 11, 3, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestFindTransitions(t *testing.T) {
+	previous := &datapersistence.PersistenceRecord{Results: []datapersistence.CheckRecord{
+		record("unchanged", datapersistence.NearExpiry),
+		record("getting-old", datapersistence.WithinRange),
+		record("renewed", datapersistence.NearExpiry),
+	}}
+	current := &datapersistence.PersistenceRecord{Results: []datapersistence.CheckRecord{
+		record("unchanged", datapersistence.NearExpiry),
+		record("getting-old", datapersistence.NearExpiry),
+		record("renewed", datapersistence.WithinRange),
+		record("new-and-fine", datapersistence.WithinRange),
+		record("new-and-expired", datapersistence.AfterExpiry),
+	}}
+
+	transitions := FindTransitions(previous, current)
+	if len(transitions) != 3 {
+		t.Fatalf("expected 3 transitions, got %d: %v", len(transitions), transitions)
+	}
+	if transitions[0].Source.Name != "getting-old" || transitions[0].Previous != datapersistence.WithinRange || transitions[0].Current != datapersistence.NearExpiry {
+		t.Errorf("unexpected first transition %s", transitions[0].String())
+	}
+	if transitions[1].Source.Name != "renewed" || !transitions[1].Resolved() {
+		t.Errorf("expected the renewed cert to be resolved, got %s", transitions[1].String())
+	}
+	if transitions[2].Source.Name != "new-and-expired" || !transitions[2].New {
+		t.Errorf("expected the new expired cert to be reported as new, got %s", transitions[2].String())
+	}
+
+	if len(FindTransitions(nil, current)) != 3 {
+		t.Errorf("with no previous report every problem should be reported")
+	}
+}
+
+func testNotification() *Notification {
+	return &Notification{
+		CheckedAt: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+		Transitions: []Transition{
+			{Cluster: "prod", Source: datapersistence.SourceDescriptor{Namespace: "web", Name: "web-tls"}, Previous: datapersistence.WithinRange, Current: datapersistence.NearExpiry},
+		},
+	}
+}
+
+/**
+starts a server that records the json body of each request it receives
+*/
+func recordingServer(t *testing.T, status int, bodies *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a json POST, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		content, _ := ioutil.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(content, &body); err != nil {
+			t.Errorf("request body was not json: %s", err)
+		}
+		*bodies = append(*bodies, body)
+		w.WriteHeader(status)
+	}))
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	bodies := make([]map[string]interface{}, 0)
+	server := recordingServer(t, 200, &bodies)
+	defer server.Close()
+
+	if err := NewWebhookNotifier(server.URL).Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	transitions, _ := bodies[0]["transitions"].([]interface{})
+	if len(transitions) != 1 {
+		t.Errorf("expected the notification itself as the body, got %v", bodies[0])
+	}
+}
+
+func TestSlackAndTeamsFormatters(t *testing.T) {
+	bodies := make([]map[string]interface{}, 0)
+	server := recordingServer(t, 200, &bodies)
+	defer server.Close()
+
+	notifiers := []Notifier{NewSlackNotifier(server.URL), NewTeamsNotifier(server.URL)}
+	if failures := NotifyAll(context.Background(), notifiers, testNotification()); failures != 0 {
+		t.Fatalf("expected no failures, got %d", failures)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(bodies))
+	}
+
+	slackText, _ := bodies[0]["text"].(string)
+	if !strings.Contains(slackText, "1 new problems") {
+		t.Errorf("unexpected Slack text '%s'", slackText)
+	}
+	blocks, _ := json.Marshal(bodies[0]["blocks"])
+	if !strings.Contains(string(blocks), "web:web-tls has gone from within range to near expiry") {
+		t.Errorf("expected the Slack blocks to describe the change, got %s", blocks)
+	}
+
+	if bodies[1]["@type"] != "MessageCard" || bodies[1]["themeColor"] != "D00000" {
+		t.Errorf("expected a red Teams MessageCard, got %v", bodies[1])
+	}
+}
+
+func TestSectionTexts(t *testing.T) {
+	line := "• " + strings.Repeat("x", 98)
+	lines := make([]string, 0)
+	for i := 0; i < maxChatTransitions; i++ {
+		lines = append(lines, line)
+	}
+
+	texts := sectionTexts(lines, 1000)
+	if len(texts) != 5 {
+		t.Errorf("expected 40 lines of 100 bytes to need 5 sections, got %d", len(texts))
+	}
+	joined := 0
+	for _, text := range texts {
+		if len(text) > 1000 {
+			t.Errorf("expected no section over the limit, got %d bytes", len(text))
+		}
+		joined += strings.Count(text, "\n") + 1
+	}
+	if joined != maxChatTransitions {
+		t.Errorf("expected every line to be kept, got %d", joined)
+	}
+
+	long := sectionTexts([]string{strings.Repeat("é", 600)}, 1000)
+	if len(long) != 1 || len(long[0]) > 1000 || !utf8.ValidString(long[0]) || !strings.HasSuffix(long[0], "...") {
+		t.Errorf("expected an over-long line to be cut short at a character boundary, got %d bytes", len(long[0]))
+	}
+}
+
+func TestWebhookNotifierFailure(t *testing.T) {
+	bodies := make([]map[string]interface{}, 0)
+	server := recordingServer(t, 500, &bodies)
+	defer server.Close()
+
+	err := NewSlackNotifier(server.URL).Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected an error for a 500 response, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+)
+
+/**
+a comma-separated list of addresses that should get the email digest for the namespace
+*/
+const EmailAnnotation = "certchecker.guardian.co.uk/notify-email"
+
+func splitAddresses(value string) []string {
+	addresses := make([]string, 0)
+	for _, address := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(address); trimmed != "" {
+			addresses = append(addresses, trimmed)
+		}
+	}
+	return addresses
+}
+
+/**
+adds the email routes for a cluster to the router.  Routes come from the EmailAnnotation on each namespace and,
+if configMap is given as `namespace/name`, from a ConfigMap whose keys are namespace names and whose values are
+comma-separated lists of addresses.  A namespace can be routed by both.
+*/
+func LoadEmailRoutes(ctx context.Context, clientset kubernetes.Interface, cluster string, configMap string, router *EmailRouter) error {
+	namespaces, listErr := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if listErr != nil {
+		return listErr
+	}
+	for _, namespace := range namespaces.Items {
+		if value, haveValue := namespace.Annotations[EmailAnnotation]; haveValue {
+			router.Add(cluster, namespace.Name, splitAddresses(value))
+		}
+	}
+
+	if configMap == "" {
+		return nil
+	}
+	parts := strings.SplitN(configMap, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("email routes config map '%s' should be given as namespace/name", configMap)
+	}
+	routes, getErr := clientset.CoreV1().ConfigMaps(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+	if getErr != nil {
+		return getErr
+	}
+	for namespace, value := range routes.Data {
+		router.Add(cluster, namespace, splitAddresses(value))
+	}
+	return nil
+}
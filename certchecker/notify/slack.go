@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+/**
+the maximum number of changes listed in a chat message, so that a bad day doesn't produce an unreadable wall of text
+*/
+const maxChatTransitions = 40
+
+/**
+the most text Slack accepts in a single section block
+*/
+const maxSlackSectionLength = 3000
+
+/**
+a notifier for a Slack incoming webhook
+*/
+func NewSlackNotifier(url string) *WebhookNotifier {
+	notifier := NewWebhookNotifier(url)
+	notifier.Kind = "slack"
+	notifier.Formatter = SlackFormatter
+	return notifier
+}
+
+func summary(notification *Notification) string {
+	problems := 0
+	for i := range notification.Transitions {
+		if !notification.Transitions[i].Resolved() {
+			problems++
+		}
+	}
+	return fmt.Sprintf("Certificate status changes: %d new problems, %d resolved", problems, len(notification.Transitions)-problems)
+}
+
+func transitionLine(t *Transition) string {
+	line := t.String()
+	if t.Cluster != "" {
+		line = "[" + t.Cluster + "] " + line
+	}
+	if !t.Record.ValidUntil.IsZero() {
+		line += ", valid until " + t.Record.ValidUntil.Format("2006-01-02")
+	}
+	return line
+}
+
+func transitionLines(notification *Notification) []string {
+	lines := make([]string, 0, len(notification.Transitions))
+	for i := range notification.Transitions {
+		if i == maxChatTransitions {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(notification.Transitions)-maxChatTransitions))
+			break
+		}
+		lines = append(lines, transitionLine(&notification.Transitions[i]))
+	}
+	return lines
+}
+
+/**
+joins the lines into as few texts as possible that are each no longer than `limit` bytes, so that they fit in a
+section block.  A line that is too long on its own is cut short
+*/
+func sectionTexts(lines []string, limit int) []string {
+	texts := make([]string, 0)
+	current := ""
+	for _, line := range lines {
+		if len(line) > limit {
+			cut := limit - 3
+			for !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			line = line[:cut] + "..."
+		}
+		if current != "" && len(current)+1+len(line) > limit {
+			texts = append(texts, current)
+			current = ""
+		}
+		if current != "" {
+			current += "\n"
+		}
+		current += line
+	}
+	if current != "" {
+		texts = append(texts, current)
+	}
+	return texts
+}
+
+/**
+formats the notification as a Slack message, with a plain `text` fallback and section blocks for the list of changes
+*/
+func SlackFormatter(notification *Notification) (interface{}, error) {
+	title := summary(notification)
+	lines := transitionLines(notification)
+	for i := range lines {
+		lines[i] = "• " + lines[i]
+	}
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]string{"type": "plain_text", "text": title},
+		},
+	}
+	for _, text := range sectionTexts(lines, maxSlackSectionLength) {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": text},
+		})
+	}
+	return map[string]interface{}{
+		"text":   title,
+		"blocks": blocks,
+	}, nil
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+const (
+	SMTPSecurityStartTLS = "starttls"
+	SMTPSecurityTLS      = "tls"
+	SMTPSecurityNone     = "none"
+)
+
+/**
+how to connect to the mail server.  Security is one of:
+- starttls: connect in plain text and then upgrade with STARTTLS, failing if the server doesn't offer it (the default)
+- tls: connect over TLS from the start, normally on port 465
+- none: never use TLS.  Only for talking to a relay on localhost
+Username and Password are optional; if a Username is given the server must offer AUTH PLAIN
+*/
+type SMTPSettings struct {
+	Address   string
+	Security  string
+	Username  string
+	Password  string
+	From      string
+	Timeout   time.Duration
+	TLSConfig *tls.Config
+}
+
+func (s *SMTPSettings) tlsConfig(host string) *tls.Config {
+	if s.TLSConfig != nil {
+		return s.TLSConfig.Clone()
+	}
+	return &tls.Config{ServerName: host}
+}
+
+/**
+connects to the server and sends a single message to the given recipients
+*/
+func (s *SMTPSettings) Send(ctx context.Context, recipients []string, message []byte) error {
+	if len(recipients) == 0 {
+		return errors.New("no recipients")
+	}
+	host, _, splitErr := net.SplitHostPort(s.Address)
+	if splitErr != nil {
+		return fmt.Errorf("invalid smtp address '%s': %s", s.Address, splitErr)
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var dialErr error
+	if s.Security == SMTPSecurityTLS {
+		conn, dialErr = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig(host)}).DialContext(ctx, "tcp", s.Address)
+	} else {
+		conn, dialErr = dialer.DialContext(ctx, "tcp", s.Address)
+	}
+	if dialErr != nil {
+		return dialErr
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, clientErr := smtp.NewClient(conn, host)
+	if clientErr != nil {
+		conn.Close()
+		return clientErr
+	}
+	defer client.Close()
+
+	if s.Security == "" || s.Security == SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		if err := client.StartTLS(s.tlsConfig(host)); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(s.From); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient %s was refused: %s", recipient, err)
+		}
+	}
+	writer, dataErr := client.Data()
+	if dataErr != nil {
+		return dataErr
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,42 @@
+package notify
+
+/**
+a notifier for a Microsoft Teams incoming webhook
+*/
+func NewTeamsNotifier(url string) *WebhookNotifier {
+	notifier := NewWebhookNotifier(url)
+	notifier.Kind = "teams"
+	notifier.Formatter = TeamsFormatter
+	return notifier
+}
+
+/**
+formats the notification as a Teams MessageCard, which is red if there are any new problems and green if everything
+has been resolved
+*/
+func TeamsFormatter(notification *Notification) (interface{}, error) {
+	title := summary(notification)
+	colour := "2EB886"
+	facts := make([]map[string]string, 0, len(notification.Transitions))
+	for i, line := range transitionLines(notification) {
+		name := "more"
+		if i < len(notification.Transitions) && i < maxChatTransitions {
+			transition := &notification.Transitions[i]
+			name = transition.Current.String()
+			if !transition.Resolved() {
+				colour = "D00000"
+			}
+		}
+		facts = append(facts, map[string]string{"name": name, "value": line})
+	}
+	return map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "http://schema.org/extensions",
+		"summary":    title,
+		"title":      title,
+		"themeColor": colour,
+		"sections": []interface{}{
+			map[string]interface{}{"facts": facts},
+		},
+	}, nil
+}
@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+/**
+turns a notification into the body of a webhook request
+*/
+type Formatter func(notification *Notification) (interface{}, error)
+
+/**
+WebhookNotifier POSTs each notification as json to a URL.  The Formatter decides what the json looks like, which is
+how the same notifier serves generic webhooks, Slack and Teams
+*/
+type WebhookNotifier struct {
+	Kind      string
+	URL       string
+	Client    *http.Client
+	Formatter Formatter
+}
+
+/**
+a generic webhook, which receives the Notification itself as json
+*/
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		Kind:      "webhook",
+		URL:       url,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		Formatter: JSONFormatter,
+	}
+}
+
+func JSONFormatter(notification *Notification) (interface{}, error) {
+	return notification, nil
+}
+
+func (w *WebhookNotifier) Name() string {
+	return w.Kind
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, notification *Notification) error {
+	payload, formatErr := w.Formatter(notification)
+	if formatErr != nil {
+		return formatErr
+	}
+	body, marshalErr := json.Marshal(payload)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	request, requestErr := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if requestErr != nil {
+		return requestErr
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, postErr := w.Client.Do(request)
+	if postErr != nil {
+		return postErr
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s returned status %d", w.Kind, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+/**
+Prober dials live TLS endpoints and compares what they present with the certificate stored in the cluster
+*/
+type Prober struct {
+	Clientset kubernetes.Interface
+	Timeout   time.Duration
+	// AddressFor maps a target onto the address that is actually dialled. By default this is just ServerName:Port,
+	// tests (or out-of-cluster runs) can override it.
+	AddressFor func(target *Target) string
+}
+
+func NewProber(clientset kubernetes.Interface, timeout time.Duration) *Prober {
+	return &Prober{
+		Clientset: clientset,
+		Timeout:   timeout,
+	}
+}
+
+func (p *Prober) addressFor(target *Target) string {
+	if p.AddressFor != nil {
+		return p.AddressFor(target)
+	}
+	return net.JoinHostPort(target.ServerName, strconv.Itoa(int(target.Port)))
+}
+
+/**
+connects to `address`, sending `serverName` as SNI, and returns the certificate chain that the server presented.
+We are interested in what is being served rather than whether it is trusted, so no verification is done here.
+*/
+func FetchPresentedChain(ctx context.Context, address string, serverName string, timeout time.Duration) ([]*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		Config: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, dialErr := dialer.DialContext(ctx, "tcp", address)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, errors.New("server presented no certificates")
+	}
+	return state.PeerCertificates, nil
+}
+
+func (p *Prober) loadStoredCert(ctx context.Context, secret *datapersistence.SourceDescriptor) (*x509.Certificate, error) {
+	content, getErr := p.Clientset.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+	if getErr != nil {
+		return nil, getErr
+	}
+	certData, haveCertData := content.Data[v1.TLSCertKey]
+	if !haveCertData {
+		return nil, fmt.Errorf("secret %s has no %s", secret, v1.TLSCertKey)
+	}
+	cert, _, loadErr := certs.LoadCert(certData, secret.String())
+	return cert, loadErr
+}
+
+/**
+probes a single target and returns a record of the outcome.  Failures are recorded on the returned record rather
+than being returned as an error, so that they end up in the report.
+*/
+func (p *Prober) Probe(ctx context.Context, target *Target) datapersistence.ProbeRecord {
+	rec := datapersistence.ProbeRecord{
+		Source:     target.Source,
+		Endpoint:   p.addressFor(target),
+		ServerName: target.ServerName,
+		Secret:     target.Secret,
+		CheckedAt:  time.Now(),
+	}
+
+	chain, fetchErr := FetchPresentedChain(ctx, rec.Endpoint, target.ServerName, p.Timeout)
+	if fetchErr != nil {
+		log.Printf("ERROR Probe could not fetch certificate from %s (%s): %s", rec.Endpoint, target.Source, fetchErr)
+		rec.CheckResult = datapersistence.Errored
+		rec.Error = fetchErr.Error()
+		return rec
+	}
+	presented := chain[0]
+	rec.PresentedFingerprint = certs.Fingerprint(presented)
+	rec.PresentedValidUntil = presented.NotAfter
+
+	if target.Secret == nil {
+		log.Printf("INFO Probe %s has no associated secret, not comparing", target.Source)
+		rec.CheckResult = datapersistence.NotCompared
+		return rec
+	}
+
+	stored, loadErr := p.loadStoredCert(ctx, target.Secret)
+	if loadErr != nil {
+		log.Printf("ERROR Probe could not load stored certificate from %s: %s", target.Secret, loadErr)
+		rec.CheckResult = datapersistence.Errored
+		rec.Error = loadErr.Error()
+		return rec
+	}
+	rec.StoredFingerprint = certs.Fingerprint(stored)
+	rec.StoredValidUntil = stored.NotAfter
+
+	if rec.StoredFingerprint == rec.PresentedFingerprint {
+		rec.CheckResult = datapersistence.WithinRange
+	} else {
+		rec.CheckResult = datapersistence.StaleCertInUse
+	}
+	return rec
+}
+
+/**
+finds every Service and Ingress target in the cluster and probes each one in turn
+*/
+func (p *Prober) ProbeAll(ctx context.Context) []datapersistence.ProbeRecord {
+	targets := make([]Target, 0)
+
+	serviceTargets, svcErr := FindServiceTargets(ctx, p.Clientset)
+	if svcErr != nil {
+		log.Printf("ERROR ProbeAll could not list services: %s", svcErr)
+	} else {
+		targets = append(targets, *serviceTargets...)
+	}
+
+	ingressTargets, ingErr := FindIngressTargets(ctx, p.Clientset)
+	if ingErr != nil {
+		log.Printf("ERROR ProbeAll could not list ingresses: %s", ingErr)
+	} else {
+		targets = append(targets, *ingressTargets...)
+	}
+
+	log.Printf("INFO ProbeAll found %d endpoints to probe", len(targets))
+	results := make([]datapersistence.ProbeRecord, len(targets))
+	for i := range targets {
+		results[i] = p.Probe(ctx, &targets[i])
+	}
+	return results
+}
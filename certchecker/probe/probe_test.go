@@ -0,0 +1,168 @@
+package probe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func makeOtherCertPEM(t *testing.T) []byte {
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "other.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, certErr := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if certErr != nil {
+		t.Fatal(certErr)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func makeIngressClientset(certPEM []byte) *fake.Clientset {
+	return fake.NewSimpleClientset(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "web-tls"},
+			Type:       v1.SecretTypeTLS,
+			Data:       map[string][]byte{v1.TLSCertKey: certPEM},
+		},
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "web"},
+			Spec: networkingv1.IngressSpec{
+				TLS: []networkingv1.IngressTLS{
+					{Hosts: []string{"web.example.com", "*.example.com"}, SecretName: "web-tls"},
+				},
+			},
+		},
+	)
+}
+
+func probeAgainst(t *testing.T, server *httptest.Server, certPEM []byte) []datapersistence.ProbeRecord {
+	prober := NewProber(makeIngressClientset(certPEM), time.Second)
+	prober.AddressFor = func(target *Target) string {
+		return server.Listener.Addr().String()
+	}
+	return prober.ProbeAll(context.Background())
+}
+
+func TestProbeMatchingCert(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	servedPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	results := probeAgainst(t, server, servedPEM)
+
+	if len(results) != 1 {
+		t.Fatalf("expected 1 probe result (wildcard host skipped), got %d", len(results))
+	}
+	if results[0].CheckResult != datapersistence.WithinRange {
+		t.Errorf("expected WithinRange for matching cert, got %d (%s)", results[0].CheckResult, results[0].Error)
+	}
+	if results[0].PresentedFingerprint != results[0].StoredFingerprint {
+		t.Errorf("fingerprints should match, got %s and %s", results[0].PresentedFingerprint, results[0].StoredFingerprint)
+	}
+}
+
+func TestProbeStaleCert(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	results := probeAgainst(t, server, makeOtherCertPEM(t))
+
+	if len(results) != 1 {
+		t.Fatalf("expected 1 probe result, got %d", len(results))
+	}
+	if results[0].CheckResult != datapersistence.StaleCertInUse {
+		t.Errorf("expected StaleCertInUse for mismatched cert, got %d (%s)", results[0].CheckResult, results[0].Error)
+	}
+}
+
+func TestProbeUnreachable(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	server.Close()
+
+	results := probeAgainst(t, server, makeOtherCertPEM(t))
+
+	if len(results) != 1 {
+		t.Fatalf("expected 1 probe result, got %d", len(results))
+	}
+	if results[0].CheckResult != datapersistence.Errored || results[0].Error == "" {
+		t.Errorf("expected Errored with a message for a closed server, got %d (%s)", results[0].CheckResult, results[0].Error)
+	}
+}
+
+func TestProbeWithoutSecret(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	prober := NewProber(fake.NewSimpleClientset(), time.Second)
+	prober.AddressFor = func(target *Target) string {
+		return server.Listener.Addr().String()
+	}
+	result := prober.Probe(context.Background(), &Target{
+		Source:     datapersistence.SourceDescriptor{Namespace: "test", Name: "api"},
+		ServerName: "api.test.svc",
+		Port:       443,
+	})
+
+	if result.CheckResult != datapersistence.NotCompared {
+		t.Errorf("expected NotCompared when there's no secret to compare with, got %s (%s)", result.CheckResult, result.Error)
+	}
+	if result.PresentedFingerprint == "" {
+		t.Error("expected the presented cert to be recorded even though it wasn't compared")
+	}
+}
+
+func TestFindServiceTargets(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "test",
+				Name:        "api",
+				Annotations: map[string]string{ProbePortAnnotation: "8443", TLSSecretAnnotation: "api-tls"},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "https", Port: 443},
+					{Name: "admin", Port: 8443},
+					{Name: "http", Port: 80},
+				},
+			},
+		},
+	)
+
+	targets, err := FindServiceTargets(context.Background(), clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(*targets))
+	}
+	for _, target := range *targets {
+		if target.ServerName != "api.test.svc" {
+			t.Errorf("unexpected server name %s", target.ServerName)
+		}
+		if target.Secret == nil || target.Secret.Name != "api-tls" {
+			t.Errorf("expected secret api-tls on target for port %d", target.Port)
+		}
+	}
+}
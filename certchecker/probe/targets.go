@@ -0,0 +1,150 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"strconv"
+	"strings"
+)
+
+// ProbePortAnnotation names (or numbers) a Service port that should be probed, in addition to any called `https`
+const ProbePortAnnotation = "certchecker.guardian.co.uk/probe-port"
+
+// TLSSecretAnnotation names the secret, in the Service's namespace, that holds the cert the Service should be serving
+const TLSSecretAnnotation = "certchecker.guardian.co.uk/tls-secret"
+
+/**
+a single TLS endpoint to dial
+*/
+type Target struct {
+	Source     datapersistence.SourceDescriptor
+	ServerName string
+	Port       int32
+	// Secret is the secret that should hold the certificate being served, or nil if we don't know
+	Secret *datapersistence.SourceDescriptor
+}
+
+func secretDescriptor(namespace string, name string) *datapersistence.SourceDescriptor {
+	if name == "" {
+		return nil
+	}
+	return &datapersistence.SourceDescriptor{
+		Kind:      datapersistence.SourceSecret,
+		Namespace: namespace,
+		Name:      name,
+		DataKey:   v1.TLSCertKey,
+	}
+}
+
+func wantProbe(port *v1.ServicePort, annotation string) bool {
+	if port.Protocol != "" && port.Protocol != v1.ProtocolTCP {
+		return false
+	}
+	if port.Name == "https" {
+		return true
+	}
+	if annotation == "" {
+		return false
+	}
+	for _, wanted := range strings.Split(annotation, ",") {
+		wanted = strings.TrimSpace(wanted)
+		if wanted == port.Name || wanted == strconv.Itoa(int(port.Port)) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+lists the Services across all namespaces and returns a Target for each port that is named `https` or is listed in the
+ProbePortAnnotation
+*/
+func FindServiceTargets(ctx context.Context, clientset kubernetes.Interface) (*[]Target, error) {
+	client := clientset.CoreV1().Services(metav1.NamespaceAll)
+
+	results := make([]Target, 0)
+	var continuation string
+	for {
+		result, err := client.List(ctx, metav1.ListOptions{Continue: continuation})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, svc := range result.Items {
+			annotation := svc.Annotations[ProbePortAnnotation]
+			for i := range svc.Spec.Ports {
+				port := &svc.Spec.Ports[i]
+				if !wantProbe(port, annotation) {
+					continue
+				}
+				results = append(results, Target{
+					Source: datapersistence.SourceDescriptor{
+						Kind:      datapersistence.SourceService,
+						Namespace: svc.Namespace,
+						Name:      svc.Name,
+						DataKey:   strconv.Itoa(int(port.Port)),
+					},
+					ServerName: fmt.Sprintf("%s.%s.svc", svc.Name, svc.Namespace),
+					Port:       port.Port,
+					Secret:     secretDescriptor(svc.Namespace, svc.Annotations[TLSSecretAnnotation]),
+				})
+			}
+		}
+
+		if result.Continue == "" {
+			break
+		} else {
+			continuation = result.Continue
+		}
+	}
+	return &results, nil
+}
+
+/**
+lists the Ingresses across all namespaces and returns a Target on port 443 for every host in their `tls` section.
+Wildcard hosts are skipped as there is nothing concrete to dial.
+*/
+func FindIngressTargets(ctx context.Context, clientset kubernetes.Interface) (*[]Target, error) {
+	client := clientset.NetworkingV1().Ingresses(metav1.NamespaceAll)
+
+	results := make([]Target, 0)
+	var continuation string
+	for {
+		result, err := client.List(ctx, metav1.ListOptions{Continue: continuation})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ing := range result.Items {
+			for _, tlsEntry := range ing.Spec.TLS {
+				for _, host := range tlsEntry.Hosts {
+					if host == "" || strings.HasPrefix(host, "*") {
+						continue
+					}
+					results = append(results, Target{
+						Source: datapersistence.SourceDescriptor{
+							Kind:      datapersistence.SourceIngress,
+							Namespace: ing.Namespace,
+							Name:      ing.Name,
+							DataKey:   host,
+						},
+						ServerName: host,
+						Port:       443,
+						Secret:     secretDescriptor(ing.Namespace, tlsEntry.SecretName),
+					})
+				}
+			}
+		}
+
+		if result.Continue == "" {
+			break
+		} else {
+			continuation = result.Continue
+		}
+	}
+	return &results, nil
+}
@@ -0,0 +1,64 @@
+package reissue
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+)
+
+/**
+a certificate authority that new certificates can be signed with
+*/
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+	// PEM is the CA certificate as it was stored, for adding to chains
+	PEM []byte
+}
+
+/**
+builds a CA from PEM-encoded certificate and key data
+*/
+func NewCA(certPEM []byte, keyPEM []byte) (*CA, error) {
+	cert, isCA, loadErr := certs.LoadCert(certPEM, "reissue CA")
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	if !isCA {
+		return nil, errors.New("the certificate is not a CA")
+	}
+	key, keyErr := certs.LoadPrivateKey(keyPEM)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	if !certs.KeyMatchesCert(cert, key) {
+		return nil, errors.New("the private key does not match the CA certificate")
+	}
+	return &CA{Cert: cert, Key: key, PEM: certPEM}, nil
+}
+
+/**
+loads the CA from the `tls.crt` and `tls.key` of the secret given as `namespace/name`
+*/
+func LoadCA(ctx context.Context, clientset kubernetes.Interface, secretRef string) (*CA, error) {
+	parts := strings.SplitN(secretRef, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("CA secret '%s' should be given as namespace/name", secretRef)
+	}
+	secret, getErr := clientset.CoreV1().Secrets(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+	if getErr != nil {
+		return nil, getErr
+	}
+	ca, caErr := NewCA(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+	if caErr != nil {
+		return nil, fmt.Errorf("could not load CA from %s: %s", secretRef, caErr)
+	}
+	return ca, nil
+}
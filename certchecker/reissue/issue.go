@@ -0,0 +1,123 @@
+package reissue
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+/**
+generates a new private key of the same type and size as the given public key
+*/
+func NewKeyLike(publicKey crypto.PublicKey) (crypto.Signer, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.GenerateKey(rand.Reader, key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return ecdsa.GenerateKey(key.Curve, rand.Reader)
+	case ed25519.PublicKey:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", publicKey)
+	}
+}
+
+/**
+makes the template for a replacement of `original`, keeping its subject, SANs and usages.  The new cert is valid
+from `now` for `validity`, or for as long as the original was if `validity` is zero
+*/
+func replacementTemplate(original *x509.Certificate, now time.Time, validity time.Duration) (*x509.Certificate, error) {
+	serial, serialErr := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if serialErr != nil {
+		return nil, serialErr
+	}
+	if validity == 0 {
+		validity = original.NotAfter.Sub(original.NotBefore)
+	}
+	//allow for clocks that are a little behind
+	notBefore := now.Add(-5 * time.Minute)
+
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               original.Subject,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		KeyUsage:              original.KeyUsage,
+		ExtKeyUsage:           original.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		DNSNames:              original.DNSNames,
+		EmailAddresses:        original.EmailAddresses,
+		IPAddresses:           original.IPAddresses,
+		URIs:                  original.URIs,
+	}, nil
+}
+
+/**
+issues a replacement for `original` signed by the CA, with a new key of the same type.  Returns the PEM-encoded
+certificate and PKCS#8 key
+*/
+func (ca *CA) Reissue(original *x509.Certificate, now time.Time, validity time.Duration) (*x509.Certificate, []byte, []byte, error) {
+	key, keyErr := NewKeyLike(original.PublicKey)
+	if keyErr != nil {
+		return nil, nil, nil, keyErr
+	}
+	template, templateErr := replacementTemplate(original, now, validity)
+	if templateErr != nil {
+		return nil, nil, nil, templateErr
+	}
+	if template.NotAfter.After(ca.Cert.NotAfter) {
+		return nil, nil, nil, fmt.Errorf("the CA expires at %s, before the new certificate would", ca.Cert.NotAfter.Format(time.RFC3339))
+	}
+
+	der, createErr := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.Key)
+	if createErr != nil {
+		return nil, nil, nil, createErr
+	}
+	cert, parseErr := x509.ParseCertificate(der)
+	if parseErr != nil {
+		return nil, nil, nil, parseErr
+	}
+	keyDER, marshalErr := x509.MarshalPKCS8PrivateKey(key)
+	if marshalErr != nil {
+		return nil, nil, nil, marshalErr
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return cert, certPEM, keyPEM, nil
+}
+
+/**
+true if the PEM data holds more than one certificate, i.e. it is a chain rather than just the leaf
+*/
+func isChain(certPEM []byte) bool {
+	count := 0
+	rest := certPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return count > 1
+		}
+		if block.Type == "CERTIFICATE" {
+			count++
+		}
+	}
+}
+
+func appendChain(leafPEM []byte, caPEM []byte) []byte {
+	chain := bytes.NewBuffer(append([]byte{}, leafPEM...))
+	chain.Write(bytes.TrimSpace(caPEM))
+	chain.WriteString("\n")
+	return chain.Bytes()
+}
@@ -0,0 +1,203 @@
+package reissue
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+func encodeKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func makeTestCA(t *testing.T) *CA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Internal CA"},
+		NotBefore:             testNow.AddDate(-1, 0, 0),
+		NotAfter:              testNow.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, caErr := NewCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), encodeKey(t, key))
+	if caErr != nil {
+		t.Fatal(caErr)
+	}
+	return ca
+}
+
+/**
+a secret holding a P-384 cert, self-signed, that expires in a few days
+*/
+func makeExpiringSecret(t *testing.T, annotations map[string]string) *v1.Secret {
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "internal.example.com", Organization: []string{"Example"}},
+		NotBefore:    testNow.AddDate(0, 0, -85),
+		NotAfter:     testNow.AddDate(0, 0, 5),
+		DNSNames:     []string{"internal.example.com", "alt.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "internal", Name: "internal-tls", Annotations: annotations},
+		Type:       v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			v1.TLSPrivateKeyKey: encodeKey(t, key),
+		},
+	}
+}
+
+func nearExpiryResult() []datapersistence.CheckRecord {
+	return []datapersistence.CheckRecord{{
+		Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "internal", Name: "internal-tls", DataKey: v1.TLSCertKey},
+		CheckResult: datapersistence.NearExpiry,
+	}}
+}
+
+func TestReissue(t *testing.T) {
+	ca := makeTestCA(t)
+	original := makeExpiringSecret(t, map[string]string{ReissueAnnotation: "true"})
+	clientset := fake.NewSimpleClientset(original)
+	reissuer := NewReissuer(clientset, ca, false, 0)
+	reissuer.Now = func() time.Time { return testNow }
+
+	remediations := reissuer.ReissueAll(context.Background(), nearExpiryResult())
+	if len(remediations) != 1 || remediations[0].Error != "" {
+		t.Fatalf("expected a successful re-issue, got %+v", remediations)
+	}
+
+	updated, _ := clientset.CoreV1().Secrets("internal").Get(context.Background(), "internal-tls", metav1.GetOptions{})
+	newCert, _, loadErr := certs.LoadCert(updated.Data[v1.TLSCertKey], "test")
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	oldCert, _, _ := certs.LoadCert(original.Data[v1.TLSCertKey], "test")
+
+	if newCert.Subject.String() != oldCert.Subject.String() {
+		t.Errorf("subject changed from %s to %s", oldCert.Subject, newCert.Subject)
+	}
+	if len(newCert.DNSNames) != 2 || newCert.DNSNames[1] != "alt.example.com" || len(newCert.IPAddresses) != 1 {
+		t.Errorf("SANs were not preserved: %v %v", newCert.DNSNames, newCert.IPAddresses)
+	}
+	if newKey, isEC := newCert.PublicKey.(*ecdsa.PublicKey); !isEC || newKey.Curve != elliptic.P384() {
+		t.Errorf("expected a new P-384 key, got %T", newCert.PublicKey)
+	}
+	if err := newCert.CheckSignatureFrom(ca.Cert); err != nil {
+		t.Errorf("new cert is not signed by the CA: %s", err)
+	}
+	if findings := certs.CheckPrivateKey(newCert, updated.Data[v1.TLSPrivateKeyKey]); len(findings) != 0 {
+		t.Errorf("new key doesn't match the new cert: %v", findings)
+	}
+	if !newCert.NotAfter.Equal(testNow.Add(-5*time.Minute).AddDate(0, 0, 90)) {
+		t.Errorf("expected the same 90 day lifetime as the original, got until %s", newCert.NotAfter)
+	}
+
+	backupName := updated.Annotations[BackupAnnotation]
+	backup, backupErr := clientset.CoreV1().Secrets("internal").Get(context.Background(), backupName, metav1.GetOptions{})
+	if backupErr != nil {
+		t.Fatalf("expected a backup called '%s': %s", backupName, backupErr)
+	}
+	if string(backup.Data[v1.TLSCertKey]) != string(original.Data[v1.TLSCertKey]) || backup.Type != BackupSecretType || backup.Labels[BackupOfLabel] != "internal-tls" {
+		t.Errorf("backup does not hold the original data")
+	}
+}
+
+func TestReissueDryRunAndOptIn(t *testing.T) {
+	ca := makeTestCA(t)
+
+	notOptedIn := makeExpiringSecret(t, nil)
+	clientset := fake.NewSimpleClientset(notOptedIn)
+	if remediations := NewReissuer(clientset, ca, false, 0).ReissueAll(context.Background(), nearExpiryResult()); len(remediations) != 0 {
+		t.Errorf("secrets without the annotation should be left alone, got %+v", remediations)
+	}
+
+	original := makeExpiringSecret(t, map[string]string{ReissueAnnotation: "true"})
+	clientset = fake.NewSimpleClientset(original)
+	remediations := NewReissuer(clientset, ca, true, 30*24*time.Hour).ReissueAll(context.Background(), nearExpiryResult())
+	if len(remediations) != 1 || !remediations[0].DryRun || remediations[0].Error != "" {
+		t.Fatalf("expected a dry run remediation, got %+v", remediations)
+	}
+	unchanged, _ := clientset.CoreV1().Secrets("internal").Get(context.Background(), "internal-tls", metav1.GetOptions{})
+	if string(unchanged.Data[v1.TLSCertKey]) != string(original.Data[v1.TLSCertKey]) {
+		t.Errorf("a dry run should not change the secret")
+	}
+	secrets, _ := clientset.CoreV1().Secrets("internal").List(context.Background(), metav1.ListOptions{})
+	if len(secrets.Items) != 1 {
+		t.Errorf("a dry run should not create a backup, got %d secrets", len(secrets.Items))
+	}
+}
+
+func TestReissueRefusesCertsFromOtherCAs(t *testing.T) {
+	otherCA := makeTestCA(t)
+	selfSigned, _, _ := certs.LoadCert(makeExpiringSecret(t, nil).Data[v1.TLSCertKey], "test")
+	_, certPEM, keyPEM, issueErr := otherCA.Reissue(selfSigned, testNow.AddDate(0, 0, -85), 90*24*time.Hour)
+	if issueErr != nil {
+		t.Fatal(issueErr)
+	}
+	original := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "internal", Name: "internal-tls", Annotations: map[string]string{ReissueAnnotation: "true"}},
+		Type:       v1.SecretTypeTLS,
+		Data:       map[string][]byte{v1.TLSCertKey: certPEM, v1.TLSPrivateKeyKey: keyPEM},
+	}
+	clientset := fake.NewSimpleClientset(original)
+	reissuer := NewReissuer(clientset, makeTestCA(t), false, 0)
+	reissuer.Now = func() time.Time { return testNow }
+
+	remediations := reissuer.ReissueAll(context.Background(), nearExpiryResult())
+	if len(remediations) != 1 || remediations[0].Error == "" {
+		t.Fatalf("expected an error remediation for a cert from another CA, got %+v", remediations)
+	}
+	unchanged, _ := clientset.CoreV1().Secrets("internal").Get(context.Background(), "internal-tls", metav1.GetOptions{})
+	if string(unchanged.Data[v1.TLSCertKey]) != string(certPEM) {
+		t.Errorf("a cert from another CA should not be replaced")
+	}
+}
+
+func TestNeedsReplacing(t *testing.T) {
+	rec := nearExpiryResult()[0]
+	rec.CheckResult = datapersistence.HasCriticalFindings
+	rec.Findings = []datapersistence.Finding{
+		{Code: datapersistence.NearExpiryFinding, Severity: datapersistence.SeverityWarning, Result: datapersistence.ResultOf(datapersistence.NearExpiry)},
+		{Code: "WeakRSAKey", Severity: datapersistence.SeverityCritical},
+	}
+	if !NeedsReplacing(&rec) {
+		t.Error("a near-expiry cert should be replaced even if a critical finding sets its result")
+	}
+	rec.Findings = rec.Findings[1:]
+	if NeedsReplacing(&rec) {
+		t.Error("a cert with only a policy finding should not be replaced")
+	}
+}
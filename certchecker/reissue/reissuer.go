@@ -0,0 +1,213 @@
+package reissue
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"time"
+)
+
+const (
+	// ReissueAnnotation must be set to "true" on a secret before certchecker will replace its certificate
+	ReissueAnnotation = "certchecker.guardian.co.uk/reissue"
+	// ReissuedAtAnnotation and BackupAnnotation are set on a secret once it has been re-issued
+	ReissuedAtAnnotation = "certchecker.guardian.co.uk/reissued-at"
+	BackupAnnotation     = "certchecker.guardian.co.uk/backup-secret"
+	// BackupOfLabel is set on each backup to the name of the secret it was taken from
+	BackupOfLabel = "certchecker.guardian.co.uk/backup-of"
+	// BackupSecretType isn't one of the types that certchecker scans, so the old certs in a backup aren't reported
+	BackupSecretType v1.SecretType = "certchecker.guardian.co.uk/backup"
+
+	RemediationAction = "reissue"
+)
+
+/**
+Reissuer replaces the near-expiry certificates in opted-in secrets with new ones signed by an internal CA.
+Before a secret is changed, its old data is copied to a backup secret alongside it so that it can be rolled back
+*/
+type Reissuer struct {
+	Clientset kubernetes.Interface
+	CA        *CA
+	DryRun    bool
+	// Validity is how long the new certs are valid for; if zero, they get the same lifetime as the ones they replace
+	Validity time.Duration
+	Now      func() time.Time
+}
+
+func NewReissuer(clientset kubernetes.Interface, ca *CA, dryRun bool, validity time.Duration) *Reissuer {
+	return &Reissuer{
+		Clientset: clientset,
+		CA:        ca,
+		DryRun:    dryRun,
+		Validity:  validity,
+		Now:       time.Now,
+	}
+}
+
+/**
+true if the record is for the `tls.crt` of a secret and is close enough to expiry to need replacing.  This goes by the
+date findings, so a cert whose result is taken over by e.g. a weak key is still replaced
+*/
+func NeedsReplacing(rec *datapersistence.CheckRecord) bool {
+	if rec.Source.Kind != datapersistence.SourceSecret || rec.Source.DataKey != v1.TLSCertKey || rec.Source.Alias != "" {
+		return false
+	}
+	switch rec.ExpiryResult() {
+	case datapersistence.NearExpiry, datapersistence.Critical, datapersistence.AfterExpiry:
+		return true
+	default:
+		return false
+	}
+}
+
+func backupName(secretName string, at time.Time) string {
+	suffix := "-backup-" + at.UTC().Format("20060102150405")
+	if len(secretName)+len(suffix) > 253 {
+		secretName = secretName[:253-len(suffix)]
+	}
+	return secretName + suffix
+}
+
+/**
+re-issues every opted-in secret in the results that needs it, returning a record of what was done for each one
+*/
+func (r *Reissuer) ReissueAll(ctx context.Context, results []datapersistence.CheckRecord) []datapersistence.RemediationRecord {
+	remediations := make([]datapersistence.RemediationRecord, 0)
+	for i := range results {
+		rec := &results[i]
+		if !NeedsReplacing(rec) {
+			continue
+		}
+		remediation, attempted := r.reissueSecret(ctx, rec)
+		if !attempted {
+			continue
+		}
+		if remediation.Error != "" {
+			log.Printf("ERROR Could not re-issue %s: %s", rec.Source, remediation.Error)
+		} else {
+			log.Printf("INFO %s: %s", rec.Source, remediation.Details)
+		}
+		remediations = append(remediations, *remediation)
+	}
+	return remediations
+}
+
+/**
+re-issues a single secret.  The boolean is false if the secret hasn't opted in, in which case nothing is recorded
+*/
+func (r *Reissuer) reissueSecret(ctx context.Context, rec *datapersistence.CheckRecord) (*datapersistence.RemediationRecord, bool) {
+	now := r.Now()
+	remediation := &datapersistence.RemediationRecord{
+		Cluster: rec.Cluster,
+		Source:  rec.Source,
+		Action:  RemediationAction,
+		At:      now,
+		DryRun:  r.DryRun,
+	}
+	fail := func(err error) (*datapersistence.RemediationRecord, bool) {
+		remediation.Error = err.Error()
+		return remediation, true
+	}
+
+	client := r.Clientset.CoreV1().Secrets(rec.Source.Namespace)
+	secret, getErr := client.Get(ctx, rec.Source.Name, metav1.GetOptions{})
+	if getErr != nil {
+		return fail(getErr)
+	}
+	if secret.Annotations[ReissueAnnotation] != "true" {
+		return nil, false
+	}
+
+	original, _, loadErr := certs.LoadCert(secret.Data[v1.TLSCertKey], rec.Source.String())
+	if loadErr != nil {
+		return fail(loadErr)
+	}
+	//replacing a cert from some other CA would break every client that only trusts that CA
+	if original.CheckSignatureFrom(r.CA.Cert) != nil && !isSelfSigned(original) {
+		return fail(fmt.Errorf("not re-issuing, the cert was issued by %s rather than %s", original.Issuer, r.CA.Cert.Subject))
+	}
+
+	newCert, certPEM, keyPEM, issueErr := r.CA.Reissue(original, now, r.Validity)
+	if issueErr != nil {
+		return fail(issueErr)
+	}
+	if isChain(secret.Data[v1.TLSCertKey]) {
+		certPEM = appendChain(certPEM, r.CA.PEM)
+	}
+	remediation.NewValidUntil = &newCert.NotAfter
+
+	if r.DryRun {
+		remediation.Details = fmt.Sprintf("would re-issue as %s until %s, backing up to %s (dry run)", newCert.Subject, newCert.NotAfter.Format(time.RFC3339), backupName(secret.Name, now))
+		return remediation, true
+	}
+
+	data := map[string][]byte{
+		v1.TLSCertKey:       certPEM,
+		v1.TLSPrivateKeyKey: keyPEM,
+	}
+	if _, haveCA := secret.Data["ca.crt"]; haveCA {
+		data["ca.crt"] = r.CA.PEM
+	}
+	backup, replaceErr := ReplaceData(ctx, r.Clientset, secret, data, now)
+	if replaceErr != nil {
+		return fail(replaceErr)
+	}
+
+	remediation.Details = fmt.Sprintf("re-issued until %s, old data backed up to %s", newCert.NotAfter.Format(time.RFC3339), backup)
+	return remediation, true
+}
+
+/**
+CheckSignatureFrom won't accept a parent that isn't a CA, which self-signed leaf certs usually aren't
+*/
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+/**
+replaces the given keys in the secret's data, having first copied all of its old data to a backup secret alongside
+it.  Nothing is changed if the backup can't be made.  Returns the name of the backup
+*/
+func ReplaceData(ctx context.Context, clientset kubernetes.Interface, secret *v1.Secret, data map[string][]byte, now time.Time) (string, error) {
+	client := clientset.CoreV1().Secrets(secret.Namespace)
+	backup := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupName(secret.Name, now),
+			Namespace: secret.Namespace,
+			Labels:    map[string]string{BackupOfLabel: secret.Name},
+			Annotations: map[string]string{
+				ReissuedAtAnnotation: now.UTC().Format(time.RFC3339),
+			},
+		},
+		Type: BackupSecretType,
+		Data: secret.Data,
+	}
+	if _, createErr := client.Create(ctx, backup, metav1.CreateOptions{}); createErr != nil {
+		return "", fmt.Errorf("could not back up the old data, not replacing it: %s", createErr)
+	}
+
+	updated := secret.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = make(map[string][]byte)
+	}
+	if updated.Annotations == nil {
+		updated.Annotations = make(map[string]string)
+	}
+	for key, value := range data {
+		updated.Data[key] = value
+	}
+	updated.Annotations[ReissuedAtAnnotation] = now.UTC().Format(time.RFC3339)
+	updated.Annotations[BackupAnnotation] = backup.Name
+	if _, updateErr := client.Update(ctx, updated, metav1.UpdateOptions{}); updateErr != nil {
+		return "", fmt.Errorf("could not update the secret, the old data is still in place: %s", updateErr)
+	}
+	return backup.Name, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/acmerenew"
+	certfinder2 "github.com/guardian/k8s-certchecker/certchecker/certfinder"
+	"github.com/guardian/k8s-certchecker/certchecker/certmanager"
+	"github.com/guardian/k8s-certchecker/certchecker/reissue"
+	"github.com/guardian/k8s-certchecker/certchecker/restart"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+the settings for fixing certificates rather than just reporting on them
+*/
+type remediationSettings struct {
+	ReissueCA       string
+	ReissueValidity time.Duration
+	DryRun          bool
+
+	ACMEDirectory string
+	ACMEAccount   string
+	ACMEEmail     string
+	ACMEChallenge string
+	// ACMEService is the namespace, name and port of the Service in front of ACMEServer, for HTTP-01 challenges
+	ACMEService      acmeService
+	ACMEIngressClass string
+	ACMEServer       *acmerenew.ChallengeServer
+	ACMEListen       string
+	ACMEDNSCommand   string
+	acmeServerOnce   sync.Once
+
+	RenewCertManager bool
+	CertManagerStuck time.Duration
+
+	RestartWorkloads   bool
+	RestartMinInterval time.Duration
+	RestartMaxPerRun   int
+}
+
+type acmeService struct {
+	Namespace string
+	Name      string
+	Port      int32
+}
+
+type remediationFlags struct {
+	ReissueCA       *string
+	ReissueValidity *time.Duration
+	DryRun          *bool
+
+	ACMEDirectory    *string
+	ACMEAccount      *string
+	ACMEEmail        *string
+	ACMEChallenge    *string
+	ACMEService      *string
+	ACMEIngressClass *string
+	ACMEListen       *string
+	ACMEDNSCommand   *string
+
+	RenewCertManager *bool
+	CertManagerStuck *time.Duration
+
+	RestartWorkloads   *bool
+	RestartMinInterval *time.Duration
+	RestartMaxPerRun   *int
+}
+
+func registerRemediationFlags(flags *flag.FlagSet) *remediationFlags {
+	return &remediationFlags{
+		ReissueCA:       flags.String("reissue-ca", "", "namespace/name of a tls secret holding a CA to re-issue near-expiry certs with, in secrets that opt in"),
+		ReissueValidity: flags.Duration("reissue-validity", 0, "how long re-issued certs are valid for (by default, as long as the certs they replace)"),
+		DryRun:          flags.Bool("remediation-dry-run", false, "log what would be done to fix certs without changing anything"),
+
+		ACMEDirectory:    flags.String("acme-directory", "", "directory URL of an ACME CA to renew near-expiry certs from, in secrets that opt in, e.g. "+acmerenew.LetsEncryptURL),
+		ACMEAccount:      flags.String("acme-account", "", "namespace/name of a secret to keep the ACME account key in; it is created if it doesn't exist"),
+		ACMEEmail:        flags.String("acme-email", "", "contact address for the ACME account"),
+		ACMEChallenge:    flags.String("acme-challenge", acmerenew.HTTP01, "type of ACME challenge to solve, http-01 or dns-01"),
+		ACMEService:      flags.String("acme-http-service", "", "namespace/name:port of the Service that routes to certchecker's -acme-http-listen port, for http-01 challenges"),
+		ACMEIngressClass: flags.String("acme-ingress-class", "", "ingress class for the temporary http-01 challenge ingresses"),
+		ACMEListen:       flags.String("acme-http-listen", ":8089", "address to serve http-01 challenge responses on"),
+		ACMEDNSCommand:   flags.String("acme-dns-command", "", "command to run to create and remove TXT records for dns-01 challenges"),
+
+		RenewCertManager: flags.Bool("renew-cert-manager", false, "trigger renewal of near-expiry cert-manager Certificates that cert-manager should already have renewed"),
+		CertManagerStuck: flags.Duration("cert-manager-stuck-after", certmanager.DefaultStuckAfter, "how long a CertificateRequest can be outstanding before it is deleted so that cert-manager tries again"),
+
+		RestartWorkloads:   flags.Bool("restart-on-rotation", false, "restart opted-in workloads whose pods started before a TLS secret they use was rotated"),
+		RestartMinInterval: flags.Duration("restart-min-interval", restart.DefaultMinInterval, "minimum time between restarts of the same workload"),
+		RestartMaxPerRun:   flags.Int("restart-max-per-run", restart.DefaultMaxPerRun, "maximum number of workloads to restart in each run"),
+	}
+}
+
+/**
+parses a Service given as namespace/name:port
+*/
+func parseACMEService(value string) (acmeService, error) {
+	nsAndName := strings.SplitN(value, "/", 2)
+	if len(nsAndName) != 2 {
+		return acmeService{}, fmt.Errorf("-acme-http-service '%s' should be given as namespace/name:port", value)
+	}
+	nameAndPort := strings.SplitN(nsAndName[1], ":", 2)
+	if len(nameAndPort) != 2 {
+		return acmeService{}, fmt.Errorf("-acme-http-service '%s' should be given as namespace/name:port", value)
+	}
+	port, portErr := strconv.ParseInt(nameAndPort[1], 10, 32)
+	if portErr != nil {
+		return acmeService{}, fmt.Errorf("-acme-http-service '%s' has an invalid port: %s", value, portErr)
+	}
+	return acmeService{Namespace: nsAndName[0], Name: nameAndPort[0], Port: int32(port)}, nil
+}
+
+/**
+returns the remediation settings, or nil if no remediation was asked for
+*/
+func (f *remediationFlags) Settings() (*remediationSettings, error) {
+	if *f.ReissueCA == "" && *f.ACMEDirectory == "" && !*f.RenewCertManager && !*f.RestartWorkloads {
+		return nil, nil
+	}
+	settings := &remediationSettings{
+		ReissueCA:          *f.ReissueCA,
+		ReissueValidity:    *f.ReissueValidity,
+		DryRun:             *f.DryRun,
+		RenewCertManager:   *f.RenewCertManager,
+		CertManagerStuck:   *f.CertManagerStuck,
+		RestartWorkloads:   *f.RestartWorkloads,
+		RestartMinInterval: *f.RestartMinInterval,
+		RestartMaxPerRun:   *f.RestartMaxPerRun,
+	}
+	if *f.ACMEDirectory == "" {
+		return settings, nil
+	}
+
+	if *f.ACMEAccount == "" {
+		return nil, errors.New("-acme-account is required with -acme-directory")
+	}
+	settings.ACMEDirectory = *f.ACMEDirectory
+	settings.ACMEAccount = *f.ACMEAccount
+	settings.ACMEEmail = *f.ACMEEmail
+	settings.ACMEChallenge = *f.ACMEChallenge
+
+	switch settings.ACMEChallenge {
+	case acmerenew.HTTP01:
+		service, serviceErr := parseACMEService(*f.ACMEService)
+		if serviceErr != nil {
+			return nil, serviceErr
+		}
+		settings.ACMEService = service
+		settings.ACMEIngressClass = *f.ACMEIngressClass
+		settings.ACMEServer = acmerenew.NewChallengeServer()
+		settings.ACMEListen = *f.ACMEListen
+	case acmerenew.DNS01:
+		if *f.ACMEDNSCommand == "" {
+			return nil, errors.New("-acme-dns-command is required for dns-01 challenges")
+		}
+		settings.ACMEDNSCommand = *f.ACMEDNSCommand
+	default:
+		return nil, fmt.Errorf("-acme-challenge must be %s or %s", acmerenew.HTTP01, acmerenew.DNS01)
+	}
+	return settings, nil
+}
+
+/**
+starts serving http-01 challenge responses on ACMEListen, the first time it is called
+*/
+func (s *remediationSettings) startChallengeServer() {
+	s.acmeServerOnce.Do(func() {
+		go func() {
+			log.Printf("INFO Serving http-01 challenge responses on %s", s.ACMEListen)
+			if err := http.ListenAndServe(s.ACMEListen, s.ACMEServer); err != nil {
+				log.Printf("ERROR Could not serve http-01 challenge responses: %s", err)
+			}
+		}()
+	})
+}
+
+func (s *remediationSettings) acmeSolver(cluster *clusterClient) acmerenew.Solver {
+	if s.ACMEChallenge == acmerenew.DNS01 {
+		return &acmerenew.DNS01Solver{Provider: &acmerenew.ExecDNSProvider{Command: s.ACMEDNSCommand}}
+	}
+	return &acmerenew.HTTP01Solver{
+		Clientset:    cluster.Clientset,
+		Server:       s.ACMEServer,
+		Namespace:    s.ACMEService.Namespace,
+		ServiceName:  s.ACMEService.Name,
+		ServicePort:  s.ACMEService.Port,
+		IngressClass: s.ACMEIngressClass,
+	}
+}
+
+/**
+records the secrets that certchecker itself uses for remediation as consumed, so that they aren't reported as unused
+*/
+func (s *remediationSettings) addOwnSecrets(consumers *certfinder2.ConsumerIndex) {
+	for _, secret := range []struct{ flag, ref string }{{"reissue-ca", s.ReissueCA}, {"acme-account", s.ACMEAccount}} {
+		parts := strings.SplitN(secret.ref, "/", 2)
+		if len(parts) == 2 {
+			consumers.Add(parts[0], parts[1], datapersistence.Consumer{Kind: "Certchecker", Name: "-" + secret.flag})
+		}
+	}
+}
+
+/**
+tries to fix the problems in the results for a cluster, returning a record of everything that was done
+*/
+func remediate(ctx context.Context, cluster *clusterClient, settings *remediationSettings, results []datapersistence.CheckRecord) []datapersistence.RemediationRecord {
+	remediations := make([]datapersistence.RemediationRecord, 0)
+
+	if settings.ReissueCA != "" {
+		ca, caErr := reissue.LoadCA(ctx, cluster.Clientset, settings.ReissueCA)
+		if caErr != nil {
+			log.Printf("ERROR Not re-issuing any certs in cluster %s: %s", cluster.Name, caErr)
+		} else {
+			reissuer := reissue.NewReissuer(cluster.Clientset, ca, settings.DryRun, settings.ReissueValidity)
+			remediations = append(remediations, reissuer.ReissueAll(ctx, results)...)
+		}
+	}
+
+	if settings.ACMEDirectory != "" && needsReplacing(results) {
+		renewer, renewerErr := newACMERenewer(ctx, cluster, settings)
+		if renewerErr != nil {
+			log.Printf("ERROR Not renewing any certs with ACME in cluster %s: %s", cluster.Name, renewerErr)
+		} else {
+			remediations = append(remediations, renewer.RenewAll(ctx, results)...)
+		}
+	}
+
+	if settings.RenewCertManager {
+		renewer := certmanager.NewRenewer(cluster.Dynamic, settings.DryRun, settings.CertManagerStuck)
+		remediations = append(remediations, renewer.RenewAll(ctx, results)...)
+	}
+
+	//this comes last so that it picks up the secrets that were replaced above
+	if settings.RestartWorkloads {
+		restarter := restart.NewRestarter(cluster.Clientset, settings.DryRun, settings.RestartMinInterval, settings.RestartMaxPerRun)
+		remediations = append(remediations, restarter.RestartAll(ctx, results)...)
+	}
+
+	for i := range remediations {
+		remediations[i].Cluster = cluster.Name
+	}
+	return remediations
+}
+
+/**
+true if any of the results is a cert that re-issuing or ACME renewal would replace
+*/
+func needsReplacing(results []datapersistence.CheckRecord) bool {
+	for i := range results {
+		if reissue.NeedsReplacing(&results[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+sets up ACME renewal for a cluster.  A dry run never talks to the CA, so it doesn't need the account key (which would
+be created if it didn't exist) or a client
+*/
+func newACMERenewer(ctx context.Context, cluster *clusterClient, settings *remediationSettings) (*acmerenew.Renewer, error) {
+	if settings.DryRun {
+		return acmerenew.NewRenewer(cluster.Clientset, nil, settings.acmeSolver(cluster), true), nil
+	}
+	accountKey, keyErr := acmerenew.LoadAccountKey(ctx, cluster.Clientset, settings.ACMEAccount)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	client, clientErr := acmerenew.NewClient(ctx, settings.ACMEDirectory, accountKey, settings.ACMEEmail)
+	if clientErr != nil {
+		return nil, clientErr
+	}
+	if settings.ACMEChallenge == acmerenew.HTTP01 {
+		settings.startChallengeServer()
+	}
+	return acmerenew.NewRenewer(cluster.Clientset, client, settings.acmeSolver(cluster), settings.DryRun), nil
+}
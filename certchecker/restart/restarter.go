@@ -0,0 +1,299 @@
+package restart
+
+import (
+	"context"
+	"fmt"
+	"github.com/guardian/k8s-certchecker/certchecker/certfinder"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/certchecker/reissue"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// RestartAnnotation must be set to "true" on a workload before certchecker will restart it
+	RestartAnnotation = "certchecker.guardian.co.uk/restart-on-rotation"
+	// RestartedAtAnnotation is set on the pod template to trigger a rollout, as `kubectl rollout restart` does
+	RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+	RemediationAction = "restart"
+
+	DefaultMinInterval = time.Hour
+	DefaultMaxPerRun   = 5
+)
+
+/**
+a Deployment, StatefulSet or DaemonSet, with the parts of it that matter for restarting
+*/
+type workload struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	Annotations map[string]string
+	Selector    *metav1.LabelSelector
+	Template    *v1.PodTemplateSpec
+	patch       func(ctx context.Context, patch []byte) error
+}
+
+func (w *workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+/**
+Restarter triggers a rollout restart of the workloads whose pods are still using the old version of a TLS secret
+that has since been rotated, because they don't reload it by themselves.  A secret counts as rotated for a pod if the
+cert in it was issued after the pod started.  Only workloads annotated with RestartAnnotation are restarted; none is
+restarted more than once in MinInterval and no more than MaxPerRun are restarted in each run
+*/
+type Restarter struct {
+	Clientset   kubernetes.Interface
+	DryRun      bool
+	MinInterval time.Duration
+	MaxPerRun   int
+	Now         func() time.Time
+}
+
+func NewRestarter(clientset kubernetes.Interface, dryRun bool, minInterval time.Duration, maxPerRun int) *Restarter {
+	return &Restarter{
+		Clientset:   clientset,
+		DryRun:      dryRun,
+		MinInterval: minInterval,
+		MaxPerRun:   maxPerRun,
+		Now:         time.Now,
+	}
+}
+
+/**
+the names of the TLS secrets in the results, by namespace
+*/
+func tlsSecrets(results []datapersistence.CheckRecord) map[string]map[string]datapersistence.SourceDescriptor {
+	secrets := make(map[string]map[string]datapersistence.SourceDescriptor)
+	for _, rec := range results {
+		if rec.Source.Kind != datapersistence.SourceSecret || rec.Source.DataKey != v1.TLSCertKey || rec.Source.Alias != "" {
+			continue
+		}
+		if secrets[rec.Source.Namespace] == nil {
+			secrets[rec.Source.Namespace] = make(map[string]datapersistence.SourceDescriptor)
+		}
+		secrets[rec.Source.Namespace][rec.Source.Name] = rec.Source
+	}
+	return secrets
+}
+
+/**
+when the cert in a secret was last changed: the later of the time certchecker last replaced it and when the cert was
+issued.  The annotation stays behind if something else, e.g. cert-manager, renews the cert afterwards, so it can't be
+taken on its own
+*/
+func (r *Restarter) rotatedAt(ctx context.Context, namespace string, name string) (time.Time, error) {
+	secret, getErr := r.Clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if getErr != nil {
+		return time.Time{}, getErr
+	}
+	replaced, parseErr := time.Parse(time.RFC3339, secret.Annotations[reissue.ReissuedAtAnnotation])
+	cert, _, loadErr := certs.LoadCert(secret.Data[v1.TLSCertKey], namespace+"/"+name)
+	switch {
+	case loadErr != nil && parseErr != nil:
+		return time.Time{}, loadErr
+	case loadErr != nil:
+		return replaced, nil
+	case parseErr == nil && replaced.After(cert.NotBefore):
+		return replaced, nil
+	default:
+		return cert.NotBefore, nil
+	}
+}
+
+func (r *Restarter) listWorkloads(ctx context.Context, namespace string) ([]*workload, error) {
+	workloads := make([]*workload, 0)
+	apps := r.Clientset.AppsV1()
+
+	deployments, deploymentsErr := apps.Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if deploymentsErr != nil {
+		return nil, deploymentsErr
+	}
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		workloads = append(workloads, &workload{
+			Kind:        "Deployment",
+			Namespace:   d.Namespace,
+			Name:        d.Name,
+			Annotations: d.Annotations,
+			Selector:    d.Spec.Selector,
+			Template:    &d.Spec.Template,
+			patch: func(ctx context.Context, patch []byte) error {
+				_, err := apps.Deployments(d.Namespace).Patch(ctx, d.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	statefulSets, statefulSetsErr := apps.StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if statefulSetsErr != nil {
+		return nil, statefulSetsErr
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		workloads = append(workloads, &workload{
+			Kind:        "StatefulSet",
+			Namespace:   s.Namespace,
+			Name:        s.Name,
+			Annotations: s.Annotations,
+			Selector:    s.Spec.Selector,
+			Template:    &s.Spec.Template,
+			patch: func(ctx context.Context, patch []byte) error {
+				_, err := apps.StatefulSets(s.Namespace).Patch(ctx, s.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	daemonSets, daemonSetsErr := apps.DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if daemonSetsErr != nil {
+		return nil, daemonSetsErr
+	}
+	for i := range daemonSets.Items {
+		d := &daemonSets.Items[i]
+		workloads = append(workloads, &workload{
+			Kind:        "DaemonSet",
+			Namespace:   d.Namespace,
+			Name:        d.Name,
+			Annotations: d.Annotations,
+			Selector:    d.Spec.Selector,
+			Template:    &d.Spec.Template,
+			patch: func(ctx context.Context, patch []byte) error {
+				_, err := apps.DaemonSets(d.Namespace).Patch(ctx, d.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+	return workloads, nil
+}
+
+/**
+returns true if any of the workload's pods started before `rotatedAt`
+*/
+func (r *Restarter) hasStalePods(ctx context.Context, w *workload, rotatedAt time.Time) (bool, error) {
+	selector, selectorErr := metav1.LabelSelectorAsSelector(w.Selector)
+	if selectorErr != nil {
+		return false, selectorErr
+	}
+	pods, listErr := r.Clientset.CoreV1().Pods(w.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if listErr != nil {
+		return false, listErr
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.StartTime != nil && pod.Status.StartTime.Time.Before(rotatedAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/**
+restarts every opted-in workload that is still using an old version of one of the TLS secrets in the results,
+returning a record of each restart
+*/
+func (r *Restarter) RestartAll(ctx context.Context, results []datapersistence.CheckRecord) []datapersistence.RemediationRecord {
+	remediations := make([]datapersistence.RemediationRecord, 0)
+	now := r.Now()
+	restarted := 0
+
+	byNamespace := tlsSecrets(results)
+	namespaces := make([]string, 0, len(byNamespace))
+	for namespace := range byNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		secrets := byNamespace[namespace]
+		workloads, listErr := r.listWorkloads(ctx, namespace)
+		if listErr != nil {
+			log.Printf("ERROR Could not list workloads in %s to restart: %s", namespace, listErr)
+			continue
+		}
+		rotationTimes := make(map[string]time.Time)
+
+		for _, w := range workloads {
+			if w.Annotations[RestartAnnotation] != "true" {
+				continue
+			}
+			for _, secretName := range certfinder.PodSpecSecrets(&w.Template.Spec) {
+				source, isTLS := secrets[secretName]
+				if !isTLS {
+					continue
+				}
+				rotatedAt, known := rotationTimes[secretName]
+				if !known {
+					var rotatedErr error
+					if rotatedAt, rotatedErr = r.rotatedAt(ctx, namespace, secretName); rotatedErr != nil {
+						log.Printf("ERROR Could not tell when %s was rotated: %s", source, rotatedErr)
+						continue
+					}
+					rotationTimes[secretName] = rotatedAt
+				}
+
+				stale, staleErr := r.hasStalePods(ctx, w, rotatedAt)
+				if staleErr != nil {
+					log.Printf("ERROR Could not list the pods of %s: %s", w, staleErr)
+					break
+				}
+				if !stale {
+					continue
+				}
+				if remediation := r.restart(ctx, w, source, now, restarted); remediation != nil {
+					remediations = append(remediations, *remediation)
+					if remediation.Error == "" {
+						restarted++
+					}
+				}
+				//one restart picks up every secret the workload uses
+				break
+			}
+		}
+	}
+	return remediations
+}
+
+/**
+restarts a single workload, unless that would break the rate limits
+*/
+func (r *Restarter) restart(ctx context.Context, w *workload, source datapersistence.SourceDescriptor, now time.Time, restartedSoFar int) *datapersistence.RemediationRecord {
+	if last, parseErr := time.Parse(time.RFC3339, w.Template.Annotations[RestartedAtAnnotation]); parseErr == nil && now.Sub(last) < r.MinInterval {
+		log.Printf("WARNING %s is using an old version of %s but was restarted at %s, not restarting it again yet", w, source, last.Format(time.RFC3339))
+		return nil
+	}
+	if restartedSoFar >= r.MaxPerRun {
+		log.Printf("WARNING %s is using an old version of %s but %d workloads have already been restarted, leaving it until the next run", w, source, restartedSoFar)
+		return nil
+	}
+
+	remediation := &datapersistence.RemediationRecord{
+		Source: source,
+		Action: RemediationAction,
+		At:     now,
+		DryRun: r.DryRun,
+	}
+	if r.DryRun {
+		remediation.Details = fmt.Sprintf("would restart %s, whose pods started before the secret was rotated (dry run)", w)
+		log.Printf("INFO %s: %s", source, remediation.Details)
+		return remediation
+	}
+
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"%s":"%s"}}}}}`, RestartedAtAnnotation, now.UTC().Format(time.RFC3339))
+	if patchErr := w.patch(ctx, []byte(patch)); patchErr != nil {
+		remediation.Error = fmt.Sprintf("could not restart %s: %s", w, patchErr)
+		log.Printf("ERROR %s: %s", source, remediation.Error)
+		return remediation
+	}
+	remediation.Details = fmt.Sprintf("restarted %s, whose pods started before the secret was rotated", w)
+	log.Printf("INFO %s: %s", source, remediation.Details)
+	return remediation
+}
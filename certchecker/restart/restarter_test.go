@@ -0,0 +1,251 @@
+package restart
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/guardian/k8s-certchecker/certchecker/reissue"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+// the secret was replaced by certchecker two hours ago
+var rotated = testNow.Add(-2 * time.Hour)
+
+func rotatedSecret(name string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "web",
+			Name:        name,
+			Annotations: map[string]string{reissue.ReissuedAtAnnotation: rotated.Format(time.RFC3339)},
+		},
+		Type: v1.SecretTypeTLS,
+	}
+}
+
+func podFor(app string, started time.Time) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: app + "-pod", Labels: map[string]string{"app": app}},
+		Status:     v1.PodStatus{StartTime: &metav1.Time{Time: started}},
+	}
+}
+
+func template(app string, spec v1.PodSpec, annotations map[string]string) v1.PodTemplateSpec {
+	return v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": app}, Annotations: annotations},
+		Spec:       spec,
+	}
+}
+
+func deployment(name string, optIn bool, spec v1.PodSpec, templateAnnotations map[string]string) *appsv1.Deployment {
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: name, Annotations: map[string]string{}},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: template(name, spec, templateAnnotations),
+		},
+	}
+	if optIn {
+		d.Annotations[RestartAnnotation] = "true"
+	}
+	return d
+}
+
+func volumeSpec(secret string) v1.PodSpec {
+	return v1.PodSpec{Volumes: []v1.Volume{{
+		Name:         "tls",
+		VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: secret}},
+	}}}
+}
+
+func projectedSpec(secret string) v1.PodSpec {
+	return v1.PodSpec{Volumes: []v1.Volume{{
+		Name: "certs",
+		VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{{
+			Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: secret}},
+		}}}},
+	}}}
+}
+
+func envSpec(secret string) v1.PodSpec {
+	return v1.PodSpec{Containers: []v1.Container{{
+		Name: "app",
+		Env: []v1.EnvVar{{
+			Name: "TLS_CERT",
+			ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: secret},
+				Key:                  v1.TLSCertKey,
+			}},
+		}},
+	}}}
+}
+
+func results(secrets ...string) []datapersistence.CheckRecord {
+	records := make([]datapersistence.CheckRecord, 0)
+	for _, name := range secrets {
+		records = append(records, datapersistence.CheckRecord{
+			Source: datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: name, DataKey: v1.TLSCertKey},
+		})
+	}
+	return records
+}
+
+func restartedAt(t *testing.T, clientset *fake.Clientset, name string) string {
+	d, err := clientset.AppsV1().Deployments("web").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.Spec.Template.Annotations[RestartedAtAnnotation]
+}
+
+func newTestRestarter(clientset *fake.Clientset, maxPerRun int) *Restarter {
+	restarter := NewRestarter(clientset, false, DefaultMinInterval, maxPerRun)
+	restarter.Now = func() time.Time { return testNow }
+	return restarter
+}
+
+func TestRestartAll(t *testing.T) {
+	objects := []runtime.Object{
+		rotatedSecret("web-tls"),
+		//mounts the secret through a projected volume and started before it was rotated
+		deployment("stale", true, projectedSpec("web-tls"), nil),
+		podFor("stale", rotated.Add(-24*time.Hour)),
+		//reads it into the environment, but has already been restarted since
+		deployment("fresh", true, envSpec("web-tls"), nil),
+		podFor("fresh", rotated.Add(time.Hour)),
+		//would need restarting but hasn't opted in
+		deployment("not-opted-in", false, volumeSpec("web-tls"), nil),
+		podFor("not-opted-in", rotated.Add(-24*time.Hour)),
+		//was restarted a few minutes ago, e.g. by a previous run, and its pods haven't been replaced yet
+		deployment("recent", true, volumeSpec("web-tls"), map[string]string{RestartedAtAnnotation: testNow.Add(-10 * time.Minute).Format(time.RFC3339)}),
+		podFor("recent", rotated.Add(-24*time.Hour)),
+	}
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "db", Annotations: map[string]string{RestartAnnotation: "true"}},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Template: template("db", envSpec("web-tls"), nil),
+		},
+	}
+	objects = append(objects, statefulSet, podFor("db", rotated.Add(-time.Hour)))
+	clientset := fake.NewSimpleClientset(objects...)
+
+	remediations := newTestRestarter(clientset, DefaultMaxPerRun).RestartAll(context.Background(), results("web-tls"))
+	if len(remediations) != 2 {
+		t.Fatalf("expected 2 restarts, got %+v", remediations)
+	}
+	for _, remediation := range remediations {
+		if remediation.Error != "" || remediation.Action != RemediationAction || remediation.Source.Name != "web-tls" {
+			t.Errorf("unexpected remediation %+v", remediation)
+		}
+	}
+
+	if restartedAt(t, clientset, "stale") != testNow.Format(time.RFC3339) {
+		t.Errorf("expected the stale deployment to be restarted, got '%s'", restartedAt(t, clientset, "stale"))
+	}
+	for _, name := range []string{"fresh", "not-opted-in"} {
+		if restartedAt(t, clientset, name) != "" {
+			t.Errorf("expected %s not to be restarted", name)
+		}
+	}
+	if restartedAt(t, clientset, "recent") == testNow.Format(time.RFC3339) {
+		t.Error("expected the recently restarted deployment not to be restarted again")
+	}
+	db, _ := clientset.AppsV1().StatefulSets("web").Get(context.Background(), "db", metav1.GetOptions{})
+	if db.Spec.Template.Annotations[RestartedAtAnnotation] == "" {
+		t.Error("expected the statefulset to be restarted")
+	}
+}
+
+func TestRestartAllRateLimit(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		rotatedSecret("a-tls"),
+		rotatedSecret("b-tls"),
+		deployment("a", true, volumeSpec("a-tls"), nil),
+		podFor("a", rotated.Add(-time.Hour)),
+		deployment("b", true, volumeSpec("b-tls"), nil),
+		podFor("b", rotated.Add(-time.Hour)),
+	)
+
+	remediations := newTestRestarter(clientset, 1).RestartAll(context.Background(), results("a-tls", "b-tls"))
+	if len(remediations) != 1 {
+		t.Fatalf("expected only one restart per run, got %+v", remediations)
+	}
+	if restartedAt(t, clientset, "a") == "" || restartedAt(t, clientset, "b") != "" {
+		t.Errorf("expected only the first deployment to be restarted")
+	}
+}
+
+func certIssuedAt(t *testing.T, notBefore time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "web.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.AddDate(0, 3, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestRotatedAt(t *testing.T) {
+	renewed := rotated.Add(time.Hour)
+	issued := rotated.Add(-time.Minute)
+	tests := []struct {
+		name        string
+		annotated   bool
+		certIssued  *time.Time
+		expected    time.Time
+		expectError bool
+	}{
+		//renewed by something else after certchecker last replaced it
+		{"renewed since reissue", true, &renewed, renewed, false},
+		//the cert certchecker put in was issued a little before it was written
+		{"issued before reissue", true, &issued, rotated, false},
+		{"annotation only", true, nil, rotated, false},
+		{"cert only", false, &renewed, renewed, false},
+		{"neither", false, nil, time.Time{}, true},
+	}
+	for _, test := range tests {
+		secret := rotatedSecret("web-tls")
+		if !test.annotated {
+			secret.Annotations = nil
+		}
+		if test.certIssued != nil {
+			secret.Data = map[string][]byte{v1.TLSCertKey: certIssuedAt(t, *test.certIssued)}
+		}
+		restarter := newTestRestarter(fake.NewSimpleClientset(secret), DefaultMaxPerRun)
+
+		at, err := restarter.rotatedAt(context.Background(), "web", "web-tls")
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", test.name, at)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+		} else if !at.Equal(test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, at)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	certfinder2 "github.com/guardian/k8s-certchecker/certchecker/certfinder"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/**
+entrypoint for `certchecker scan-files <paths...>`, which runs the same checks against certificate files on disk
+and doesn't need a cluster at all
+*/
+func scanFilesMain(args []string) {
+	pwd, _ := os.Getwd()
+	flags := flag.NewFlagSet("scan-files", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s scan-files [options] [<path>...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	outputPath := flags.String("out", pwd, "path to create an output record in")
+	checkOptions := registerCheckFlags(flags)
+	kubeconfigs := flags.String("kubeconfigs", "", "comma-separated list of kubeconfig files to check the embedded client and CA certificates of")
+	failOnProblem := flags.Bool("fail", false, "exit with status 2 if any cert is expired, near expiry, not valid yet, has critical policy findings or could not be read")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 && *kubeconfigs == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	checks, checksErr := checkOptions.Settings()
+	if checksErr != nil {
+		log.Fatalf("Invalid options: %s", checksErr)
+	}
+
+	sources := []certfinder2.CertSource{certfinder2.NewFileSource(flags.Args())}
+	if *kubeconfigs != "" {
+		sources = append(sources, certfinder2.NewKubeconfigFileSource(strings.Split(*kubeconfigs, ",")))
+	}
+	//unlike a cluster scan every path here was asked for by name, so any source failing is fatal rather than skipped
+	foundCerts := make([]certfinder2.CertData, 0)
+	for _, source := range sources {
+		found, scanErr := source.FindCertificates(context.Background())
+		if scanErr != nil {
+			log.Fatalf("Could not scan %s: %s", source.Describe(), scanErr)
+		}
+		foundCerts = append(foundCerts, *found...)
+	}
+
+	results := checkCertificates(&foundCerts, checks, "")
+
+	writeErr := datapersistence.WriteReport(*outputPath, &datapersistence.PersistenceRecord{
+		CheckedAt: time.Now(),
+		AsOf:      checks.ReportAsOf(),
+		Results:   results,
+	})
+	if writeErr != nil {
+		log.Fatalf("ERROR Could not write out final report: %s", writeErr)
+	}
+
+	if *failOnProblem {
+		for _, result := range results {
+			switch result.CheckResult {
+			case datapersistence.Errored, datapersistence.NotValidYet, datapersistence.NearExpiry, datapersistence.Critical, datapersistence.AfterExpiry:
+				log.Printf("%s has problems, failing", result.Source)
+				os.Exit(2)
+			}
+			for _, finding := range result.Findings {
+				if finding.Severity == datapersistence.SeverityCritical {
+					log.Printf("%s has critical findings, failing", result.Source)
+					os.Exit(2)
+				}
+			}
+		}
+	}
+	log.Print("All done.")
+}
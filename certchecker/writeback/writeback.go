@@ -0,0 +1,197 @@
+package writeback
+
+import (
+	"context"
+	"github.com/guardian/k8s-certchecker/certchecker/certs"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	applycorev1 "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FieldManager = "certchecker"
+
+	StatusLabel           = "certchecker.guardian.co.uk/status"
+	SeverityLabel         = "certchecker.guardian.co.uk/severity"
+	LastCheckedAnnotation = "certchecker.guardian.co.uk/last-checked"
+	ExpiryAnnotation      = "certchecker.guardian.co.uk/expiry"
+	FingerprintAnnotation = "certchecker.guardian.co.uk/fingerprint"
+	FindingsAnnotation    = "certchecker.guardian.co.uk/findings"
+)
+
+/**
+the status of a secret, summarised from the results of every certificate in it
+*/
+type SecretStatus struct {
+	Namespace   string
+	Name        string
+	Result      datapersistence.ValidationResult
+	Severity    datapersistence.Severity
+	CheckedAt   time.Time
+	Expiry      time.Time
+	Fingerprint string
+	Findings    []string
+}
+
+/**
+turns a ValidationResult into something that can be used as a label value, e.g. `near-expiry`
+*/
+func StatusValue(result datapersistence.ValidationResult) string {
+	return strings.ReplaceAll(result.String(), " ", "-")
+}
+
+/**
+works out the status of each secret in the results.  A secret can hold several certs (e.g. tls.crt plus a keystore),
+in which case the status is the worst of them, the expiry is the earliest of them and the fingerprint is that of
+`tls.crt`
+*/
+func SummariseSecrets(results []datapersistence.CheckRecord) []SecretStatus {
+	type secretKey struct{ namespace, name string }
+	summaries := make(map[secretKey]*datapersistence.CheckRecord)
+	statuses := make(map[secretKey]*SecretStatus)
+	order := make([]secretKey, 0)
+
+	for i := range results {
+		rec := &results[i]
+		if rec.Source.Kind != datapersistence.SourceSecret || rec.Source.Namespace == "" {
+			continue
+		}
+		key := secretKey{rec.Source.Namespace, rec.Source.Name}
+		status, haveStatus := statuses[key]
+		if !haveStatus {
+			status = &SecretStatus{Namespace: key.namespace, Name: key.name, CheckedAt: rec.CheckedAt}
+			statuses[key] = status
+			summaries[key] = &datapersistence.CheckRecord{}
+			order = append(order, key)
+		}
+
+		certs.AddFindings(summaries[key], rec.Findings...)
+		for _, finding := range rec.Findings {
+			status.Findings = append(status.Findings, finding.Code)
+		}
+		if !rec.ValidUntil.IsZero() && (status.Expiry.IsZero() || rec.ValidUntil.Before(status.Expiry)) {
+			status.Expiry = rec.ValidUntil
+		}
+		if rec.Source.DataKey == v1.TLSCertKey && rec.Source.Alias == "" {
+			status.Fingerprint = rec.Fingerprint
+		}
+	}
+
+	output := make([]SecretStatus, 0, len(order))
+	for _, key := range order {
+		status := statuses[key]
+		summary := summaries[key]
+		status.Severity = summary.Severity
+		status.Result = summary.CheckResult
+		//the expiry is more use to select on than "has critical findings", which the severity label shows anyway
+		if expiry := summary.ExpiryResult(); expiry != datapersistence.WithinRange && summary.CheckResult != datapersistence.Errored {
+			status.Result = expiry
+		}
+		status.Findings = uniqueSorted(status.Findings)
+		output = append(output, *status)
+	}
+	return output
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+/**
+builds the server-side apply configuration for a secret's status.  Only labels and annotations are set, so applying
+this can never change the secret's data; and because it is applied with its own field manager, anything that is
+left out (e.g. the findings annotation once the findings are resolved) is removed again
+*/
+func ApplyConfiguration(status *SecretStatus) *applycorev1.SecretApplyConfiguration {
+	annotations := map[string]string{
+		LastCheckedAnnotation: status.CheckedAt.UTC().Format(time.RFC3339),
+	}
+	if !status.Expiry.IsZero() {
+		annotations[ExpiryAnnotation] = status.Expiry.UTC().Format(time.RFC3339)
+	}
+	if status.Fingerprint != "" {
+		annotations[FingerprintAnnotation] = status.Fingerprint
+	}
+	if len(status.Findings) > 0 {
+		annotations[FindingsAnnotation] = strings.Join(status.Findings, ",")
+	}
+
+	return applycorev1.Secret(status.Name, status.Namespace).
+		WithLabels(map[string]string{
+			StatusLabel:   StatusValue(status.Result),
+			SeverityLabel: status.Severity.String(),
+		}).
+		WithAnnotations(annotations)
+}
+
+/**
+Writer patches the status of each scanned secret back onto it, so that cert health can be queried with label selectors
+*/
+type Writer struct {
+	Clientset kubernetes.Interface
+	DryRun    bool
+}
+
+func NewWriter(clientset kubernetes.Interface, dryRun bool) *Writer {
+	return &Writer{
+		Clientset: clientset,
+		DryRun:    dryRun,
+	}
+}
+
+func (w *Writer) applyOptions() metav1.ApplyOptions {
+	opts := metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		//the fields we set are ours alone, so take them over if anything else has touched them
+		Force: true,
+	}
+	if w.DryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	return opts
+}
+
+/**
+applies the status of every secret in the results.  Returns the number of secrets that could not be updated;
+failures are logged and don't stop the others.  A server-side apply would create a secret that has been deleted since
+the scan, so secrets that no longer exist are skipped
+*/
+func (w *Writer) WriteBack(ctx context.Context, results []datapersistence.CheckRecord) int {
+	failures := 0
+	for _, status := range SummariseSecrets(results) {
+		_, getErr := w.Clientset.CoreV1().Secrets(status.Namespace).Get(ctx, status.Name, metav1.GetOptions{})
+		if errors.IsNotFound(getErr) {
+			log.Printf("INFO %s:%s has been deleted since it was scanned, not writing its status back", status.Namespace, status.Name)
+			continue
+		}
+		if getErr != nil {
+			log.Printf("ERROR Could not write status back to %s:%s: %s", status.Namespace, status.Name, getErr)
+			failures++
+			continue
+		}
+		_, applyErr := w.Clientset.CoreV1().Secrets(status.Namespace).Apply(ctx, ApplyConfiguration(&status), w.applyOptions())
+		if applyErr != nil {
+			log.Printf("ERROR Could not write status back to %s:%s: %s", status.Namespace, status.Name, applyErr)
+			failures++
+		} else if w.DryRun {
+			log.Printf("INFO Would set %s:%s status to %s (dry run)", status.Namespace, status.Name, StatusValue(status.Result))
+		}
+	}
+	return failures
+}
@@ -0,0 +1,135 @@
+package writeback
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+	"time"
+)
+
+func writebackTestResults() []datapersistence.CheckRecord {
+	checkedAt := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	secret := func(dataKey string, alias string) datapersistence.SourceDescriptor {
+		return datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: "web-tls", DataKey: dataKey, Alias: alias}
+	}
+	return []datapersistence.CheckRecord{
+		{
+			Source:      secret("tls.crt", ""),
+			CheckedAt:   checkedAt,
+			CheckResult: datapersistence.WithinRange,
+			ValidUntil:  checkedAt.AddDate(0, 6, 0),
+			Fingerprint: "abcd",
+		},
+		{
+			Source:      secret("keystore.p12", "server"),
+			CheckedAt:   checkedAt,
+			CheckResult: datapersistence.NearExpiry,
+			Severity:    datapersistence.SeverityWarning,
+			ValidUntil:  checkedAt.AddDate(0, 0, 10),
+			Fingerprint: "ef01",
+			Findings: []datapersistence.Finding{
+				{Code: "NearExpiry", Severity: datapersistence.SeverityWarning, Result: datapersistence.ResultOf(datapersistence.NearExpiry)},
+			},
+		},
+		{
+			Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceFile, Name: "/etc/ssl/cert.pem"},
+			CheckResult: datapersistence.AfterExpiry,
+		},
+	}
+}
+
+func TestSummariseSecrets(t *testing.T) {
+	statuses := SummariseSecrets(writebackTestResults())
+	if len(statuses) != 1 {
+		t.Fatalf("expected only the secret to be summarised, got %d", len(statuses))
+	}
+	status := statuses[0]
+	if status.Result != datapersistence.NearExpiry || status.Severity != datapersistence.SeverityWarning {
+		t.Errorf("expected the worst result of the secret's certs, got %s/%s", status.Result, status.Severity)
+	}
+	if status.Fingerprint != "abcd" {
+		t.Errorf("expected the tls.crt fingerprint, got %s", status.Fingerprint)
+	}
+	if !status.Expiry.Equal(time.Date(2026, 10, 29, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the earliest expiry, got %s", status.Expiry)
+	}
+
+	unreadable := SummariseSecrets([]datapersistence.CheckRecord{{
+		Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: "broken"},
+		CheckResult: datapersistence.Errored,
+		Findings:    []datapersistence.Finding{{Code: "Unreadable", Severity: datapersistence.SeverityCritical, Result: datapersistence.ResultOf(datapersistence.Errored)}},
+	}})
+	weak := SummariseSecrets([]datapersistence.CheckRecord{{
+		Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: "weak", DataKey: v1.TLSCertKey},
+		CheckResult: datapersistence.HasCriticalFindings,
+		Findings: []datapersistence.Finding{
+			{Code: datapersistence.NearExpiryFinding, Severity: datapersistence.SeverityWarning, Result: datapersistence.ResultOf(datapersistence.NearExpiry)},
+			{Code: "WeakRSAKey", Severity: datapersistence.SeverityCritical},
+		},
+	}})
+	if StatusValue(weak[0].Result) != "near-expiry" || weak[0].Severity != datapersistence.SeverityCritical {
+		t.Errorf("expected a near-expiry secret with a weak key to be labelled near-expiry and critical, got %s and %s", StatusValue(weak[0].Result), weak[0].Severity)
+	}
+
+	if StatusValue(unreadable[0].Result) != "errored" {
+		t.Errorf("expected an unreadable cert to give an errored status, got %s", StatusValue(unreadable[0].Result))
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web-tls"}})
+	var patches []k8stesting.PatchAction
+	clientset.PrependReactor("patch", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, action.(k8stesting.PatchAction))
+		return true, nil, nil
+	})
+
+	results := append(writebackTestResults(), datapersistence.CheckRecord{
+		Source:      datapersistence.SourceDescriptor{Kind: datapersistence.SourceSecret, Namespace: "web", Name: "deleted-tls", DataKey: v1.TLSCertKey},
+		CheckResult: datapersistence.WithinRange,
+	})
+	writer := NewWriter(clientset, false)
+	if failures := writer.WriteBack(context.Background(), results); failures != 0 {
+		t.Fatalf("expected no failures, got %d", failures)
+	}
+	if len(patches) != 1 {
+		t.Fatalf("expected a single patch, and none for the deleted secret, got %d", len(patches))
+	}
+	if patches[0].GetPatchType() != types.ApplyPatchType || patches[0].GetNamespace() != "web" || patches[0].GetName() != "web-tls" {
+		t.Errorf("expected a server-side apply of web/web-tls, got %s of %s/%s", patches[0].GetPatchType(), patches[0].GetNamespace(), patches[0].GetName())
+	}
+
+	var applied map[string]interface{}
+	if err := json.Unmarshal(patches[0].GetPatch(), &applied); err != nil {
+		t.Fatal(err)
+	}
+	if _, haveData := applied["data"]; haveData {
+		t.Errorf("the apply configuration must never include data")
+	}
+	metadata := applied["metadata"].(map[string]interface{})
+	labels := metadata["labels"].(map[string]interface{})
+	if labels[StatusLabel] != "near-expiry" || labels[SeverityLabel] != "warning" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	annotations := metadata["annotations"].(map[string]interface{})
+	if annotations[FindingsAnnotation] != "NearExpiry" || annotations[FingerprintAnnotation] != "abcd" || annotations[LastCheckedAnnotation] != "2026-10-19T09:00:00Z" {
+		t.Errorf("unexpected annotations %v", annotations)
+	}
+}
+
+func TestApplyOptions(t *testing.T) {
+	opts := NewWriter(nil, true).applyOptions()
+	if opts.FieldManager != FieldManager || !opts.Force || len(opts.DryRun) != 1 {
+		t.Errorf("unexpected apply options %+v", opts)
+	}
+	if len(NewWriter(nil, false).applyOptions().DryRun) != 0 {
+		t.Errorf("dry run should only be set when asked for")
+	}
+}
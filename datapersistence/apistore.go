@@ -0,0 +1,352 @@
+package datapersistence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	ReportGroup   = "certchecker.guardian.co.uk"
+	ReportVersion = "v1alpha1"
+	//every report that certchecker writes has this name, there is one per namespace plus the cluster-wide summary
+	ReportName = "certchecker"
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "certchecker"
+
+	ConditionHealthy  = "Healthy"
+	ConditionExpiring = "Expiring"
+	ConditionExpired  = "Expired"
+)
+
+var CertificateReportResource = schema.GroupVersionResource{Group: ReportGroup, Version: ReportVersion, Resource: "certificatereports"}
+var ClusterCertificateReportResource = schema.GroupVersionResource{Group: ReportGroup, Version: ReportVersion, Resource: "clustercertificatereports"}
+
+/**
+counts of the certificates in a report
+*/
+type ReportSummary struct {
+	Total    int `json:"total"`
+	Healthy  int `json:"healthy"`
+	Warning  int `json:"warning"`
+	Critical int `json:"critical"`
+	Expiring int `json:"expiring"`
+	Expired  int `json:"expired"`
+}
+
+func (s *ReportSummary) add(rec *CheckRecord) {
+	s.Total++
+	switch rec.Severity {
+	case SeverityCritical:
+		s.Critical++
+	case SeverityWarning:
+		s.Warning++
+	default:
+		s.Healthy++
+	}
+	switch rec.ExpiryResult() {
+	case NearExpiry, Critical:
+		s.Expiring++
+	case AfterExpiry:
+		s.Expired++
+	}
+}
+
+/**
+the status of a namespaced CertificateReport, holding the results for the certificates in that namespace
+*/
+type CertificateReportStatus struct {
+	CheckedAt    time.Time           `json:"checkedAt"`
+	AsOf         *time.Time          `json:"asOf,omitempty"`
+	Cluster      string              `json:"cluster,omitempty"`
+	Summary      ReportSummary       `json:"summary"`
+	Results      []CheckRecord       `json:"results"`
+	Probes       []ProbeRecord       `json:"probes,omitempty"`
+	GatewayTLS   []GatewayTLSRecord  `json:"gatewayTLS,omitempty"`
+	Remediations []RemediationRecord `json:"remediations,omitempty"`
+	Conditions   []metav1.Condition  `json:"conditions,omitempty"`
+}
+
+type NamespaceSummary struct {
+	Namespace string        `json:"namespace"`
+	Summary   ReportSummary `json:"summary"`
+}
+
+/**
+the status of the cluster-scoped ClusterCertificateReport.  This summarises every namespace, and holds the results
+that don't belong to any namespace and the duplicates, which can span namespaces
+*/
+type ClusterCertificateReportStatus struct {
+	CheckedAt    time.Time           `json:"checkedAt"`
+	AsOf         *time.Time          `json:"asOf,omitempty"`
+	Cluster      string              `json:"cluster,omitempty"`
+	Clusters     []ClusterStatus     `json:"clusters,omitempty"`
+	Summary      ReportSummary       `json:"summary"`
+	Namespaces   []NamespaceSummary  `json:"namespaces"`
+	Results      []CheckRecord       `json:"results,omitempty"`
+	Probes       []ProbeRecord       `json:"probes,omitempty"`
+	GatewayTLS   []GatewayTLSRecord  `json:"gatewayTLS,omitempty"`
+	Duplicates   *Duplicates         `json:"duplicates,omitempty"`
+	Remediations []RemediationRecord `json:"remediations,omitempty"`
+	Conditions   []metav1.Condition  `json:"conditions,omitempty"`
+}
+
+/**
+sets the standard conditions from the summary, keeping the transition times of any that haven't changed
+*/
+func setConditions(conditions *[]metav1.Condition, summary *ReportSummary, generation int64) {
+	healthy := metav1.Condition{Type: ConditionHealthy, Status: metav1.ConditionTrue, Reason: "AllCertificatesValid", Message: fmt.Sprintf("%d certificates have no problems", summary.Total)}
+	if summary.Warning+summary.Critical > 0 {
+		healthy.Status = metav1.ConditionFalse
+		healthy.Reason = "CertificatesNeedAttention"
+		healthy.Message = fmt.Sprintf("%d critical and %d warning certificates", summary.Critical, summary.Warning)
+	}
+	expiring := metav1.Condition{Type: ConditionExpiring, Status: metav1.ConditionFalse, Reason: "NoneExpiring", Message: "no certificates are near expiry"}
+	if summary.Expiring > 0 {
+		expiring.Status = metav1.ConditionTrue
+		expiring.Reason = "CertificatesNearExpiry"
+		expiring.Message = fmt.Sprintf("%d certificates are near expiry", summary.Expiring)
+	}
+	expired := metav1.Condition{Type: ConditionExpired, Status: metav1.ConditionFalse, Reason: "NoneExpired", Message: "no certificates have expired"}
+	if summary.Expired > 0 {
+		expired.Status = metav1.ConditionTrue
+		expired.Reason = "CertificatesExpired"
+		expired.Message = fmt.Sprintf("%d certificates have expired", summary.Expired)
+	}
+	for _, condition := range []metav1.Condition{healthy, expiring, expired} {
+		condition.ObservedGeneration = generation
+		meta.SetStatusCondition(conditions, condition)
+	}
+}
+
+func toUnstructuredStatus(status interface{}) (map[string]interface{}, error) {
+	content, marshalErr := json.Marshal(status)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	var result map[string]interface{}
+	return result, json.Unmarshal(content, &result)
+}
+
+func fromUnstructuredStatus(obj *unstructured.Unstructured, status interface{}) error {
+	content, marshalErr := json.Marshal(obj.Object["status"])
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return json.Unmarshal(content, status)
+}
+
+/**
+creates the named report if it doesn't exist yet and then sets its status.  `update` is given the existing status
+(if there is one) to fill in, so that condition transition times are kept
+*/
+func applyReport(ctx context.Context, client dynamic.ResourceInterface, kind string, status interface{}, update func(generation int64)) error {
+	existing, getErr := client.Get(ctx, ReportName, metav1.GetOptions{})
+	if errors.IsNotFound(getErr) {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(ReportGroup + "/" + ReportVersion)
+		obj.SetKind(kind)
+		obj.SetName(ReportName)
+		obj.SetLabels(map[string]string{managedByLabel: managedByValue})
+		var createErr error
+		existing, createErr = client.Create(ctx, obj, metav1.CreateOptions{})
+		if createErr != nil {
+			return createErr
+		}
+	} else if getErr != nil {
+		return getErr
+	} else if readErr := fromUnstructuredStatus(existing, status); readErr != nil {
+		log.Printf("WARNING Could not read the existing status of %s, replacing it: %s", kind, readErr)
+	}
+
+	update(existing.GetGeneration())
+	statusContent, convertErr := toUnstructuredStatus(status)
+	if convertErr != nil {
+		return convertErr
+	}
+	existing.Object["status"] = statusContent
+	_, updateErr := client.UpdateStatus(ctx, existing, metav1.UpdateOptions{})
+	return updateErr
+}
+
+/**
+writes the report into the cluster as a CertificateReport in each namespace that has results, plus a
+ClusterCertificateReport summarising them all.  CertificateReports from earlier runs in namespaces that no longer
+have any certificates are deleted.
+*/
+func WriteReportToAPI(ctx context.Context, client dynamic.Interface, report *PersistenceRecord) error {
+	byNamespace := make(map[string]*CertificateReportStatus)
+	clusterStatus := ClusterCertificateReportStatus{}
+
+	newNamespaceStatus := func(namespace string) *CertificateReportStatus {
+		if byNamespace[namespace] == nil {
+			byNamespace[namespace] = &CertificateReportStatus{Results: make([]CheckRecord, 0)}
+		}
+		return byNamespace[namespace]
+	}
+	for _, rec := range report.Results {
+		if rec.Source.Namespace == "" {
+			clusterStatus.Results = append(clusterStatus.Results, rec)
+			clusterStatus.Summary.add(&rec)
+			continue
+		}
+		status := newNamespaceStatus(rec.Source.Namespace)
+		status.Results = append(status.Results, rec)
+		status.Summary.add(&rec)
+		clusterStatus.Summary.add(&rec)
+	}
+	for _, probe := range report.Probes {
+		if probe.Source.Namespace == "" {
+			clusterStatus.Probes = append(clusterStatus.Probes, probe)
+		} else {
+			status := newNamespaceStatus(probe.Source.Namespace)
+			status.Probes = append(status.Probes, probe)
+		}
+	}
+	for _, listener := range report.GatewayTLS {
+		if listener.Source.Namespace == "" {
+			clusterStatus.GatewayTLS = append(clusterStatus.GatewayTLS, listener)
+		} else {
+			status := newNamespaceStatus(listener.Source.Namespace)
+			status.GatewayTLS = append(status.GatewayTLS, listener)
+		}
+	}
+	for _, remediation := range report.Remediations {
+		if remediation.Source.Namespace == "" {
+			clusterStatus.Remediations = append(clusterStatus.Remediations, remediation)
+		} else {
+			status := newNamespaceStatus(remediation.Source.Namespace)
+			status.Remediations = append(status.Remediations, remediation)
+		}
+	}
+
+	namespaces := make([]string, 0, len(byNamespace))
+	for namespace := range byNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	failures := 0
+	for _, namespace := range namespaces {
+		fresh := byNamespace[namespace]
+		var status CertificateReportStatus
+		err := applyReport(ctx, client.Resource(CertificateReportResource).Namespace(namespace), "CertificateReport", &status, func(generation int64) {
+			conditions := status.Conditions
+			status = *fresh
+			status.CheckedAt = report.CheckedAt
+			status.AsOf = report.AsOf
+			status.Cluster = report.Cluster
+			status.Conditions = conditions
+			setConditions(&status.Conditions, &status.Summary, generation)
+		})
+		if err != nil {
+			log.Printf("ERROR Could not write the CertificateReport for %s: %s", namespace, err)
+			failures++
+		}
+		clusterStatus.Namespaces = append(clusterStatus.Namespaces, NamespaceSummary{Namespace: namespace, Summary: fresh.Summary})
+	}
+
+	var status ClusterCertificateReportStatus
+	clusterErr := applyReport(ctx, client.Resource(ClusterCertificateReportResource), "ClusterCertificateReport", &status, func(generation int64) {
+		conditions := status.Conditions
+		status = clusterStatus
+		status.CheckedAt = report.CheckedAt
+		status.AsOf = report.AsOf
+		status.Cluster = report.Cluster
+		status.Clusters = report.Clusters
+		status.Duplicates = report.Duplicates
+		status.Conditions = conditions
+		setConditions(&status.Conditions, &status.Summary, generation)
+	})
+	if clusterErr != nil {
+		return fmt.Errorf("could not write the ClusterCertificateReport: %s", clusterErr)
+	}
+
+	deleteStaleReports(ctx, client, byNamespace)
+	if failures > 0 {
+		return fmt.Errorf("could not write %d of %d CertificateReports", failures, len(namespaces))
+	}
+	return nil
+}
+
+func managedReports(ctx context.Context, client dynamic.Interface) (*unstructured.UnstructuredList, error) {
+	return client.Resource(CertificateReportResource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue,
+	})
+}
+
+func deleteStaleReports(ctx context.Context, client dynamic.Interface, current map[string]*CertificateReportStatus) {
+	existing, listErr := managedReports(ctx, client)
+	if listErr != nil {
+		log.Printf("ERROR Could not list CertificateReports to tidy up: %s", listErr)
+		return
+	}
+	for _, item := range existing.Items {
+		if item.GetName() != ReportName || current[item.GetNamespace()] != nil {
+			continue
+		}
+		deleteErr := client.Resource(CertificateReportResource).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+		if deleteErr != nil && !errors.IsNotFound(deleteErr) {
+			log.Printf("ERROR Could not delete stale CertificateReport in %s: %s", item.GetNamespace(), deleteErr)
+		}
+	}
+}
+
+/**
+reads the reports that WriteReportToAPI wrote back into a single PersistenceRecord, so that it can be served just
+like a report from a file.  Returns ErrNoReports if there is no ClusterCertificateReport
+*/
+func ReadReportFromAPI(ctx context.Context, client dynamic.Interface) (*PersistenceRecord, error) {
+	clusterObj, getErr := client.Resource(ClusterCertificateReportResource).Get(ctx, ReportName, metav1.GetOptions{})
+	if errors.IsNotFound(getErr) {
+		return nil, ErrNoReports
+	} else if getErr != nil {
+		return nil, getErr
+	}
+	var clusterStatus ClusterCertificateReportStatus
+	if err := fromUnstructuredStatus(clusterObj, &clusterStatus); err != nil {
+		return nil, err
+	}
+
+	report := &PersistenceRecord{
+		CheckedAt:    clusterStatus.CheckedAt,
+		AsOf:         clusterStatus.AsOf,
+		Cluster:      clusterStatus.Cluster,
+		Clusters:     clusterStatus.Clusters,
+		Results:      append(make([]CheckRecord, 0), clusterStatus.Results...),
+		Probes:       clusterStatus.Probes,
+		GatewayTLS:   clusterStatus.GatewayTLS,
+		Duplicates:   clusterStatus.Duplicates,
+		Remediations: clusterStatus.Remediations,
+	}
+
+	reports, listErr := managedReports(ctx, client)
+	if listErr != nil {
+		return nil, listErr
+	}
+	sort.Slice(reports.Items, func(i, j int) bool {
+		return reports.Items[i].GetNamespace() < reports.Items[j].GetNamespace()
+	})
+	for i := range reports.Items {
+		var status CertificateReportStatus
+		if err := fromUnstructuredStatus(&reports.Items[i], &status); err != nil {
+			log.Printf("ERROR Could not read CertificateReport in %s: %s", reports.Items[i].GetNamespace(), err)
+			continue
+		}
+		report.Results = append(report.Results, status.Results...)
+		report.Probes = append(report.Probes, status.Probes...)
+		report.GatewayTLS = append(report.GatewayTLS, status.GatewayTLS...)
+		report.Remediations = append(report.Remediations, status.Remediations...)
+	}
+	return report, nil
+}
@@ -0,0 +1,150 @@
+package datapersistence
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"testing"
+	"time"
+)
+
+func newFakeReportClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		CertificateReportResource:        "CertificateReportList",
+		ClusterCertificateReportResource: "ClusterCertificateReportList",
+	}, objects...)
+}
+
+func apiTestReport(checkedAt time.Time) *PersistenceRecord {
+	return &PersistenceRecord{
+		CheckedAt: checkedAt,
+		Cluster:   "prod",
+		Results: []CheckRecord{
+			{Namespace: "web", Source: SourceDescriptor{Kind: SourceSecret, Namespace: "web", Name: "web-tls"}, CheckResult: NearExpiry, Severity: SeverityWarning},
+			{Namespace: "web", Source: SourceDescriptor{Kind: SourceSecret, Namespace: "web", Name: "api-tls"}, CheckResult: WithinRange},
+			{Namespace: "payments", Source: SourceDescriptor{Kind: SourceSecret, Namespace: "payments", Name: "card-tls"}, CheckResult: AfterExpiry, Severity: SeverityCritical},
+		},
+		Probes: []ProbeRecord{
+			{Source: SourceDescriptor{Kind: SourceIngress, Namespace: "web", Name: "web"}, CheckResult: WithinRange},
+		},
+		GatewayTLS: []GatewayTLSRecord{
+			{Source: SourceDescriptor{Kind: SourceGateway, Namespace: "web", Name: "public", DataKey: "https"}, CheckResult: Errored, Severity: SeverityCritical},
+		},
+		Remediations: []RemediationRecord{
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "payments", Name: "card-tls"}, Action: "reissue", Details: "backed up to card-tls-backup"},
+		},
+		Duplicates: &Duplicates{
+			Copies: []DuplicateGroup{{Key: "abc123", Entries: []DuplicateEntry{
+				{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "web", Name: "web-tls"}, Fingerprint: "abc123"},
+				{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "payments", Name: "web-tls-copy"}, Fingerprint: "abc123"},
+			}}},
+		},
+	}
+}
+
+func TestWriteAndReadReportFromAPI(t *testing.T) {
+	ctx := context.Background()
+	stale := &unstructured.Unstructured{}
+	stale.SetAPIVersion(ReportGroup + "/" + ReportVersion)
+	stale.SetKind("CertificateReport")
+	stale.SetNamespace("retired")
+	stale.SetName(ReportName)
+	stale.SetLabels(map[string]string{managedByLabel: managedByValue})
+	client := newFakeReportClient(stale)
+
+	checkedAt := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	if err := WriteReportToAPI(ctx, client, apiTestReport(checkedAt)); err != nil {
+		t.Fatal(err)
+	}
+
+	webObj, getErr := client.Resource(CertificateReportResource).Namespace("web").Get(ctx, ReportName, metav1.GetOptions{})
+	if getErr != nil {
+		t.Fatal(getErr)
+	}
+	var web CertificateReportStatus
+	if err := fromUnstructuredStatus(webObj, &web); err != nil {
+		t.Fatal(err)
+	}
+	if web.Summary.Total != 2 || web.Summary.Expiring != 1 || len(web.Probes) != 1 || len(web.GatewayTLS) != 1 {
+		t.Errorf("unexpected web summary %+v with %d probes and %d gateway listeners", web.Summary, len(web.Probes), len(web.GatewayTLS))
+	}
+	if !meta.IsStatusConditionTrue(web.Conditions, ConditionExpiring) || !meta.IsStatusConditionFalse(web.Conditions, ConditionHealthy) {
+		t.Errorf("unexpected web conditions %+v", web.Conditions)
+	}
+
+	clusterObj, getErr := client.Resource(ClusterCertificateReportResource).Get(ctx, ReportName, metav1.GetOptions{})
+	if getErr != nil {
+		t.Fatal(getErr)
+	}
+	var cluster ClusterCertificateReportStatus
+	fromUnstructuredStatus(clusterObj, &cluster)
+	if cluster.Summary.Total != 3 || cluster.Summary.Expired != 1 || len(cluster.Namespaces) != 2 {
+		t.Errorf("unexpected cluster summary %+v over %v", cluster.Summary, cluster.Namespaces)
+	}
+	healthySince := meta.FindStatusCondition(cluster.Conditions, ConditionHealthy).LastTransitionTime
+
+	if _, err := client.Resource(CertificateReportResource).Namespace("retired").Get(ctx, ReportName, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the stale report in a namespace with no certs to be deleted")
+	}
+
+	report, readErr := ReadReportFromAPI(ctx, client)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if !report.CheckedAt.Equal(checkedAt) || report.Cluster != "prod" || len(report.Results) != 3 || len(report.Probes) != 1 || len(report.GatewayTLS) != 1 {
+		t.Errorf("report did not round-trip: %+v", report)
+	}
+	if len(report.Remediations) != 1 || report.Remediations[0].Details != "backed up to card-tls-backup" {
+		t.Errorf("remediations did not round-trip: %+v", report.Remediations)
+	}
+	if report.Duplicates == nil || len(report.Duplicates.Copies) != 1 || len(report.Duplicates.Copies[0].Entries) != 2 {
+		t.Errorf("duplicates did not round-trip: %+v", report.Duplicates)
+	}
+	if report.Results[0].Source.Namespace != "payments" || report.Results[0].CheckResult != AfterExpiry {
+		t.Errorf("expected results ordered by namespace, got %+v", report.Results[0])
+	}
+
+	//writing the same state again should keep the condition transition times
+	time.Sleep(1100 * time.Millisecond)
+	if err := WriteReportToAPI(ctx, client, apiTestReport(checkedAt.Add(24*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	clusterObj, _ = client.Resource(ClusterCertificateReportResource).Get(ctx, ReportName, metav1.GetOptions{})
+	fromUnstructuredStatus(clusterObj, &cluster)
+	if !meta.FindStatusCondition(cluster.Conditions, ConditionHealthy).LastTransitionTime.Equal(&healthySince) {
+		t.Errorf("an unchanged condition should keep its transition time")
+	}
+}
+
+func TestReadReportFromAPINoReports(t *testing.T) {
+	if _, err := ReadReportFromAPI(context.Background(), newFakeReportClient()); err != ErrNoReports {
+		t.Errorf("expected ErrNoReports, got %v", err)
+	}
+}
+
+func TestReportSummaryCountsExpiryFindings(t *testing.T) {
+	var summary ReportSummary
+	summary.add(&CheckRecord{
+		CheckResult: HasCriticalFindings,
+		Severity:    SeverityCritical,
+		Findings: []Finding{
+			{Code: ExpiredFinding, Severity: SeverityCritical, Result: ResultOf(AfterExpiry)},
+			{Code: "WeakRSAKey", Severity: SeverityCritical},
+		},
+	})
+	summary.add(&CheckRecord{
+		CheckResult: HasCriticalFindings,
+		Severity:    SeverityCritical,
+		Findings: []Finding{
+			{Code: NearExpiryFinding, Severity: SeverityWarning, Result: ResultOf(NearExpiry)},
+			{Code: "KeyMismatch", Severity: SeverityCritical},
+		},
+	})
+	if summary.Expired != 1 || summary.Expiring != 1 || summary.Critical != 2 {
+		t.Errorf("expected the expiry findings to be counted despite the critical findings, got %+v", summary)
+	}
+}
@@ -0,0 +1,84 @@
+package datapersistence
+
+import (
+	"sort"
+	"time"
+)
+
+// UnusedFinding is the code of the finding given to a secret that nothing in its cluster uses
+const UnusedFinding = "Unused"
+
+/**
+a secret and everything that uses it.  ValidUntil and Result are those of the soonest-expiring cert in the secret
+*/
+type SecretUsage struct {
+	Cluster    string           `json:"cluster,omitempty"`
+	Namespace  string           `json:"namespace"`
+	Name       string           `json:"name"`
+	Owner      string           `json:"owner,omitempty"`
+	ValidUntil time.Time        `json:"validUntil"`
+	Result     ValidationResult `json:"result"`
+	Consumers  []Consumer       `json:"consumers"`
+	Unused     bool             `json:"unused"`
+}
+
+func hasFinding(rec *CheckRecord, code string) bool {
+	for _, finding := range rec.Findings {
+		if finding.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+lists each secret in the report with the workloads, ingresses and gateways that use it, sorted by cluster, namespace
+and name.  If `unusedOnly` is set only the secrets that were found to be unused are listed, and if `namespace` is not
+empty only the secrets in that namespace are.  A secret is only marked unused if the scan that produced the report
+looked for consumers and could see all of them.
+*/
+func BuildSecretUsage(report *PersistenceRecord, unusedOnly bool, namespace string) []SecretUsage {
+	byKey := make(map[string]*SecretUsage)
+	keys := make([]string, 0)
+
+	for i := range report.Results {
+		rec := &report.Results[i]
+		if rec.Source.Kind != SourceSecret || (namespace != "" && rec.Source.Namespace != namespace) {
+			continue
+		}
+		key := rec.Cluster + "/" + rec.Source.Namespace + "/" + rec.Source.Name
+		usage, existing := byKey[key]
+		if !existing {
+			usage = &SecretUsage{
+				Cluster:   rec.Cluster,
+				Namespace: rec.Source.Namespace,
+				Name:      rec.Source.Name,
+				Owner:     rec.Owner,
+				Result:    rec.CheckResult,
+				Consumers: rec.Consumers,
+			}
+			if usage.Consumers == nil {
+				usage.Consumers = make([]Consumer, 0)
+			}
+			byKey[key] = usage
+			keys = append(keys, key)
+		}
+		if !rec.ValidUntil.IsZero() && (usage.ValidUntil.IsZero() || rec.ValidUntil.Before(usage.ValidUntil)) {
+			usage.ValidUntil = rec.ValidUntil
+			usage.Result = rec.CheckResult
+		}
+		if hasFinding(rec, UnusedFinding) {
+			usage.Unused = true
+		}
+	}
+
+	sort.Strings(keys)
+	usages := make([]SecretUsage, 0, len(keys))
+	for _, key := range keys {
+		if unusedOnly && !byKey[key].Unused {
+			continue
+		}
+		usages = append(usages, *byKey[key])
+	}
+	return usages
+}
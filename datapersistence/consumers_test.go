@@ -0,0 +1,40 @@
+package datapersistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildSecretUsage(t *testing.T) {
+	unused := []Finding{{Code: UnusedFinding, Severity: SeverityInfo}}
+	web := []Consumer{{Kind: "Deployment", Namespace: "web", Name: "frontend"}, {Kind: "Ingress", Namespace: "web", Name: "frontend"}}
+	report := &PersistenceRecord{
+		Results: []CheckRecord{
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "web", Name: "web-tls", DataKey: "tls.crt"}, ValidUntil: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), CheckResult: WithinRange, Consumers: web},
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "web", Name: "web-tls", DataKey: "ca.crt"}, ValidUntil: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), CheckResult: NearExpiry, Consumers: web},
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "legacy", Name: "old-tls"}, ValidUntil: time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC), Findings: unused},
+			{Source: SourceDescriptor{Kind: SourceFile, Name: "/etc/ssl/ca.pem"}, ValidUntil: time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	usages := BuildSecretUsage(report, false, "")
+	if len(usages) != 2 {
+		t.Fatalf("expected one entry per secret, got %+v", usages)
+	}
+	if usages[0].Name != "old-tls" || !usages[0].Unused || usages[0].Consumers == nil {
+		t.Errorf("expected the unused legacy secret first, got %+v", usages[0])
+	}
+	if usages[1].Name != "web-tls" || usages[1].Unused || len(usages[1].Consumers) != 2 {
+		t.Errorf("expected web-tls with its consumers, got %+v", usages[1])
+	}
+	if usages[1].Result != NearExpiry || !usages[1].ValidUntil.Equal(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected web-tls to report its soonest-expiring cert, got %s %s", usages[1].Result, usages[1].ValidUntil)
+	}
+
+	if unusedOnly := BuildSecretUsage(report, true, ""); len(unusedOnly) != 1 || unusedOnly[0].Name != "old-tls" {
+		t.Errorf("expected only old-tls to be unused, got %+v", unusedOnly)
+	}
+	if inWeb := BuildSecretUsage(report, false, "web"); len(inWeb) != 1 || inWeb[0].Name != "web-tls" {
+		t.Errorf("expected only web-tls in the web namespace, got %+v", inWeb)
+	}
+}
@@ -0,0 +1,164 @@
+package datapersistence
+
+import (
+	"sort"
+	"time"
+)
+
+/**
+one place that a duplicated certificate was found.  Stale is set on the copies of an identity that expire before the
+newest copy does
+*/
+type DuplicateEntry struct {
+	Cluster     string           `json:"cluster,omitempty"`
+	Source      SourceDescriptor `json:"source"`
+	Identity    string           `json:"identity"`
+	Fingerprint string           `json:"fingerprint"`
+	ValidUntil  time.Time        `json:"validUntil"`
+	Stale       bool             `json:"stale,omitempty"`
+}
+
+/**
+certificates that share a Key, which is a fingerprint, an identity or a public key hash depending on the group
+*/
+type DuplicateGroup struct {
+	Key     string           `json:"key"`
+	Entries []DuplicateEntry `json:"entries"`
+}
+
+/**
+- Copies: the same certificate (by fingerprint) stored in more than one place
+- StaleCopies: the same identity (subject and SANs) stored in more than one place with different expiry dates,
+  usually because the cert was renewed and some of its copies weren't updated
+- ReusedKeys: the same key pair used by certificates with different identities
+*/
+type Duplicates struct {
+	Copies      []DuplicateGroup `json:"copies"`
+	StaleCopies []DuplicateGroup `json:"staleCopies"`
+	ReusedKeys  []DuplicateGroup `json:"reusedKeys"`
+}
+
+/**
+where a record's certificate is stored.  Several records can share a location, e.g. the same cert in a secret's
+tls.crt and its keystore
+*/
+func locationOf(rec *CheckRecord) string {
+	return rec.Cluster + "/" + string(rec.Source.Kind) + "/" + rec.Source.Namespace + "/" + rec.Source.Name
+}
+
+/**
+groups the records by `key`, keeping one record per location in each group, and returns the groups in key order
+*/
+func groupRecords(results []CheckRecord, key func(*CheckRecord) string) ([]string, map[string][]*CheckRecord) {
+	groups := make(map[string][]*CheckRecord)
+	seen := make(map[string]bool)
+	for i := range results {
+		rec := &results[i]
+		k := key(rec)
+		if k == "" || seen[k+"|"+locationOf(rec)] {
+			continue
+		}
+		seen[k+"|"+locationOf(rec)] = true
+		groups[k] = append(groups[k], rec)
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, groups
+}
+
+func entryFor(rec *CheckRecord) DuplicateEntry {
+	return DuplicateEntry{
+		Cluster:     rec.Cluster,
+		Source:      rec.Source,
+		Identity:    rec.Identity,
+		Fingerprint: rec.Fingerprint,
+		ValidUntil:  rec.ValidUntil,
+	}
+}
+
+func countDistinct(records []*CheckRecord, value func(*CheckRecord) string) int {
+	distinct := make(map[string]bool)
+	for _, rec := range records {
+		distinct[value(rec)] = true
+	}
+	return len(distinct)
+}
+
+/**
+looks for certificates that appear more than once in the results.  CA certificates are left out, as it is normal for
+the same CA bundle to be copied everywhere it is trusted, as are unreadable ones, which have no fingerprint.  Within
+each group the entries are sorted by cluster, namespace and name
+*/
+func FindDuplicates(results []CheckRecord) *Duplicates {
+	duplicates := &Duplicates{
+		Copies:      make([]DuplicateGroup, 0),
+		StaleCopies: make([]DuplicateGroup, 0),
+		ReusedKeys:  make([]DuplicateGroup, 0),
+	}
+	onlyLeaves := func(value func(*CheckRecord) string) func(*CheckRecord) string {
+		return func(rec *CheckRecord) string {
+			if rec.IsCA {
+				return ""
+			}
+			return value(rec)
+		}
+	}
+
+	keys, byFingerprint := groupRecords(results, onlyLeaves(func(rec *CheckRecord) string { return rec.Fingerprint }))
+	for _, fingerprint := range keys {
+		if len(byFingerprint[fingerprint]) > 1 {
+			duplicates.Copies = append(duplicates.Copies, makeGroup(fingerprint, byFingerprint[fingerprint]))
+		}
+	}
+
+	keys, byIdentity := groupRecords(results, onlyLeaves(func(rec *CheckRecord) string { return rec.Identity }))
+	for _, identity := range keys {
+		records := byIdentity[identity]
+		if countDistinct(records, func(rec *CheckRecord) string { return rec.ValidUntil.String() }) < 2 {
+			continue
+		}
+		group := makeGroup(identity, records)
+		newest := time.Time{}
+		for _, entry := range group.Entries {
+			if entry.ValidUntil.After(newest) {
+				newest = entry.ValidUntil
+			}
+		}
+		for j := range group.Entries {
+			group.Entries[j].Stale = group.Entries[j].ValidUntil.Before(newest)
+		}
+		duplicates.StaleCopies = append(duplicates.StaleCopies, group)
+	}
+
+	keys, byKey := groupRecords(results, onlyLeaves(func(rec *CheckRecord) string { return rec.PublicKeyHash }))
+	for _, keyHash := range keys {
+		records := byKey[keyHash]
+		//renewing a cert without rotating its key is common (and has long been cert-manager's default), so only
+		//keys shared between different identities count
+		if countDistinct(records, func(rec *CheckRecord) string { return rec.Identity }) > 1 {
+			duplicates.ReusedKeys = append(duplicates.ReusedKeys, makeGroup(keyHash, records))
+		}
+	}
+	return duplicates
+}
+
+func makeGroup(key string, records []*CheckRecord) DuplicateGroup {
+	group := DuplicateGroup{Key: key, Entries: make([]DuplicateEntry, 0, len(records))}
+	for _, rec := range records {
+		group.Entries = append(group.Entries, entryFor(rec))
+	}
+	sort.SliceStable(group.Entries, func(i, j int) bool {
+		a, b := group.Entries[i], group.Entries[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Source.Namespace != b.Source.Namespace {
+			return a.Source.Namespace < b.Source.Namespace
+		}
+		return a.Source.Name < b.Source.Name
+	})
+	return group
+}
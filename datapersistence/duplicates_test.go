@@ -0,0 +1,67 @@
+package datapersistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	renewed := time.Date(2027, 1, 10, 0, 0, 0, 0, time.UTC)
+	old := time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC)
+	wildcard := "CN=*.example.com [DNS:*.example.com]"
+	secret := func(namespace string, name string, dataKey string) SourceDescriptor {
+		return SourceDescriptor{Kind: SourceSecret, Namespace: namespace, Name: name, DataKey: dataKey}
+	}
+	results := []CheckRecord{
+		//the renewed wildcard, copied into two namespaces, once with a keystore alongside it
+		{Source: secret("web", "wildcard-tls", "tls.crt"), Fingerprint: "new", Identity: wildcard, PublicKeyHash: "key-1", ValidUntil: renewed},
+		{Source: secret("web", "wildcard-tls", "keystore.jks"), Fingerprint: "new", Identity: wildcard, PublicKeyHash: "key-1", ValidUntil: renewed},
+		{Source: secret("shop", "wildcard-tls", "tls.crt"), Fingerprint: "new", Identity: wildcard, PublicKeyHash: "key-1", ValidUntil: renewed},
+		//a copy that was missed when it was renewed
+		{Source: secret("legacy", "wildcard-tls", "tls.crt"), Fingerprint: "old", Identity: wildcard, PublicKeyHash: "key-1", ValidUntil: old},
+		//a different cert made with the same key
+		{Source: secret("api", "api-tls", "tls.crt"), Fingerprint: "api", Identity: "CN=api.example.org [DNS:api.example.org]", PublicKeyHash: "key-1", ValidUntil: renewed},
+		//CA bundles are expected to be everywhere
+		{Source: secret("web", "wildcard-tls", "ca.crt"), Fingerprint: "ca", Identity: "CN=Example CA []", PublicKeyHash: "ca-key", IsCA: true},
+		{Source: secret("shop", "wildcard-tls", "ca.crt"), Fingerprint: "ca", Identity: "CN=Example CA []", PublicKeyHash: "ca-key", IsCA: true},
+		{Source: secret("broken", "broken-tls", "tls.crt"), CheckResult: Errored},
+		{Source: secret("broken", "other-tls", "tls.crt"), CheckResult: Errored},
+	}
+
+	duplicates := FindDuplicates(results)
+
+	if len(duplicates.Copies) != 1 || duplicates.Copies[0].Key != "new" || len(duplicates.Copies[0].Entries) != 2 {
+		t.Fatalf("expected the renewed wildcard to be copied into 2 secrets, got %+v", duplicates.Copies)
+	}
+	if duplicates.Copies[0].Entries[0].Source.Namespace != "shop" {
+		t.Errorf("expected the copies to be sorted by namespace, got %+v", duplicates.Copies[0].Entries)
+	}
+
+	if len(duplicates.StaleCopies) != 1 || len(duplicates.StaleCopies[0].Entries) != 3 {
+		t.Fatalf("expected one group of stale copies across 3 secrets, got %+v", duplicates.StaleCopies)
+	}
+	for _, entry := range duplicates.StaleCopies[0].Entries {
+		if entry.Stale != (entry.Source.Namespace == "legacy") {
+			t.Errorf("expected only the legacy copy to be stale, got %+v", entry)
+		}
+	}
+
+	if len(duplicates.ReusedKeys) != 1 || duplicates.ReusedKeys[0].Key != "key-1" || len(duplicates.ReusedKeys[0].Entries) != 4 {
+		t.Errorf("expected key-1 to be reused by the wildcard and api certs, got %+v", duplicates.ReusedKeys)
+	}
+}
+
+func TestFindDuplicatesRenewedWithSameKey(t *testing.T) {
+	identity := "CN=www.example.com [DNS:www.example.com]"
+	results := []CheckRecord{
+		{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "web", Name: "www-tls"}, Fingerprint: "a", Identity: identity, PublicKeyHash: "key", ValidUntil: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "backup", Name: "www-tls"}, Fingerprint: "b", Identity: identity, PublicKeyHash: "key", ValidUntil: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	duplicates := FindDuplicates(results)
+	if len(duplicates.ReusedKeys) != 0 {
+		t.Errorf("renewing a cert with the same key should not count as reusing it, got %+v", duplicates.ReusedKeys)
+	}
+	if len(duplicates.StaleCopies) != 1 {
+		t.Errorf("expected the backup to be a stale copy, got %+v", duplicates.StaleCopies)
+	}
+}
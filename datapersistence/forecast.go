@@ -0,0 +1,166 @@
+package datapersistence
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ForecastPeriod string
+
+const (
+	ForecastWeek  ForecastPeriod = "week"
+	ForecastMonth ForecastPeriod = "month"
+)
+
+/**
+a single certificate in an expiry forecast. AutoRenewed is set when something (at present, cert-manager) will renew
+the cert without anybody having to do anything; the rest need manual action
+*/
+type ForecastEntry struct {
+	Cluster     string           `json:"cluster,omitempty"`
+	Source      SourceDescriptor `json:"source"`
+	Owner       string           `json:"owner,omitempty"`
+	ValidUntil  time.Time        `json:"validUntil"`
+	Severity    Severity         `json:"severity"`
+	AutoRenewed bool             `json:"autoRenewed"`
+}
+
+/**
+the certificates that expire between Start (inclusive) and End (exclusive)
+*/
+type ForecastBucket struct {
+	Label        string          `json:"label"`
+	Start        time.Time       `json:"start"`
+	End          time.Time       `json:"end"`
+	AutoRenewed  int             `json:"autoRenewed"`
+	Manual       int             `json:"manual"`
+	Certificates []ForecastEntry `json:"certificates"`
+}
+
+type Forecast struct {
+	CheckedAt time.Time        `json:"checkedAt"`
+	From      time.Time        `json:"from"`
+	Until     time.Time        `json:"until"`
+	Period    ForecastPeriod   `json:"period"`
+	Overdue   []ForecastEntry  `json:"overdue"`
+	Buckets   []ForecastBucket `json:"buckets"`
+}
+
+/**
+returns true if the certificate is managed by cert-manager, which will renew it automatically
+*/
+func IsAutoRenewed(rec *CheckRecord) bool {
+	owner := rec.Source.Owner
+	return owner != nil && owner.Kind == "Certificate" && strings.HasPrefix(owner.ApiVersion, "cert-manager.io/")
+}
+
+func ParseForecastPeriod(value string) (ForecastPeriod, error) {
+	switch ForecastPeriod(value) {
+	case "", ForecastMonth:
+		return ForecastMonth, nil
+	case ForecastWeek:
+		return ForecastWeek, nil
+	default:
+		return "", fmt.Errorf("'%s' is not a valid period, expected week or month", value)
+	}
+}
+
+/**
+returns the start of the period (in UTC) that `t` falls into.  Weeks start on Monday, as ISO weeks do
+*/
+func periodStart(t time.Time, period ForecastPeriod) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == ForecastWeek {
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	}
+	return day.AddDate(0, 0, 1-day.Day())
+}
+
+func nextPeriod(start time.Time, period ForecastPeriod) time.Time {
+	if period == ForecastWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func periodLabel(start time.Time, period ForecastPeriod) string {
+	if period == ForecastWeek {
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return start.Format("2006-01")
+}
+
+/**
+buckets the certificates in the report by the week or month that they expire in, for every period from the one
+containing `from` up to `until`.  Periods with nothing expiring are still included so that the forecast reads as a
+calendar.  Certs that had already expired at `from` are listed under Overdue, and certs that expire after `until` are
+left out.  Records that could not be read (and so have no expiry date) are skipped.
+*/
+func BuildForecast(report *PersistenceRecord, from time.Time, until time.Time, period ForecastPeriod) *Forecast {
+	forecast := &Forecast{
+		CheckedAt: report.CheckedAt,
+		From:      from,
+		Until:     until,
+		Period:    period,
+		Overdue:   make([]ForecastEntry, 0),
+		Buckets:   make([]ForecastBucket, 0),
+	}
+
+	for start := periodStart(from, period); start.Before(until); start = nextPeriod(start, period) {
+		forecast.Buckets = append(forecast.Buckets, ForecastBucket{
+			Label:        periodLabel(start, period),
+			Start:        start,
+			End:          nextPeriod(start, period),
+			Certificates: make([]ForecastEntry, 0),
+		})
+	}
+
+	for i := range report.Results {
+		rec := &report.Results[i]
+		if rec.ValidUntil.IsZero() || !rec.ValidUntil.Before(until) {
+			continue
+		}
+		entry := ForecastEntry{
+			Cluster:     rec.Cluster,
+			Source:      rec.Source,
+			Owner:       rec.Owner,
+			ValidUntil:  rec.ValidUntil,
+			Severity:    rec.Severity,
+			AutoRenewed: IsAutoRenewed(rec),
+		}
+		if rec.ValidUntil.Before(from) {
+			forecast.Overdue = append(forecast.Overdue, entry)
+			continue
+		}
+		for j := range forecast.Buckets {
+			bucket := &forecast.Buckets[j]
+			if !rec.ValidUntil.Before(bucket.End) {
+				continue
+			}
+			bucket.Certificates = append(bucket.Certificates, entry)
+			if entry.AutoRenewed {
+				bucket.AutoRenewed++
+			} else {
+				bucket.Manual++
+			}
+			break
+		}
+	}
+
+	for j := range forecast.Buckets {
+		sortEntries(forecast.Buckets[j].Certificates)
+	}
+	sortEntries(forecast.Overdue)
+	return forecast
+}
+
+func sortEntries(entries []ForecastEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ValidUntil.Before(entries[j].ValidUntil)
+	})
+}
@@ -0,0 +1,91 @@
+package datapersistence
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func forecastTestReport() *PersistenceRecord {
+	certManager := &OwnerReference{ApiVersion: "cert-manager.io/v1", Kind: "Certificate", Name: "web"}
+	return &PersistenceRecord{
+		CheckedAt: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+		Results: []CheckRecord{
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "web", Name: "web-tls", Owner: certManager}, ValidUntil: time.Date(2026, 11, 3, 0, 0, 0, 0, time.UTC)},
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "legacy", Name: "old-tls"}, ValidUntil: time.Date(2026, 11, 20, 0, 0, 0, 0, time.UTC)},
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "legacy", Name: "expired-tls"}, ValidUntil: time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)},
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "legacy", Name: "far-future"}, ValidUntil: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Source: SourceDescriptor{Kind: SourceSecret, Namespace: "legacy", Name: "unreadable"}, CheckResult: Errored},
+		},
+	}
+}
+
+func TestBuildForecastByMonth(t *testing.T) {
+	from := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	forecast := BuildForecast(forecastTestReport(), from, from.AddDate(0, 12, 0), ForecastMonth)
+
+	if len(forecast.Buckets) != 13 {
+		t.Fatalf("expected 13 monthly buckets (Oct to Oct), got %d", len(forecast.Buckets))
+	}
+	if forecast.Buckets[0].Label != "2026-10" || !forecast.Buckets[0].Start.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected first bucket %s starting %s", forecast.Buckets[0].Label, forecast.Buckets[0].Start)
+	}
+	november := forecast.Buckets[1]
+	if november.Label != "2026-11" || len(november.Certificates) != 2 {
+		t.Fatalf("expected 2 certs in 2026-11, got %d in %s", len(november.Certificates), november.Label)
+	}
+	if november.AutoRenewed != 1 || november.Manual != 1 {
+		t.Errorf("expected 1 auto-renewed and 1 manual, got %d and %d", november.AutoRenewed, november.Manual)
+	}
+	if !november.Certificates[0].AutoRenewed || november.Certificates[0].Source.Name != "web-tls" {
+		t.Errorf("expected the cert-manager cert first and auto-renewed, got %+v", november.Certificates[0])
+	}
+	if len(forecast.Overdue) != 1 || forecast.Overdue[0].Source.Name != "expired-tls" {
+		t.Errorf("expected the expired cert to be overdue, got %+v", forecast.Overdue)
+	}
+}
+
+func TestBuildForecastByWeek(t *testing.T) {
+	from := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	forecast := BuildForecast(forecastTestReport(), from, from.AddDate(0, 0, 28), ForecastWeek)
+
+	if len(forecast.Buckets) != 4 {
+		t.Fatalf("expected 4 weekly buckets, got %d", len(forecast.Buckets))
+	}
+	//3rd November 2026 is a Tuesday in ISO week 45
+	for _, bucket := range forecast.Buckets {
+		if bucket.Start.Weekday() != time.Monday {
+			t.Errorf("bucket %s should start on a Monday, starts %s", bucket.Label, bucket.Start)
+		}
+		if bucket.Label == "2026-W45" && len(bucket.Certificates) != 1 {
+			t.Errorf("expected 1 cert in 2026-W45, got %d", len(bucket.Certificates))
+		}
+	}
+}
+
+func TestWriteICalendar(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteICalendar(&buf, forecastTestReport()); err != nil {
+		t.Fatal(err)
+	}
+	content := buf.String()
+
+	if !strings.HasPrefix(content, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(content, "END:VCALENDAR\r\n") {
+		t.Errorf("calendar is not wrapped in VCALENDAR")
+	}
+	if count := strings.Count(content, "BEGIN:VEVENT"); count != 4 {
+		t.Errorf("expected 4 events (unreadable certs skipped), got %d", count)
+	}
+	if !strings.Contains(content, "DTSTART;VALUE=DATE:20261103\r\n") {
+		t.Errorf("expected an all-day event on 2026-11-03")
+	}
+	if !strings.Contains(content, "renewed by cert-manager") || !strings.Contains(content, "needs manual renewal") {
+		t.Errorf("expected events to say how the cert is renewed")
+	}
+	for _, line := range strings.Split(content, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is longer than 75 octets: %s", line)
+		}
+	}
+}
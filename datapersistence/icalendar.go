@@ -0,0 +1,95 @@
+package datapersistence
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const icalDateFormat = "20060102"
+const icalTimeFormat = "20060102T150405Z"
+
+/**
+escapes a TEXT value as RFC 5545 section 3.3.11 requires
+*/
+func icalEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
+
+/**
+writes a content line, folding it so that no line is longer than 75 octets (RFC 5545 section 3.1)
+*/
+func writeICalLine(w *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		//don't split a multi-byte character
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	w.WriteString(line + "\r\n")
+}
+
+/**
+a UID that stays the same for the same certificate across reports, so that calendar clients update the existing
+event rather than adding a new one each time
+*/
+func icalUID(rec *CheckRecord) string {
+	sum := sha1.Sum([]byte(rec.Cluster + "|" + rec.Source.String() + "|" + rec.ValidUntil.UTC().Format(time.RFC3339)))
+	return hex.EncodeToString(sum[:]) + "@k8s-certchecker"
+}
+
+/**
+writes an iCalendar feed with an all-day event on the expiry date of each certificate in the report
+*/
+func WriteICalendar(w io.Writer, report *PersistenceRecord) error {
+	out := bufio.NewWriter(w)
+	stamp := report.CheckedAt.UTC().Format(icalTimeFormat)
+
+	writeICalLine(out, "BEGIN:VCALENDAR")
+	writeICalLine(out, "VERSION:2.0")
+	writeICalLine(out, "PRODID:-//guardian//k8s-certchecker//EN")
+	writeICalLine(out, "CALSCALE:GREGORIAN")
+	writeICalLine(out, "METHOD:PUBLISH")
+	writeICalLine(out, "X-WR-CALNAME:Certificate expiries")
+
+	for i := range report.Results {
+		rec := &report.Results[i]
+		if rec.ValidUntil.IsZero() {
+			continue
+		}
+		renewal := "needs manual renewal"
+		if IsAutoRenewed(rec) {
+			renewal = "renewed by cert-manager"
+		}
+		expiryDay := rec.ValidUntil.UTC()
+		description := fmt.Sprintf("%s expires at %s (%s)", rec.Source, expiryDay.Format(time.RFC3339), renewal)
+		if rec.Cluster != "" {
+			description += "\nCluster: " + rec.Cluster
+		}
+		if rec.Owner != "" {
+			description += "\nOwner: " + rec.Owner
+		}
+
+		writeICalLine(out, "BEGIN:VEVENT")
+		writeICalLine(out, "UID:"+icalUID(rec))
+		writeICalLine(out, "DTSTAMP:"+stamp)
+		writeICalLine(out, "DTSTART;VALUE=DATE:"+expiryDay.Format(icalDateFormat))
+		writeICalLine(out, "DTEND;VALUE=DATE:"+expiryDay.AddDate(0, 0, 1).Format(icalDateFormat))
+		writeICalLine(out, "SUMMARY:"+icalEscape(fmt.Sprintf("Certificate expires: %s (%s)", rec.Source, renewal)))
+		writeICalLine(out, "DESCRIPTION:"+icalEscape(description))
+		writeICalLine(out, "TRANSP:TRANSPARENT")
+		writeICalLine(out, "END:VEVENT")
+	}
+
+	writeICalLine(out, "END:VCALENDAR")
+	return out.Flush()
+}
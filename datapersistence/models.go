@@ -1,6 +1,8 @@
 package datapersistence
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -12,20 +14,295 @@ const (
 	WithinRange
 	NearExpiry
 	AfterExpiry
-	TooLongForChrome
+	ExceedsMaxLifetime
+	StaleCertInUse
+	Critical
+	// HasWarnings and HasCriticalFindings are the results of certs whose worst findings, e.g. from the crypto policy
+	// checks, aren't about any of the results above
+	HasWarnings
+	HasCriticalFindings
+	// NotCompared is the result of probing an endpoint that has no stored cert to compare the one it presents with
+	NotCompared
 )
 
+func (r ValidationResult) String() string {
+	switch r {
+	case Errored:
+		return "errored"
+	case NotValidYet:
+		return "not valid yet"
+	case WithinRange:
+		return "within range"
+	case NearExpiry:
+		return "near expiry"
+	case AfterExpiry:
+		return "expired"
+	case ExceedsMaxLifetime:
+		return "exceeds max lifetime"
+	case StaleCertInUse:
+		return "stale cert in use"
+	case Critical:
+		return "critical"
+	case HasWarnings:
+		return "has warnings"
+	case HasCriticalFindings:
+		return "has critical findings"
+	case NotCompared:
+		return "not compared"
+	default:
+		return "unknown"
+	}
+}
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+/**
+a single problem found with a certificate, e.g. by one of the crypto policy checks.  Findings about the certificate's
+dates, or that it couldn't be read, also carry the ValidationResult they correspond to; for the rest Result is nil.
+*/
+type Finding struct {
+	Code     string            `json:"code"`
+	Severity Severity          `json:"severity"`
+	Result   *ValidationResult `json:"result,omitempty"`
+	Message  string            `json:"message"`
+}
+
+/**
+for filling in Finding.Result
+*/
+func ResultOf(result ValidationResult) *ValidationResult {
+	return &result
+}
+
+type SourceKind string
+
+const (
+	SourceSecret  SourceKind = "Secret"
+	SourceService SourceKind = "Service"
+	SourceIngress SourceKind = "Ingress"
+	SourceFile    SourceKind = "File"
+	// SourceGateway is a Gateway API Gateway; SourceIstioGateway is an Istio one
+	SourceGateway      SourceKind = "Gateway"
+	SourceIstioGateway SourceKind = "IstioGateway"
+)
+
+/**
+identifies the object that owns a certificate source, e.g. the cert-manager Certificate that issued a Secret
+*/
+type OwnerReference struct {
+	ApiVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+/**
+describes where a certificate was found: what kind of object it came from, which namespace and name that object
+has and which data key within it held the certificate.  For keystores and kubeconfigs, Alias is the entry the
+certificate came from; for kubeconfigs Contexts lists the contexts that use that entry.
+*/
+type SourceDescriptor struct {
+	Kind      SourceKind      `json:"kind"`
+	Namespace string          `json:"namespace,omitempty"`
+	Name      string          `json:"name"`
+	DataKey   string          `json:"dataKey,omitempty"`
+	Alias     string          `json:"alias,omitempty"`
+	Contexts  []string        `json:"contexts,omitempty"`
+	Owner     *OwnerReference `json:"owner,omitempty"`
+}
+
+// KubeconfigUserAliasPrefix starts the Alias of a certificate that is a kubeconfig user's client certificate
+const KubeconfigUserAliasPrefix = "user/"
+
+/**
+whether the certificate is one that is only ever presented to a server, rather than served, which at the moment
+means the client certificate of a kubeconfig user
+*/
+func (s SourceDescriptor) IsClientCert() bool {
+	return strings.HasPrefix(s.Alias, KubeconfigUserAliasPrefix)
+}
+
+func (s SourceDescriptor) String() string {
+	var base string
+	switch {
+	case s.Kind == SourceFile:
+		base = fmt.Sprintf("%s#%s", s.Name, s.DataKey)
+	case s.Namespace == "":
+		base = fmt.Sprintf("%s/%s", s.Kind, s.Name)
+	default:
+		base = fmt.Sprintf("%s:%s", s.Namespace, s.Name)
+	}
+	if s.Alias != "" {
+		if s.Kind == SourceFile {
+			return fmt.Sprintf("%s[%s]", base, s.Alias)
+		}
+		return fmt.Sprintf("%s[%s/%s]", base, s.DataKey, s.Alias)
+	}
+	return base
+}
+
+/**
+something that uses the secret a certificate is stored in, e.g. a Deployment that mounts it or an Ingress that
+serves it
+*/
+type Consumer struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (c Consumer) String() string {
+	return fmt.Sprintf("%s %s/%s", c.Kind, c.Namespace, c.Name)
+}
+
 type CheckRecord struct {
-	Namespace        string           `json:"namespace"`
-	SecretName       string           `json:"secretName"`
-	CheckedAt        time.Time        `json:"checkedAt"`
-	CheckResult      ValidationResult `json:"result"`
-	ValidUntil       time.Time        `json:"validUntil"`
-	PercentUsed      float64          `json:"percentUsed"`
-	TooLongForChrome bool             `json:"tooLongForChrome"`
+	Cluster            string           `json:"cluster,omitempty"`
+	Namespace          string           `json:"namespace"`
+	SecretName         string           `json:"secretName"`
+	Source             SourceDescriptor `json:"source"`
+	Owner              string           `json:"owner,omitempty"`
+	CheckedAt          time.Time        `json:"checkedAt"`
+	CheckResult        ValidationResult `json:"result"`
+	Severity           Severity         `json:"severity"`
+	ValidUntil         time.Time        `json:"validUntil"`
+	Fingerprint        string           `json:"fingerprint,omitempty"`
+	Identity           string           `json:"identity,omitempty"`
+	PublicKeyHash      string           `json:"publicKeyHash,omitempty"`
+	IsCA               bool             `json:"isCA,omitempty"`
+	PercentUsed        float64          `json:"percentUsed"`
+	ExceedsMaxLifetime bool             `json:"exceedsMaxLifetime"`
+	LifetimePolicy     string           `json:"lifetimePolicy,omitempty"`
+	TriggeredThreshold string           `json:"triggeredThreshold,omitempty"`
+	Findings           []Finding        `json:"findings,omitempty"`
+	Consumers          []Consumer       `json:"consumers,omitempty"`
+}
+
+// the codes of the findings about a certificate's dates
+const (
+	NearExpiryFinding     = "NearExpiry"
+	CriticalExpiryFinding = "CriticalExpiry"
+	ExpiredFinding        = "Expired"
+	NotValidYetFinding    = "NotValidYet"
+)
+
+/**
+the record's date-based result: NearExpiry, Critical, AfterExpiry or NotValidYet if it has the matching finding,
+otherwise WithinRange.  Unlike CheckResult this isn't hidden by a more severe finding about something else, such as a
+weak key, so it is what anything to do with expiry should go by.  Records without a date finding fall back to
+CheckResult if that is date-based, e.g. ones from reports written before there were findings
+*/
+func (r *CheckRecord) ExpiryResult() ValidationResult {
+	for _, finding := range r.Findings {
+		switch finding.Code {
+		case NearExpiryFinding:
+			return NearExpiry
+		case CriticalExpiryFinding:
+			return Critical
+		case ExpiredFinding:
+			return AfterExpiry
+		case NotValidYetFinding:
+			return NotValidYet
+		}
+	}
+	switch r.CheckResult {
+	case NearExpiry, Critical, AfterExpiry, NotValidYet:
+		return r.CheckResult
+	default:
+		return WithinRange
+	}
+}
+
+/**
+the outcome of dialling a live TLS endpoint and comparing the certificate it presented with the one stored in the
+secret that is supposed to be serving it.  CheckResult is WithinRange if they match, StaleCertInUse if they don't,
+NotCompared if there is no secret to compare with and Errored if the endpoint could not be probed.
+*/
+type ProbeRecord struct {
+	Cluster              string            `json:"cluster,omitempty"`
+	Source               SourceDescriptor  `json:"source"`
+	Endpoint             string            `json:"endpoint"`
+	ServerName           string            `json:"serverName"`
+	Secret               *SourceDescriptor `json:"secret,omitempty"`
+	CheckedAt            time.Time         `json:"checkedAt"`
+	CheckResult          ValidationResult  `json:"result"`
+	PresentedFingerprint string            `json:"presentedFingerprint,omitempty"`
+	PresentedValidUntil  time.Time         `json:"presentedValidUntil"`
+	StoredFingerprint    string            `json:"storedFingerprint,omitempty"`
+	StoredValidUntil     time.Time         `json:"storedValidUntil"`
+	Error                string            `json:"error,omitempty"`
+}
+
+/**
+the outcome of checking a TLS listener on a Gateway API or Istio Gateway against one of the secrets it refers to.
+Source is the Gateway, with the listener's name as its DataKey.  CheckResult is Errored if the secret can't be used,
+e.g. because it is missing or in another namespace without a ReferenceGrant, HasCriticalFindings if there is another
+critical problem, such as a listener hostname that the cert doesn't cover, and WithinRange otherwise; Findings lists
+the problems
+*/
+type GatewayTLSRecord struct {
+	Cluster     string           `json:"cluster,omitempty"`
+	Source      SourceDescriptor `json:"source"`
+	Hostnames   []string         `json:"hostnames,omitempty"`
+	Secret      SourceDescriptor `json:"secret"`
+	CheckedAt   time.Time        `json:"checkedAt"`
+	CheckResult ValidationResult `json:"result"`
+	Severity    Severity         `json:"severity"`
+	Fingerprint string           `json:"fingerprint,omitempty"`
+	ValidUntil  time.Time        `json:"validUntil"`
+	Findings    []Finding        `json:"findings,omitempty"`
+	Error       string           `json:"error,omitempty"`
+}
+
+/**
+records something that certchecker did (or, in a dry run, would have done) to fix a certificate, e.g. re-issuing it.
+Details describes the outcome, such as the name of the backup that was taken; Error is set if the action failed
+*/
+type RemediationRecord struct {
+	Cluster       string           `json:"cluster,omitempty"`
+	Source        SourceDescriptor `json:"source"`
+	Action        string           `json:"action"`
+	At            time.Time        `json:"at"`
+	DryRun        bool             `json:"dryRun,omitempty"`
+	NewValidUntil *time.Time       `json:"newValidUntil,omitempty"`
+	Details       string           `json:"details,omitempty"`
+	Error         string           `json:"error,omitempty"`
+}
+
+/**
+summarises the scan of a single cluster. Error is set if the cluster could not be scanned at all
+*/
+type ClusterStatus struct {
+	Name         string `json:"name"`
+	Certificates int    `json:"certificates"`
+	Error        string `json:"error,omitempty"`
 }
 
 type PersistenceRecord struct {
-	CheckedAt time.Time     `json:"checkedAt"`
-	Results   []CheckRecord `json:"results"`
+	CheckedAt    time.Time           `json:"checkedAt"`
+	AsOf         *time.Time          `json:"asOf,omitempty"`
+	Cluster      string              `json:"cluster,omitempty"`
+	Clusters     []ClusterStatus     `json:"clusters,omitempty"`
+	Results      []CheckRecord       `json:"results"`
+	Probes       []ProbeRecord       `json:"probes,omitempty"`
+	GatewayTLS   []GatewayTLSRecord  `json:"gatewayTLS,omitempty"`
+	Duplicates   *Duplicates         `json:"duplicates,omitempty"`
+	Remediations []RemediationRecord `json:"remediations,omitempty"`
 }
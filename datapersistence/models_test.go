@@ -0,0 +1,26 @@
+package datapersistence
+
+import "testing"
+
+func TestExpiryResult(t *testing.T) {
+	weakAndExpiring := CheckRecord{
+		CheckResult: HasCriticalFindings,
+		Findings: []Finding{
+			{Code: NearExpiryFinding, Severity: SeverityWarning, Result: ResultOf(NearExpiry)},
+			{Code: "WeakRSAKey", Severity: SeverityCritical},
+		},
+	}
+	if result := weakAndExpiring.ExpiryResult(); result != NearExpiry {
+		t.Errorf("expected a critical policy finding not to hide the expiry, got %s", result)
+	}
+
+	policyOnly := CheckRecord{CheckResult: HasCriticalFindings, Findings: []Finding{{Code: "WeakRSAKey", Severity: SeverityCritical}}}
+	if result := policyOnly.ExpiryResult(); result != WithinRange {
+		t.Errorf("expected a cert with no date findings to be within range, got %s", result)
+	}
+
+	old := CheckRecord{CheckResult: AfterExpiry}
+	if result := old.ExpiryResult(); result != AfterExpiry {
+		t.Errorf("expected a record without findings to fall back to its result, got %s", result)
+	}
+}
@@ -0,0 +1,65 @@
+package datapersistence
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+/**
+reads back a report that was written by WriteReport or WriteData
+*/
+func ReadReport(filename string) (*PersistenceRecord, error) {
+	content, readErr := ioutil.ReadFile(filename)
+	if readErr != nil {
+		return nil, readErr
+	}
+	var report PersistenceRecord
+	unmarshalErr := json.Unmarshal(content, &report)
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return &report, nil
+}
+
+var ErrNoReports = errors.New("no data available")
+
+/**
+reads the most recently written report in `basepath`, skipping over any that can't be read and any `-as-of`
+forecasts, which don't show the current state.  Returns ErrNoReports if there aren't any.
+*/
+func ReadLatestReport(basepath string) (*PersistenceRecord, error) {
+	contents, readErr := ioutil.ReadDir(basepath)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	files := make([]os.FileInfo, 0)
+	for _, entry := range contents {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, entry)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+
+	for _, entry := range files {
+		filename := path.Join(basepath, entry.Name())
+		report, readErr := ReadReport(filename)
+		if readErr != nil {
+			log.Printf("ERROR could not read report '%s': %s", filename, readErr)
+			continue
+		}
+		if report.AsOf != nil {
+			continue
+		}
+		return report, nil
+	}
+	return nil, ErrNoReports
+}
@@ -0,0 +1,44 @@
+package datapersistence
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func writeTestReport(t *testing.T, dir string, name string, report *PersistenceRecord, modTime time.Time) {
+	content, _ := json.Marshal(report)
+	filename := path.Join(dir, name)
+	if err := ioutil.WriteFile(filename, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadLatestReportSkipsForecasts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "reports")
+	defer os.RemoveAll(dir)
+
+	checkedAt := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	asOf := checkedAt.AddDate(0, 2, 0)
+	writeTestReport(t, dir, "current.json", &PersistenceRecord{CheckedAt: checkedAt, Cluster: "current"}, checkedAt)
+	writeTestReport(t, dir, "forecast.json", &PersistenceRecord{CheckedAt: checkedAt, AsOf: &asOf, Cluster: "forecast"}, checkedAt.Add(time.Hour))
+
+	latest, err := ReadLatestReport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Cluster != "current" {
+		t.Errorf("expected the newer forecast to be skipped, got the %s report", latest.Cluster)
+	}
+
+	os.Remove(path.Join(dir, "current.json"))
+	if _, err := ReadLatestReport(dir); err != ErrNoReports {
+		t.Errorf("expected ErrNoReports when there are only forecasts, got %v", err)
+	}
+}
@@ -62,17 +62,22 @@ writes a record of the scan results to a json file with a unique name,
 that is in the directory `basepath`
 */
 func WriteData(basepath string, results *[]CheckRecord) error {
+	return WriteReport(basepath, &PersistenceRecord{
+		CheckedAt: time.Now(),
+		Results:   *results,
+	})
+}
+
+/**
+writes the given report to a json file with a unique name, that is in the directory `basepath`
+*/
+func WriteReport(basepath string, finalReport *PersistenceRecord) error {
 	filename, filenameErr := getFilename(basepath, 32768)
 	if filenameErr != nil {
 		log.Printf("ERROR WriteData could not get a filename to write to: %s", filenameErr)
 		return filenameErr
 	}
 
-	finalReport := PersistenceRecord{
-		CheckedAt: time.Now(),
-		Results:   *results,
-	}
-
 	encodedContent, marshalErr := json.Marshal(finalReport)
 	if marshalErr != nil {
 		log.Printf("ERROR WriteData was passed invalid content: %s", marshalErr)
@@ -6,8 +6,10 @@ require (
 	github.com/form3tech-oss/jwt-go v3.2.3+incompatible
 	github.com/go-openapi/spec v0.19.3 // indirect
 	github.com/h2non/filetype v1.1.1
+	golang.org/x/crypto v0.0.0-20220331220935-ae2d96664a29
 	gopkg.in/errgo.v2 v2.1.0
 	k8s.io/api v0.22.0
 	k8s.io/apimachinery v0.22.0
 	k8s.io/client-go v0.22.0
+	software.sslmate.com/src/go-pkcs12 v0.2.0
 )
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"github.com/guardian/k8s-certchecker/webserver/helpers"
+	"log"
+	"net/http"
+)
+
+/**
+serves an iCalendar feed of certificate expiry dates from the latest report.
+
+calendar clients can't send a bearer token, so as well as the usual login the feed can be fetched with
+`?token=<CalendarToken>` if CalendarToken is set
+*/
+type CalendarHandler struct {
+	Store                ReportStore
+	OAuthSigningCertPath string
+	CalendarToken        string
+}
+
+func (h CalendarHandler) authorised(request *http.Request) (string, error) {
+	token := request.URL.Query().Get("token")
+	if h.CalendarToken != "" && token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.CalendarToken)) == 1 {
+			return "calendar subscriber", nil
+		}
+	}
+	return helpers.ValidateLogin(request, h.OAuthSigningCertPath)
+}
+
+func (h CalendarHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	if !helpers.AssertHttpMethod(request, w, "GET") {
+		return
+	}
+
+	username, validationErr := h.authorised(request)
+	if validationErr != nil {
+		log.Printf("ERROR CalendarHandler could not validate request: %s", validationErr)
+		response := helpers.GenericErrorResponse{
+			Status: "forbidden",
+			Detail: validationErr.Error(),
+		}
+		helpers.WriteJsonContent(response, w, 403)
+		return
+	}
+
+	log.Printf("Serving calendar request to %s", username)
+
+	report, loadErr := h.Store.Latest(request.Context())
+	if loadErr != nil {
+		log.Printf("ERROR CalendarHandler could not load a report: %s", loadErr)
+		response := helpers.GenericErrorResponse{
+			Status: "error",
+			Detail: "no data available",
+		}
+		helpers.WriteJsonContent(response, w, 404)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Add("Content-Disposition", "inline; filename=\"certificate-expiries.ics\"")
+	w.WriteHeader(200)
+	writeErr := datapersistence.WriteICalendar(w, report)
+	if writeErr != nil {
+		log.Printf("Could not write calendar to HTTP socket: %s", writeErr)
+	}
+}
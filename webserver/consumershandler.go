@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"github.com/guardian/k8s-certchecker/webserver/helpers"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+/**
+serves each secret in the latest report with the workloads, ingresses and gateways that use it. Query parameters:
+- unused: `true` to list only the secrets that nothing uses, which may be candidates for cleaning up
+- namespace: only list the secrets in this namespace
+*/
+type ConsumersHandler struct {
+	Store                ReportStore
+	OAuthSigningCertPath string
+}
+
+func (h ConsumersHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	if request.Body != nil {
+		defer request.Body.Close()
+	}
+
+	if !helpers.AssertHttpMethod(request, w, "GET") {
+		io.Copy(ioutil.Discard, request.Body) //discard any remaining body
+		return
+	}
+
+	username, validationErr := helpers.ValidateLogin(request, h.OAuthSigningCertPath)
+	if validationErr != nil {
+		log.Printf("ERROR ConsumersHandler could not validate request: %s", validationErr)
+		response := helpers.GenericErrorResponse{
+			Status: "forbidden",
+			Detail: validationErr.Error(),
+		}
+		helpers.WriteJsonContent(response, w, 403)
+		return
+	}
+
+	query := request.URL.Query()
+	unusedOnly := false
+	switch query.Get("unused") {
+	case "", "false":
+	case "true":
+		unusedOnly = true
+	default:
+		response := helpers.InvalidOptionResponse{
+			Status:  "error",
+			Detail:  "unused must be true or false",
+			Options: []string{"true", "false"},
+		}
+		helpers.WriteJsonContent(response, w, 400)
+		return
+	}
+
+	log.Printf("Serving consumers request to %s", username)
+
+	report, loadErr := h.Store.Latest(request.Context())
+	if loadErr != nil {
+		log.Printf("ERROR ConsumersHandler could not load a report: %s", loadErr)
+		response := helpers.GenericErrorResponse{
+			Status: "error",
+			Detail: "no data available",
+		}
+		helpers.WriteJsonContent(response, w, 404)
+		return
+	}
+
+	helpers.WriteJsonContent(datapersistence.BuildSecretUsage(report, unusedOnly, query.Get("namespace")), w, 200)
+}
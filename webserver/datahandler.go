@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/guardian/k8s-certchecker/datapersistence"
 	"github.com/guardian/k8s-certchecker/webserver/helpers"
 	"io"
 	"io/ioutil"
@@ -12,8 +13,12 @@ import (
 	"strings"
 )
 
+/**
+serves the latest report.  Reports are read straight from DataRoot if it is set, otherwise from Store
+*/
 type DataHandler struct {
 	DataRoot             string
+	Store                ReportStore
 	OAuthSigningCertPath string
 }
 
@@ -58,9 +63,14 @@ func findReports(dataRoot string) ([]string, error) {
 
 	sort.Sort(dirSlice(files))
 
-	filenames := make([]string, len(files))
-	for i, entry := range files {
-		filenames[i] = path.Join(dataRoot, entry.Name())
+	filenames := make([]string, 0, len(files))
+	for _, entry := range files {
+		filename := path.Join(dataRoot, entry.Name())
+		//-as-of forecasts don't show the current state, so they aren't served as the latest report
+		if report, readErr := datapersistence.ReadReport(filename); readErr == nil && report.AsOf != nil {
+			continue
+		}
+		filenames = append(filenames, filename)
 	}
 	return filenames, nil
 }
@@ -101,6 +111,21 @@ func (h DataHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
 
 	log.Printf("Serving data request to %s", username)
 
+	if h.DataRoot == "" {
+		report, loadErr := h.Store.Latest(request.Context())
+		if loadErr != nil {
+			log.Printf("ERROR DataHandler could not load a report: %s", loadErr)
+			response := helpers.GenericErrorResponse{
+				Status: "error",
+				Detail: "no data available",
+			}
+			helpers.WriteJsonContent(response, w, 404)
+			return
+		}
+		helpers.WriteJsonContent(report, w, 200)
+		return
+	}
+
 	reports, listErr := findReports(h.DataRoot)
 	if listErr != nil {
 		log.Printf("ERROR DataHandler could not list data root '%s': %s", h.DataRoot, listErr)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"github.com/guardian/k8s-certchecker/webserver/helpers"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+/**
+serves the certificates in the latest report that are copied into more than one place, the copies that were missed
+when a cert was renewed and the private keys that are shared between certs
+*/
+type DuplicatesHandler struct {
+	Store                ReportStore
+	OAuthSigningCertPath string
+}
+
+func (h DuplicatesHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	if request.Body != nil {
+		defer request.Body.Close()
+	}
+
+	if !helpers.AssertHttpMethod(request, w, "GET") {
+		io.Copy(ioutil.Discard, request.Body) //discard any remaining body
+		return
+	}
+
+	username, validationErr := helpers.ValidateLogin(request, h.OAuthSigningCertPath)
+	if validationErr != nil {
+		log.Printf("ERROR DuplicatesHandler could not validate request: %s", validationErr)
+		response := helpers.GenericErrorResponse{
+			Status: "forbidden",
+			Detail: validationErr.Error(),
+		}
+		helpers.WriteJsonContent(response, w, 403)
+		return
+	}
+
+	log.Printf("Serving duplicates request to %s", username)
+
+	report, loadErr := h.Store.Latest(request.Context())
+	if loadErr != nil {
+		log.Printf("ERROR DuplicatesHandler could not load a report: %s", loadErr)
+		response := helpers.GenericErrorResponse{
+			Status: "error",
+			Detail: "no data available",
+		}
+		helpers.WriteJsonContent(response, w, 404)
+		return
+	}
+
+	//worked out from the results rather than read from the report, so that this also works for reports that were
+	//made without -find-duplicates
+	helpers.WriteJsonContent(datapersistence.FindDuplicates(report.Results), w, 200)
+}
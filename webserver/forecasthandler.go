@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"github.com/guardian/k8s-certchecker/webserver/helpers"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/**
+serves an expiry forecast built from the latest report. Query parameters:
+- period: `month` (the default) or `week`
+- months: how many months ahead to forecast, default 12
+*/
+type ForecastHandler struct {
+	Store                ReportStore
+	OAuthSigningCertPath string
+}
+
+func (h ForecastHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	if request.Body != nil {
+		defer request.Body.Close()
+	}
+
+	if !helpers.AssertHttpMethod(request, w, "GET") {
+		io.Copy(ioutil.Discard, request.Body) //discard any remaining body
+		return
+	}
+
+	username, validationErr := helpers.ValidateLogin(request, h.OAuthSigningCertPath)
+	if validationErr != nil {
+		log.Printf("ERROR ForecastHandler could not validate request: %s", validationErr)
+		response := helpers.GenericErrorResponse{
+			Status: "forbidden",
+			Detail: validationErr.Error(),
+		}
+		helpers.WriteJsonContent(response, w, 403)
+		return
+	}
+
+	query := request.URL.Query()
+	period, periodErr := datapersistence.ParseForecastPeriod(query.Get("period"))
+	if periodErr != nil {
+		response := helpers.InvalidOptionResponse{
+			Status:  "error",
+			Detail:  periodErr.Error(),
+			Options: []string{string(datapersistence.ForecastMonth), string(datapersistence.ForecastWeek)},
+		}
+		helpers.WriteJsonContent(response, w, 400)
+		return
+	}
+	months := 12
+	if monthsParam := query.Get("months"); monthsParam != "" {
+		var parseErr error
+		months, parseErr = strconv.Atoi(monthsParam)
+		if parseErr != nil || months < 1 || months > 60 {
+			response := helpers.GenericErrorResponse{
+				Status: "error",
+				Detail: "months must be a number between 1 and 60",
+			}
+			helpers.WriteJsonContent(response, w, 400)
+			return
+		}
+	}
+
+	log.Printf("Serving forecast request to %s", username)
+
+	report, loadErr := h.Store.Latest(request.Context())
+	if loadErr != nil {
+		log.Printf("ERROR ForecastHandler could not load a report: %s", loadErr)
+		response := helpers.GenericErrorResponse{
+			Status: "error",
+			Detail: "no data available",
+		}
+		helpers.WriteJsonContent(response, w, 404)
+		return
+	}
+
+	now := time.Now()
+	forecast := datapersistence.BuildForecast(report, now, now.AddDate(0, months, 0), period)
+	helpers.WriteJsonContent(forecast, w, 200)
+}
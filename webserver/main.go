@@ -23,16 +23,46 @@ func getRootFromEnviron(key string) string {
 
 func main() {
 	htmlRoot := getRootFromEnviron("HTML_ROOT")
-	dataRoot := getRootFromEnviron("DATA_ROOT")
+	//REPORT_SOURCE=api reads the CertificateReport resources in the cluster instead of files in DATA_ROOT
+	var dataRoot string
+	var store ReportStore
+	if os.Getenv("REPORT_SOURCE") == "api" {
+		store = newAPIReportStore()
+	} else {
+		dataRoot = getRootFromEnviron("DATA_ROOT")
+		store = FileReportStore{DataRoot: dataRoot}
+	}
 	indexHandler := IndexHandler{HtmlRoot: htmlRoot}
 	staticHandler := StaticFilesHandler{basePath: htmlRoot, uriTrim: 2} //assuming htmlRoot points to the /static foler
 	dataHandler := DataHandler{
 		DataRoot:             dataRoot,
+		Store:                store,
+		OAuthSigningCertPath: os.Getenv("SIGNING_CERT"),
+	}
+	forecastHandler := ForecastHandler{
+		Store:                store,
+		OAuthSigningCertPath: os.Getenv("SIGNING_CERT"),
+	}
+	consumersHandler := ConsumersHandler{
+		Store:                store,
+		OAuthSigningCertPath: os.Getenv("SIGNING_CERT"),
+	}
+	duplicatesHandler := DuplicatesHandler{
+		Store:                store,
+		OAuthSigningCertPath: os.Getenv("SIGNING_CERT"),
+	}
+	calendarHandler := CalendarHandler{
+		Store:                store,
 		OAuthSigningCertPath: os.Getenv("SIGNING_CERT"),
+		CalendarToken:        os.Getenv("CALENDAR_TOKEN"),
 	}
 	healthcheck := HealthcheckHandler{}
 
 	http.Handle("/api/latest", dataHandler)
+	http.Handle("/api/forecast", forecastHandler)
+	http.Handle("/api/consumers", consumersHandler)
+	http.Handle("/api/duplicates", duplicatesHandler)
+	http.Handle("/api/calendar.ics", calendarHandler)
 	http.Handle("/healthcheck", healthcheck)
 	http.Handle("/static/", staticHandler)
 	http.Handle("/", indexHandler)
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"github.com/guardian/k8s-certchecker/datapersistence"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"log"
+	"os"
+)
+
+/**
+somewhere that the latest certchecker report can be loaded from
+*/
+type ReportStore interface {
+	Latest(ctx context.Context) (*datapersistence.PersistenceRecord, error)
+}
+
+/**
+reads the reports that certchecker writes to the shared volume in DATA_ROOT
+*/
+type FileReportStore struct {
+	DataRoot string
+}
+
+func (s FileReportStore) Latest(ctx context.Context) (*datapersistence.PersistenceRecord, error) {
+	return datapersistence.ReadLatestReport(s.DataRoot)
+}
+
+/**
+reads the CertificateReport resources that certchecker writes with -write-reports
+*/
+type APIReportStore struct {
+	Client dynamic.Interface
+}
+
+func (s APIReportStore) Latest(ctx context.Context) (*datapersistence.PersistenceRecord, error) {
+	return datapersistence.ReadReportFromAPI(ctx, s.Client)
+}
+
+/**
+builds a store that reads from the cluster we are running in, or from the cluster in $KUBECONFIG when run outside one
+*/
+func newAPIReportStore() ReportStore {
+	restConfig, configErr := rest.InClusterConfig()
+	if configErr != nil {
+		log.Printf("INFO Could not get in-cluster configuration: %s, falling back to KUBECONFIG", configErr)
+		restConfig, configErr = clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
+		if configErr != nil {
+			log.Fatalf("Could not get a configuration to read reports from the cluster: %s", configErr)
+		}
+	}
+	client, clientErr := dynamic.NewForConfig(restConfig)
+	if clientErr != nil {
+		log.Fatalf("Could not create a client to read reports from the cluster: %s", clientErr)
+	}
+	return APIReportStore{Client: client}
+}